@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
+	"maps"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -124,7 +125,7 @@ func CompareBOM(expectedStruct *unstructured.Unstructured, actualStruct *unstruc
 	}
 
 	if comparisonError == true {
-		fmt.Errorf("Error: Found mismatches between expetced BOM and actual cluster BOM")
+		fmt.Println("Error: Found mismatches between expected BOM and actual cluster BOM")
 	} else {
 		fmt.Println("No errors found during BOM comparison.")
 	}
@@ -142,23 +143,51 @@ func ExtractBOMToMap(data *unstructured.Unstructured, dataType string) map[strin
 
 		properties := component["properties"].([]interface{})
 		if bomType == dataType { //Add to respective map for application or container type
+			var namespaces []string
 			for _, props := range properties {
 				itemKey := props.(map[string]interface{})["name"].(string)
-				itemValue := props.(map[string]interface{})["value"].(string)
-				innerContainerMap[itemKey] = itemValue
+				itemValues := propertyValues(props.(map[string]interface{})["value"])
+				innerContainerMap[itemKey] = strings.Join(itemValues, ",")
+				if itemKey == BOM_PROPERTY_CONTAINER_NAMESPACE {
+					namespaces = itemValues
+				}
 			}
 			innerContainerMap[BOM_PROPERTY_NAME] = component["name"].(string)
 			innerContainerMap[BOM_PROPERTY_VERSION] = component["version"].(string)
-			if dataType == CONTAINER {
-				purl = innerContainerMap[BOM_PROPERTY_CONTAINER_NAMESPACE] + "/" + ecrRegex.ReplaceAllString(name, "*")
+			if dataType != CONTAINER {
+				dataMap[purl] = innerContainerMap
+				continue
+			}
+			// An image is a single component for every namespace it runs in, compare it in each of them
+			if len(namespaces) == 0 {
+				namespaces = []string{""}
+			}
+			for _, namespace := range namespaces {
+				namespacedMap := maps.Clone(innerContainerMap)
+				namespacedMap[BOM_PROPERTY_CONTAINER_NAMESPACE] = namespace
+				dataMap[namespace+"/"+ecrRegex.ReplaceAllString(name, "*")] = namespacedMap
 			}
-			dataMap[purl] = innerContainerMap
 		}
 	}
 
 	return dataMap
 }
 
+// propertyValues returns the value of a property, which is a string or an array of strings
+func propertyValues(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return nil
+}
+
 func CompareBOMData(expected map[string]map[string]string, actual map[string]map[string]string) ([]ComponentData, []ComponentData) {
 	var warnMismatching []ComponentData
 	var errorMismatching []ComponentData
@@ -65,6 +65,33 @@ var _ = Describe("extractBOMToMap - Unit", Label("unit"), func() {
 			Expect(result[applicationPurl]).To(HaveKeyWithValue("clx:k8s:componentKind", "FlowSchema"))
 		})
 
+		It("should compare an image in each namespace it runs in", func() {
+			data := &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"components": []interface{}{
+						map[string]interface{}{
+							"type":    "container",
+							"purl":    "pkg:oci/etcd@sha256:24bc64e911039ecf00e263be2161797c758b7d82403ca5516ab64047a477f737?repository_url=registry.k8s.io%2Fetcd",
+							"name":    "registry.k8s.io/etcd",
+							"version": "3.5.7-0",
+							"properties": []interface{}{
+								map[string]interface{}{"name": "clx:k8s:componentKind", "value": "Image"},
+								map[string]interface{}{"name": "clx:k8s:componentNamespace", "value": []interface{}{"default", "kube-system"}},
+								map[string]interface{}{"name": "clx:k8s:source", "value": []interface{}{"init", "main"}},
+							},
+						},
+					},
+				},
+			}
+
+			result := ExtractBOMToMap(data, "container")
+
+			Expect(result).To(HaveLen(2))
+			Expect(result["default/registry.k8s.io/etcd"]).To(HaveKeyWithValue(BOM_PROPERTY_CONTAINER_NAMESPACE, "default"))
+			Expect(result["kube-system/registry.k8s.io/etcd"]).To(HaveKeyWithValue(BOM_PROPERTY_CONTAINER_NAMESPACE, "kube-system"))
+			Expect(result["kube-system/registry.k8s.io/etcd"]).To(HaveKeyWithValue("clx:k8s:source", "init,main"))
+		})
+
 		It("should return empty for invalid dataType", func() {
 			result := ExtractBOMToMap(data, "invalid")
 			Expect(result).To(BeEmpty())
@@ -0,0 +1,392 @@
+package cmd
+
+import (
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"context"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var filterInitPath string
+
+var FilterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Work with the filter files of --filter-path",
+}
+
+var FilterValidateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Check a YAML or JSON filter file for mistakes",
+	Long: `Check a YAML or JSON filter file for mistakes, and report every unknown field and wrong type with its line, or
+else the first invalid pattern or selector.
+
+	Example usage: clx filter validate filter.yaml`,
+	Args: cobra.ExactArgs(1),
+	// The mistakes in the file are the output, so don't repeat them or the usage
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE:          validateFilter,
+}
+
+var FilterSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the filter files",
+	Long: `Print the JSON Schema of the filter files, for editors to complete and check them.
+
+	Example usage: clx filter schema > filter.schema.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		_, err := cmd.OutOrStdout().Write(model.FilterSchema)
+		return err
+	},
+}
+
+var FilterInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a starter filter file from the kinds and namespaces of the provided K8s cluster",
+	Long: `Write a starter filter file from the kinds and namespaces found in the provided K8s cluster, with the number of
+objects of each as comments. The kinds that change all the time, like Events and Leases, are excluded. Remove what you
+don't need, then preview what the filter includes with clx filter test.
+
+	Example usage: clx filter init --context dev -o filter.yaml`,
+	Args: cobra.NoArgs,
+	RunE: initFilter,
+}
+
+var FilterTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Show what a filter includes and skips in the provided K8s cluster, without writing a BOM",
+	Long: `List the provided K8s cluster the same as clx generate and show, for each resource type and namespace, how many
+objects the filter includes and skips, and the namespaces, Pods and workloads the images are taken from. No BOM is
+written.
+
+	Example usage: clx filter test -i filter.yaml --context dev`,
+	Args: cobra.NoArgs,
+	RunE: testFilter,
+}
+
+func init() {
+	FilterCmd.AddCommand(FilterValidateCmd)
+	FilterCmd.AddCommand(FilterSchemaCmd)
+	FilterCmd.AddCommand(FilterInitCmd)
+	FilterCmd.AddCommand(FilterTestCmd)
+
+	FilterInitCmd.Flags().StringVarP(&filterInitPath, "out-path", "o", "filter.yaml", "Path of the filter file to write, or - for stdout. An existing file is not overwritten.")
+	FilterInitCmd.Flags().IntVarP(&concurrency, "concurrency", "c", k8.DefaultConcurrency, "Number of resource types listed from the cluster in parallel.")
+	addClientFlags(FilterInitCmd, &clientOptions)
+
+	FilterTestCmd.Flags().StringVarP(&filterPath, "filter-path", "i", "", filterPathUsage)
+	FilterTestCmd.Flags().StringVar(&helmDriver, "helm-driver", k8.HelmDriverSecret, helmDriverUsage)
+	FilterTestCmd.Flags().IntVarP(&concurrency, "concurrency", "c", k8.DefaultConcurrency, "Number of resource types listed from the cluster in parallel.")
+	addClientFlags(FilterTestCmd, &clientOptions)
+}
+
+func validateFilter(cmd *cobra.Command, args []string) error {
+	if _, err := model.LoadFilter(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is a valid filter\n", args[0])
+	return nil
+}
+
+// noisyKinds change all the time and are rarely wanted in a BOM, so a starter filter excludes them
+var noisyKinds = []string{"Event", "Lease", "Endpoints", "EndpointSlice", "ControllerRevision"}
+
+func initFilter(cmd *cobra.Command, _ []string) error {
+	out := cmd.OutOrStdout()
+	if filterInitPath != "-" {
+		if _, err := os.Stat(filterInitPath); err == nil {
+			return fmt.Errorf("%s already exists, remove it or choose another --out-path", filterInitPath)
+		}
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := getLiveClient(clientOptions)
+	if err != nil {
+		return err
+	}
+	counts, err := client.CountResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	if filterInitPath != "-" {
+		file, err := os.Create(filterInitPath)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", filterInitPath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := WriteStarterFilter(out, counts, client.K8sContext); err != nil {
+		return err
+	}
+	if filterInitPath != "-" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Starter filter written to %s\n", filterInitPath)
+	}
+	return nil
+}
+
+// WriteStarterFilter writes a YAML filter including every kind and namespace with objects, except for the noisyKinds,
+// with the number of objects of each as comments
+func WriteStarterFilter(w io.Writer, counts []k8.ResourceCount, contextName string) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(starterFilter(counts, contextName)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// starterFilter returns the YAML document of a filter including every kind and namespace with objects, except for the
+// noisyKinds, with the number of objects of each as comments
+func starterFilter(counts []k8.ResourceCount, contextName string) *yaml.Node {
+	groups := make(map[string][]string)
+	for _, count := range counts {
+		if !slices.Contains(groups[count.Kind], count.GVR.Group) {
+			groups[count.Kind] = append(groups[count.Kind], count.GVR.Group)
+		}
+	}
+
+	var clusterKinds, namespacedKinds, excludedKinds []*yaml.Node
+	namespaces := make(k8.NamespaceCounts)
+	for _, count := range counts {
+		total := count.Objects.Total()
+		if count.Err != nil || total == 0 {
+			continue
+		}
+		// A kind served by several groups is told apart by its group. A filter can't name the core group, so the
+		// core kind is left as is, which matches the kind in every group.
+		name := count.Kind
+		if len(groups[count.Kind]) > 1 && count.GVR.Group != "" {
+			name = count.Kind + "." + count.GVR.Group
+		}
+		entry := &yaml.Node{Kind: yaml.ScalarNode, Value: name, LineComment: objectCount(total)}
+		switch {
+		case !count.Namespaced:
+			clusterKinds = append(clusterKinds, entry)
+		case slices.Contains(noisyKinds, count.Kind):
+			excludedKinds = append(excludedKinds, entry)
+		default:
+			namespacedKinds = append(namespacedKinds, entry)
+			for namespace, objects := range count.Objects {
+				namespaces[namespace] += objects
+			}
+		}
+	}
+	var namespaceEntries []*yaml.Node
+	for _, namespace := range namespaces.Namespaces() {
+		namespaceEntries = append(namespaceEntries, &yaml.Node{Kind: yaml.ScalarNode, Value: namespace, LineComment: objectCount(namespaces[namespace])})
+	}
+
+	root := yamlMapping(
+		"non-namespaced-inclusions", yamlMapping("resources", yamlSequence(clusterKinds...)),
+		"namespaced-inclusions", yamlSequence(yamlMapping(
+			"namespaces", yamlSequence(namespaceEntries...),
+			"resources", yamlSequence(namespacedKinds...),
+		)),
+	)
+	if len(excludedKinds) > 0 {
+		exclusions := yamlMapping("namespaced-exclusions", yamlSequence(yamlMapping("resources", yamlSequence(excludedKinds...))))
+		exclusions.Content[0].HeadComment = "Objects that change all the time, rarely wanted in a BOM"
+		root.Content = append(root.Content, exclusions.Content...)
+	}
+	return &yaml.Node{
+		Kind: yaml.DocumentNode,
+		HeadComment: fmt.Sprintf("Starter filter for context %s, with the number of objects of each kind and in each namespace.\n"+
+			"Remove what you don't need, check it with clx filter validate and preview it with clx filter test.", contextName),
+		Content: []*yaml.Node{root},
+	}
+}
+
+func objectCount(count int) string {
+	if count == 1 {
+		return "1 object"
+	}
+	return strconv.Itoa(count) + " objects"
+}
+
+// yamlMapping returns a mapping of the keys to the values, which alternate
+func yamlMapping(keysAndValues ...interface{}) *yaml.Node {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: keysAndValues[i].(string)}, keysAndValues[i+1].(*yaml.Node))
+	}
+	return mapping
+}
+
+func yamlSequence(items ...*yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Content: items}
+}
+
+func testFilter(cmd *cobra.Command, _ []string) error {
+	if err := getInclusionFilter(); err != nil {
+		return err
+	}
+	if err := k8.ValidateHelmDriver(helmDriver); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := getLiveClient(clientOptions)
+	if err != nil {
+		return err
+	}
+	report, namespaces, err := runFilterReport(ctx, client)
+	if err != nil {
+		return err
+	}
+	PrintFilterReport(cmd.OutOrStdout(), report, namespaces)
+	return nil
+}
+
+// runFilterReport lists the cluster the same as GenerateBOM, recording what K8Filter includes and skips. It returns the
+// report and the namespaces found in the cluster.
+func runFilterReport(ctx context.Context, client *k8.K8sClient) (*k8.FilterReport, []string, error) {
+	client.Report = &k8.FilterReport{}
+	_, namespaces, err := client.GetAllComponents(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := client.GetAllImages(ctx, imageNamespaceList(namespaces)); err != nil {
+		return nil, nil, err
+	}
+	return client.Report, namespaces, nil
+}
+
+// PrintFilterReport prints the objects included and skipped of each resource type and namespace, and the namespaces,
+// Pods and workloads the images are taken from
+func PrintFilterReport(w io.Writer, report *k8.FilterReport, namespaces []string) {
+	resources := table.NewWriter()
+	resources.SetOutputMirror(w)
+	resources.SetTitle("Objects")
+	resources.AppendHeader(table.Row{"Resource", "Kind", "Namespace", "Included", "Skipped", "Notes"})
+	resources.SetColumnConfigs([]table.ColumnConfig{
+		{Name: "Included", Align: text.AlignRight, AlignFooter: text.AlignRight},
+		{Name: "Skipped", Align: text.AlignRight, AlignFooter: text.AlignRight},
+	})
+	var included, skipped int
+	for _, resource := range report.Resources {
+		name, kind := resourceName(resource.GVR.Group, resource.GVR.Version, resource.GVR.Resource), resource.Kind
+		notes := listNotes(resource.Purpose, resource.LabelSelector, resource.FieldSelector)
+		if resource.Skip != "" {
+			resources.AppendRow(table.Row{name, resource.Kind, "", "", "", "not listed: " + resource.Skip})
+			continue
+		}
+		if resource.Err != nil {
+			resources.AppendRow(table.Row{name, resource.Kind, "", "", "", "not listed: " + resource.Err.Error()})
+			continue
+		}
+		resourceNamespaces := slices.Compact(slices.Sorted(slices.Values(append(resource.Included.Namespaces(), resource.Skipped.Namespaces()...))))
+		if len(resourceNamespaces) == 0 {
+			resources.AppendRow(table.Row{name, resource.Kind, "", 0, 0, notes})
+		}
+		for _, namespace := range resourceNamespaces {
+			resources.AppendRow(table.Row{name, kind, namespaceName(namespace), resource.Included[namespace], resource.Skipped[namespace], notes})
+			name, kind, notes = "", "", ""
+		}
+		included += resource.Included.Total()
+		skipped += resource.Skipped.Total()
+	}
+	resources.AppendFooter(table.Row{"", "", "", included, skipped, ""})
+	resources.Render()
+
+	images := report.Images
+	imageSources := table.NewWriter()
+	imageSources.SetOutputMirror(w)
+	imageSources.SetTitle("Image sources")
+	imageSources.AppendHeader(table.Row{"Namespace", "Pods included", "Pods skipped", "Workloads included", "Workloads skipped"})
+	for _, namespace := range images.Namespaces {
+		imageSources.AppendRow(table.Row{namespace, images.IncludedPods[namespace], images.SkippedPods[namespace], images.IncludedWorkloads[namespace], images.SkippedWorkloads[namespace]})
+	}
+	imageSources.AppendFooter(table.Row{"", images.IncludedPods.Total(), images.SkippedPods.Total(), images.IncludedWorkloads.Total(), images.SkippedWorkloads.Total()})
+	imageSources.Render()
+	if notes := listNotes("", images.LabelSelector, images.FieldSelector); notes != "" {
+		fmt.Fprintf(w, "Pods: %s\n", notes)
+	}
+	if notListed := slices.DeleteFunc(slices.Clone(namespaces), func(namespace string) bool { return slices.Contains(images.Namespaces, namespace) }); len(notListed) > 0 {
+		fmt.Fprintf(w, "Namespaces the images are not taken from: %s\n", strings.Join(notListed, ", "))
+	}
+}
+
+// PrintQueryPlan prints the resource types the plan lists, in which namespaces and with which selectors, and the ones it
+// skips
+func PrintQueryPlan(w io.Writer, plan k8.QueryPlan) {
+	queries := table.NewWriter()
+	queries.SetOutputMirror(w)
+	queries.SetTitle("Query plan")
+	queries.AppendHeader(table.Row{"Resource", "Kind", "Namespaces", "Requests", "Notes"})
+	queries.SetColumnConfigs([]table.ColumnConfig{{Name: "Requests", Align: text.AlignRight}})
+	appendQuery := func(query k8.ResourceQuery) {
+		name := resourceName(query.GVR.Group, query.GVR.Version, query.GVR.Resource)
+		if query.Skip != "" {
+			queries.AppendRow(table.Row{name, query.Kind, "", 0, "not listed: " + query.Skip})
+			return
+		}
+		namespaces := "(all)"
+		switch {
+		case !query.Namespaced:
+			namespaces = namespaceName("")
+		case query.Namespaces != nil:
+			namespaces = strings.Join(query.Namespaces, ", ")
+		}
+		queries.AppendRow(table.Row{name, query.Kind, namespaces, query.Requests(), listNotes(query.Purpose, query.LabelSelector, query.FieldSelector)})
+	}
+	listed := 0
+	for _, query := range plan.Queries {
+		appendQuery(query)
+		if query.Skip == "" {
+			listed++
+		}
+	}
+	// The Helm releases are read from the Secrets or ConfigMaps they are stored in
+	if plan.HelmReleases.GVR.Resource != "" {
+		appendQuery(plan.HelmReleases)
+	}
+	queries.Render()
+	fmt.Fprintf(w, "Listing %d of %d resource types with %d requests\n", listed, len(plan.Queries), plan.Requests())
+}
+
+// resourceName returns the GVR the way kubectl api-resources --output wide names it, e.g. deployments.v1.apps
+func resourceName(group string, version string, resource string) string {
+	if group == "" {
+		return resource + "." + version
+	}
+	return resource + "." + version + "." + group
+}
+
+func namespaceName(namespace string) string {
+	if namespace == "" {
+		return "(cluster)"
+	}
+	return namespace
+}
+
+// listNotes describes why a resource type the filter includes none of is listed, and the selectors sent to the API
+// server with the list requests
+func listNotes(purpose string, labelSelector string, fieldSelector string) string {
+	var notes []string
+	if purpose != "" {
+		notes = append(notes, "listed for the "+purpose)
+	}
+	if labelSelector != "" {
+		notes = append(notes, "listed with label selector "+labelSelector)
+	}
+	if fieldSelector != "" {
+		notes = append(notes, "listed with field selector "+fieldSelector)
+	}
+	return strings.Join(notes, ", ")
+}
@@ -0,0 +1,119 @@
+package cmd_test
+
+import (
+	"bytes"
+	. "cluster-codex/cmd"
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("WriteStarterFilter - Unit", Label("unit"), func() {
+	It("should write a valid filter of the kinds and namespaces with objects and their counts", func() {
+		counts := []k8.ResourceCount{
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, Kind: "Pod", Namespaced: true, Objects: k8.NamespaceCounts{"default": 3, "kube-system": 1}},
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, Kind: "Namespace", Objects: k8.NamespaceCounts{"": 2}},
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "events"}, Kind: "Event", Namespaced: true, Objects: k8.NamespaceCounts{"default": 40}},
+			{GVR: schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, Kind: "Event", Namespaced: true, Objects: k8.NamespaceCounts{"default": 40}},
+			{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment", Namespaced: true, Objects: k8.NamespaceCounts{"default": 1}},
+			{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, Kind: "StatefulSet", Namespaced: true},
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Kind: "Secret", Namespaced: true, Err: errors.New("forbidden")},
+		}
+		var out bytes.Buffer
+
+		Expect(WriteStarterFilter(&out, counts, "dev")).To(Succeed())
+
+		Expect(out.String()).To(Equal(`# Starter filter for context dev, with the number of objects of each kind and in each namespace.
+# Remove what you don't need, check it with clx filter validate and preview it with clx filter test.
+
+non-namespaced-inclusions:
+  resources:
+    - Namespace # 2 objects
+namespaced-inclusions:
+  - namespaces:
+      - default # 4 objects
+      - kube-system # 1 object
+    resources:
+      - Pod # 4 objects
+      - Deployment # 1 object
+# Objects that change all the time, rarely wanted in a BOM
+namespaced-exclusions:
+  - resources:
+      - Event # 40 objects
+      - Event.events.k8s.io # 40 objects
+`))
+		filter, err := model.DecodeFilter("filter.yaml", out.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter.Validate()).To(Succeed())
+	})
+})
+
+var _ = Describe("PrintFilterReport - Unit", Label("unit"), func() {
+	It("should print the objects included and skipped and the image sources", func() {
+		report := &k8.FilterReport{
+			Resources: []k8.ResourceReport{
+				{
+					GVR:           schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+					Kind:          "Pod",
+					LabelSelector: "app=web",
+					Included:      k8.NamespaceCounts{"default": 2},
+					Skipped:       k8.NamespaceCounts{"kube-system": 5},
+				},
+				{GVR: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, Kind: "Node", Skipped: k8.NamespaceCounts{"": 3}},
+				{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment"},
+				{GVR: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Kind: "Secret", Err: errors.New("forbidden")},
+				{GVR: schema.GroupVersionResource{Version: "v1", Resource: "services"}, Kind: "Service", Skip: "the filter includes none of it"},
+				{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, Kind: "ReplicaSet", Purpose: "owners of the Pods", Skipped: k8.NamespaceCounts{"default": 4}},
+			},
+			Images: k8.ImageReport{
+				Namespaces:    []string{"default"},
+				LabelSelector: "app=web",
+				IncludedPods:  k8.NamespaceCounts{"default": 2},
+			},
+		}
+		var out bytes.Buffer
+
+		PrintFilterReport(&out, report, []string{"default", "kube-system"})
+
+		lines := out.String()
+		Expect(lines).To(MatchRegexp(`\| pods\.v1 +\| Pod +\| default +\| +2 \| +0 \| listed with label selector app=web +\|`))
+		Expect(lines).To(MatchRegexp(`\| +\| +\| kube-system +\| +0 \| +5 \| +\|`))
+		Expect(lines).To(MatchRegexp(`\| nodes\.v1 +\| Node +\| \(cluster\) +\| +0 \| +3 \| +\|`))
+		Expect(lines).To(MatchRegexp(`\| deployments\.v1\.apps +\| Deployment +\| +\| +0 \| +0 \| +\|`))
+		Expect(lines).To(MatchRegexp(`\| secrets\.v1 +\| Secret +\| +\| +\| +\| not listed: forbidden +\|`))
+		Expect(lines).To(MatchRegexp(`\| services\.v1 +\| Service +\| +\| +\| +\| not listed: the filter includes none of it +\|`))
+		Expect(lines).To(MatchRegexp(`\| replicasets\.v1\.apps +\| ReplicaSet +\| default +\| +0 \| +4 \| listed for the owners of the Pods +\|`))
+		Expect(lines).To(MatchRegexp(`\| +\| +\| +\| +2 \| +12 \| +\|`))
+		Expect(lines).To(MatchRegexp(`\| default +\| +2 \| +0 \| +0 \| +0 \|`))
+		Expect(lines).To(ContainSubstring("Pods: listed with label selector app=web\n"))
+		Expect(lines).To(ContainSubstring("Namespaces the images are not taken from: kube-system\n"))
+	})
+})
+
+var _ = Describe("PrintQueryPlan - Unit", Label("unit"), func() {
+	It("should print the namespaces and selectors of each resource type and the ones skipped", func() {
+		plan := k8.QueryPlan{Queries: []k8.ResourceQuery{
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, Kind: "Pod", Namespaced: true, Namespaces: []string{"default", "web"}, LabelSelector: "app=web"},
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, Kind: "Namespace", Purpose: "namespaces of the images"},
+			{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment", Namespaced: true},
+			{GVR: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, Kind: "Node", Skip: "the filter includes none of it"},
+		}, HelmReleases: k8.ResourceQuery{
+			GVR: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Kind: "Release", Namespaced: true, Namespaces: []string{"web"},
+			LabelSelector: "owner=helm,status=deployed", Purpose: "Helm releases",
+		}}
+		var out bytes.Buffer
+
+		PrintQueryPlan(&out, plan)
+
+		lines := out.String()
+		Expect(lines).To(MatchRegexp(`\| pods\.v1 +\| Pod +\| default, web +\| +2 \| listed with label selector app=web +\|`))
+		Expect(lines).To(MatchRegexp(`\| namespaces\.v1 +\| Namespace +\| \(cluster\) +\| +1 \| listed for the namespaces of the images +\|`))
+		Expect(lines).To(MatchRegexp(`\| deployments\.v1\.apps +\| Deployment +\| \(all\) +\| +1 \| +\|`))
+		Expect(lines).To(MatchRegexp(`\| nodes\.v1 +\| Node +\| +\| +0 \| not listed: the filter includes none of it +\|`))
+		Expect(lines).To(MatchRegexp(`\| secrets\.v1 +\| Release +\| web +\| +1 \| listed for the Helm releases, listed with label selector owner=helm,status=deployed +\|`))
+		Expect(lines).To(HaveSuffix("Listing 3 of 4 resource types with 5 requests\n"))
+	})
+})
@@ -3,26 +3,41 @@ package cmd
 import (
 	"cluster-codex/internal/k8"
 	"cluster-codex/internal/model"
+	"cluster-codex/internal/registry"
 	"context"
 	prettyjson "encoding/json"
 	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
-	"io/ioutil"
-	"k8s.io/apimachinery/pkg/util/json"
-	"k8s.io/apimachinery/pkg/version"
+	"io"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 var (
-	format     string
-	outPath    string
-	filterPath string
-	sort       bool
+	format        string
+	outPath       string
+	filterPath    string
+	imageRules    string
+	versionRules  string
+	helmDriver    string
+	sort          bool
+	concurrency   int
+	fromManifests string
+	dryRun        bool
+	clientOptions k8.ClientOptions
+
+	resolveDigests      bool
+	imageMetadata       bool
+	digestCacheDir      string
+	registryConcurrency int
+	digestResolver      *registry.Resolver
 )
 
 var GenerateCmd = &cobra.Command{
@@ -34,8 +49,17 @@ var GenerateCmd = &cobra.Command{
 func init() {
 	GenerateCmd.Flags().StringVarP(&format, "format", "f", "cyclonedx-json", "Format of the generated BOM.")
 	GenerateCmd.Flags().StringVarP(&outPath, "out-path", "o", "./output.json", "Path and filename of generated cluster codex file.")
-	GenerateCmd.Flags().StringVarP(&filterPath, "filter-path", "i", "", "Path to a json file containing inclusion filterPath.")
+	GenerateCmd.Flags().StringVarP(&filterPath, "filter-path", "i", "", filterPathUsage)
+	GenerateCmd.Flags().StringVar(&imageRules, "image-rules", "", imageRulesUsage)
+	GenerateCmd.Flags().StringVar(&versionRules, "version-rules", "", versionRulesUsage)
+	GenerateCmd.Flags().StringVar(&helmDriver, "helm-driver", k8.HelmDriverSecret, helmDriverUsage)
 	GenerateCmd.Flags().BoolVarP(&sort, "sort", "s", false, "Sort the generated BOM JSON in Application, Kind, Name, Namespace order")
+	GenerateCmd.Flags().StringVar(&fromManifests, "from-manifests", "", "Generate the BOM offline from a manifest file, a directory of manifests or a cluster-info dump, or - for stdin.")
+	GenerateCmd.Flags().IntVarP(&concurrency, "concurrency", "c", k8.DefaultConcurrency, "Number of resource types listed from the cluster in parallel.")
+	GenerateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the resource types the filter lists from the cluster and in which namespaces, then exit without writing a BOM.")
+	GenerateCmd.MarkFlagsMutuallyExclusive("dry-run", "from-manifests")
+	addClientFlags(GenerateCmd, &clientOptions)
+	addDigestFlags(GenerateCmd)
 }
 
 func runGenerate(cmd *cobra.Command, _ []string) error {
@@ -46,34 +70,64 @@ func runGenerate(cmd *cobra.Command, _ []string) error {
 	var err error
 	err = getInclusionFilter()
 	if err != nil {
-		log.Fatal().Msgf("Error loading filter file: %v", err)
+		return err
 	}
-
-	k8sClient, err := k8.GetClient()
-
-	if err != nil {
-		log.Fatal().Msgf("Error creating Kubernetes client: %v", err)
+	if err := loadRules(imageRules, versionRules); err != nil {
+		return err
+	}
+	if err := k8.ValidateHelmDriver(helmDriver); err != nil {
+		return err
 	}
-	var serverVersion *version.Info
-	serverVersion, err = k8sClient.Client.Discovery().ServerVersion()
+
+	digestResolver, err = getDigestResolver()
 	if err != nil {
-		log.Fatal().Msgf("Failed to get server version: %v", err)
+		return err
 	}
 
-	log.Info().Msgf("Git version: %s", serverVersion.String())
+	// Stop listing the cluster when the user interrupts the command
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	bom, err := GenerateBOM(k8sClient)
+	contexts, err := getContexts()
 	if err != nil {
-		log.Err(err).Msgf("Error in GenerateBOM")
 		return err
 	}
+	if dryRun {
+		return printQueryPlans(ctx, cmd.OutOrStdout(), contexts)
+	}
+	if len(contexts) > 0 {
+		return runMultiClusterGenerate(ctx, contexts, start)
+	}
+
+	var bom *model.BOM
+	if fromManifests != "" {
+		manifestClient, err := k8.NewManifestClient(fromManifests)
+		if err != nil {
+			return fmt.Errorf("error reading manifests from %s: %w", fromManifests, err)
+		}
+		if resolveDigests {
+			manifestClient.DigestResolver = digestResolver
+		}
+		manifestClient.HelmDriver = helmDriver
+		bom, err = GenerateBOM(ctx, manifestClient)
+		if err != nil {
+			log.Err(err).Msgf("Error in GenerateBOM")
+			return err
+		}
+		saveDigestCache()
+	} else {
+		bom, err = generateLiveBOM(ctx, clientOptions)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Sort the BOM so it is consistent
 	if sort {
 		bom.Sort()
 	}
 
-	err = writeJson(bom)
+	err = writeJson(bom, outPath)
 	if err != nil {
 		return err
 	}
@@ -85,7 +139,112 @@ func runGenerate(cmd *cobra.Command, _ []string) error {
 	return err
 }
 
-func writeJson(bom *model.BOM) error {
+// getLiveClient connects to the cluster selected by the connection flags
+func getLiveClient(opts k8.ClientOptions) (*k8.K8sClient, error) {
+	k8sClient, err := k8.GetClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+	k8sClient.Concurrency = concurrency
+	k8sClient.HelmDriver = helmDriver
+	if resolveDigests {
+		k8sClient.DigestResolver = digestResolver
+	}
+	return k8sClient, nil
+}
+
+// printQueryPlans prints the query plan of the cluster selected by the connection flags, or of each of the contexts
+func printQueryPlans(ctx context.Context, w io.Writer, contexts []string) error {
+	if len(contexts) == 0 {
+		contexts = []string{clientOptions.Context}
+	}
+	for _, contextName := range contexts {
+		opts := clientOptions
+		opts.Context = contextName
+		liveClient, err := getLiveClient(opts)
+		if err != nil {
+			return err
+		}
+		plan, err := liveClient.PlanQueries(ctx)
+		if err != nil {
+			return fmt.Errorf("context %s: %w", liveClient.K8sContext, err)
+		}
+		fmt.Fprintf(w, "Context %s\n", liveClient.K8sContext)
+		PrintQueryPlan(w, plan)
+	}
+	return nil
+}
+
+// addClientFlags adds the standard kubectl connection flags to the command
+func addClientFlags(cmd *cobra.Command, opts *k8.ClientOptions) {
+	cmd.Flags().StringVar(&opts.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use for CLI requests.")
+	cmd.Flags().StringVar(&opts.Context, "context", "", "The name of the kubeconfig context to use.")
+	cmd.Flags().StringVar(&opts.Impersonate, "as", "", "Username to impersonate for the operation.")
+	cmd.Flags().StringArrayVar(&opts.ImpersonateGroups, "as-group", nil, "Group to impersonate for the operation, this flag can be repeated to specify multiple groups.")
+	cmd.Flags().StringVar(&opts.RequestTimeout, "request-timeout", "0", "The length of time to wait before giving up on a single server request. Zero means no timeout.")
+	cmd.Flags().BoolVar(&opts.InCluster, "in-cluster", false, "Use the service account of the Pod clx is running in instead of a kubeconfig.")
+}
+
+// generateLiveBOM generates the BOM of the cluster selected by the connection options
+func generateLiveBOM(ctx context.Context, opts k8.ClientOptions) (*model.BOM, error) {
+	liveClient, err := getLiveClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	bom, err := GenerateBOM(ctx, liveClient)
+	if err != nil {
+		log.Err(err).Msgf("Error in GenerateBOM for context %s", liveClient.K8sContext)
+		return nil, err
+	}
+	bom.Metadata.Component.AddProperty(model.ClusterContext, liveClient.K8sContext)
+	for _, resourceErr := range liveClient.ResourceErrors {
+		log.Debug().Msg(resourceErr.Error())
+	}
+	if len(liveClient.ResourceErrors) > 0 {
+		log.Info().Msgf("%d resource types could not be listed in context %s", len(liveClient.ResourceErrors), liveClient.K8sContext)
+	}
+	saveDigestCache()
+	return bom, nil
+}
+
+// addDigestFlags adds the flags to look up the image digests missing from the Pod statuses and the image metadata in
+// the registry
+func addDigestFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&resolveDigests, "resolve-digests", false, "Look up the digest of images without one in the Pod status, e.g. pending Pods and pod templates, in the registry.")
+	cmd.Flags().BoolVar(&imageMetadata, "image-metadata", false, "Add the licenses, vendor, source and revision of images from their OCI labels and annotations in the registry.")
+	cmd.Flags().StringVar(&digestCacheDir, "digest-cache-dir", "", "Directory to cache the digests and image metadata read from the registry in. Defaults to clx in the user cache directory, e.g. ~/.cache/clx.")
+	cmd.Flags().IntVar(&registryConcurrency, "registry-concurrency", registry.DefaultConcurrency, "Number of registry requests made in parallel when resolving digests and image metadata.")
+}
+
+// getDigestResolver returns the registry resolver for --resolve-digests and --image-metadata, or nil when the
+// registry is not used
+func getDigestResolver() (*registry.Resolver, error) {
+	if !resolveDigests && !imageMetadata {
+		return nil, nil
+	}
+	cacheDir := digestCacheDir
+	if cacheDir == "" {
+		cacheDir = registry.DefaultCacheDir()
+	}
+	resolver, err := registry.NewResolver(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry cache: %w", err)
+	}
+	resolver.Concurrency = registryConcurrency
+	return resolver, nil
+}
+
+// saveDigestCache writes the digests and image metadata read so far to the disk cache
+func saveDigestCache() {
+	if digestResolver == nil {
+		return
+	}
+	if err := digestResolver.Save(); err != nil {
+		log.Warn().Msgf("Could not save registry cache: %v", err)
+	}
+}
+
+func writeJson(bom *model.BOM, outPath string) error {
 	err := ValidatePath(outPath)
 	if err != nil {
 		log.Fatal().Msgf("Error validating path: %v", err)
@@ -107,30 +266,46 @@ func writeJson(bom *model.BOM) error {
 	return err
 }
 
-func GenerateBOM(k8client k8.K8sClientInterface) (*model.BOM, error) {
+func GenerateBOM(ctx context.Context, k8client k8.K8sClientInterface) (*model.BOM, error) {
 
 	bom := model.NewBOM()
-	ctx := context.Background()
 
-	componentList, namespaces, err := k8client.GetAllComponents(ctx)
+	// Failing to read the cluster info means the cluster cannot be reached, so stop before listing anything
+	clusterInfo, err := k8client.GetClusterInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
-	bom.Components = componentList
+	log.Info().Msgf("Git version: %s, provider: %s", clusterInfo.GitVersion, clusterInfo.Provider)
+	clusterInfo.AddToComponent(bom.Metadata.Component)
 
-	namespaceList := k8.K8Filter.GetNamespaceList()
-	if len(namespaceList) <= 0 {
-		namespaceList = namespaces // Get the list of namespaces if no filter is defined for namespaces
+	componentList, namespaces, err := k8client.GetAllComponents(ctx)
+	if err != nil {
+		return nil, err
 	}
+	bom.Components = componentList
 
-	componentList, err = k8client.GetAllImages(ctx, namespaceList)
+	componentList, err = k8client.GetAllImages(ctx, imageNamespaceList(namespaces))
 	if err != nil {
 		return nil, err
 	}
+	if imageMetadata && digestResolver != nil {
+		digestResolver.Enrich(ctx, componentList)
+	}
 	bom.Components = append(bom.Components, componentList...)
+	bom.AddOwnerDependencies()
+	bom.AddNodeDependencies()
 	return bom, nil
 }
 
+// imageNamespaceList returns the namespaces the images are taken from, out of the namespaces found in the cluster
+func imageNamespaceList(namespaces []string) []string {
+	namespaceList := k8.K8Filter.GetNamespaceList(namespaces)
+	if len(namespaceList) <= 0 {
+		namespaceList = namespaces // Get the list of namespaces if no filter is defined for namespaces
+	}
+	return namespaceList
+}
+
 func ValidatePath(filePath string) error {
 	if filePath == "" {
 		return errors.New("path cannot be empty")
@@ -151,27 +326,61 @@ func ValidatePath(filePath string) error {
 }
 
 func getInclusionFilter() error {
-
 	if filterPath != "" {
-		// Check if file exists
-		if _, err := os.Stat(filterPath); os.IsNotExist(err) {
-			log.Fatal().Str("file", filterPath).Msg("File does not exist")
-		}
-
-		// Read file contents
-		data, err := ioutil.ReadFile(filterPath)
+		filter, err := model.LoadFilter(filterPath)
 		if err != nil {
-			return fmt.Errorf("failed to read file: %v", err)
+			return err
+		}
+		InitializeFilterStruct(&filter)
+		// Compiles the patterns and selectors once, after InitializeFilterStruct has normalized them
+		if err := k8.K8Filter.Validate(); err != nil {
+			return fmt.Errorf("invalid filter %s: %w", filterPath, err)
 		}
+	}
+	return nil
+}
 
-		// Parse JSON
-		var filter model.Filter
-		err = json.Unmarshal(data, &filter)
-		if err != nil {
-			return fmt.Errorf("failed to parse JSON: %v", err)
+const (
+	filterPathUsage   = "Path to a YAML or JSON file of inclusion and exclusion filters. Check it with clx filter validate."
+	imageRulesUsage   = "Path to a YAML or JSON file mapping the kinds of custom resources to JSONPath expressions that yield their images."
+	versionRulesUsage = "Path to a YAML or JSON file of rules reading the version of objects from a JSONPath expression, a label or an annotation, tried before the built-in rules."
+	helmDriverUsage   = "Storage the Helm releases are read from: secret, configmap or none."
+)
+
+// loadRules reads the image rules and version rules from their files, if any, and sets them for the k8 package
+func loadRules(imageRulesPath string, versionRulesPath string) error {
+	if imageRulesPath != "" {
+		var rules model.ImageRules
+		if err := decodeRulesFile(imageRulesPath, &rules); err != nil {
+			return err
 		}
+		if err := k8.SetImageRules(rules); err != nil {
+			return fmt.Errorf("invalid image rules %s: %w", imageRulesPath, err)
+		}
+		log.Info().Msgf("Loaded %d image rules from %s", len(rules.Rules), imageRulesPath)
+	}
+	if versionRulesPath != "" {
+		var rules model.VersionRules
+		if err := decodeRulesFile(versionRulesPath, &rules); err != nil {
+			return err
+		}
+		if err := k8.SetVersionRules(rules); err != nil {
+			return fmt.Errorf("invalid version rules %s: %w", versionRulesPath, err)
+		}
+		log.Info().Msgf("Loaded %d version rules from %s", len(rules.Rules), versionRulesPath)
+	}
+	return nil
+}
 
-		InitializeFilterStruct(&filter)
+// decodeRulesFile decodes the YAML or JSON rules file into rules
+func decodeRulesFile(path string, rules interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read rules: %w", err)
+	}
+	defer file.Close()
+	if err := yaml.NewYAMLOrJSONDecoder(file, 4096).Decode(rules); err != nil {
+		return fmt.Errorf("failed to parse rules %s: %w", path, err)
 	}
 	return nil
 }
@@ -199,9 +408,11 @@ func InitializeFilterStruct(filter *model.Filter) {
 					// If resource array is empty, consider all resources for all namespaces
 					filter.NamespacedInclusions[idx].Resources = []string{"*"}
 				}
-				// Convert Resources to lowercase
+				// Convert Resources to lowercase, leaving regular expressions alone since case matters for their escapes
 				for k, resource := range inclusion.Resources {
-					filter.NamespacedInclusions[idx].Resources[k] = strings.ToLower(resource)
+					if !model.IsRegexPattern(resource) {
+						filter.NamespacedInclusions[idx].Resources[k] = strings.ToLower(resource)
+					}
 				}
 				break
 			}
@@ -4,6 +4,7 @@ import (
 	. "cluster-codex/cmd"
 	"cluster-codex/internal/k8"
 	"cluster-codex/internal/model"
+	"context"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -17,7 +18,7 @@ var _ = Describe("GenerateBOM - Integration", Label("integration"), Ordered, fun
 
 	Context("When generate BOM is called should return valid BOM", func() {
 		BeforeEach(func() {
-			k8client, err = k8.GetClient()
+			k8client, err = k8.GetClient(k8.ClientOptions{})
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -29,7 +30,7 @@ var _ = Describe("GenerateBOM - Integration", Label("integration"), Ordered, fun
 				k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: namespaces}}}
 				InitializeFilterStruct(&k8.K8Filter)
 
-				bom, err = GenerateBOM(k8client)
+				bom, err = GenerateBOM(context.Background(), k8client)
 
 				Expect(err).To(BeNil())
 				Expect(bom).ToNot(BeNil())
@@ -57,12 +58,13 @@ var _ = Describe("GenerateBOM - Integration", Label("integration"), Ordered, fun
 				images := bom.FindContainers("index.docker.io/library/nginx", "Image", testNamespace)
 				if findNamespace {
 					Expect(len(images)).To(BeNumerically("==", 1))
-					ownerRef, found := images[0].GetProperty("clx:k8s:ownerRef")
+					// An image run in both namespaces is a single component with an ownerRef for each
+					ownerRef, found := images[0].GetPropertyObject("clx:k8s:ownerRef")
 					Expect(found).To(BeTrue())
-					Expect(ownerRef).To(Equal("Deployment/nginx-deployment"))
+					Expect(ownerRef.Values).To(ContainElement("clx-test/Deployment/nginx-deployment"))
 					//Image sha will be different for multi-arch images so checking substring
 					Expect(images[0].PackageURL).To(ContainSubstring("pkg:oci/library/nginx@sha256:"))
-					Expect(images[0].PackageURL).To(ContainSubstring("?namespace=clx-test&ownerRef=Deployment%2Fnginx-deployment&repository_url=index.docker.io%2Flibrary%2Fnginx"))
+					Expect(images[0].PackageURL).To(ContainSubstring("?repository_url=index.docker.io%2Flibrary%2Fnginx"))
 				} else {
 					Expect(len(images)).To(BeNumerically("==", 0))
 				}
@@ -78,12 +80,13 @@ var _ = Describe("GenerateBOM - Integration", Label("integration"), Ordered, fun
 				images1 := bom.FindContainers("index.docker.io/library/nginx", "Image", testNamespace2)
 				if findNamespace2 {
 					Expect(len(images1)).To(BeNumerically("==", 1))
-					ownerRef, found := images1[0].GetProperty("clx:k8s:ownerRef")
+					// An image run in both namespaces is a single component with an ownerRef for each
+					ownerRef, found := images1[0].GetPropertyObject("clx:k8s:ownerRef")
 					Expect(found).To(BeTrue())
-					Expect(ownerRef).To(Equal("Deployment/nginx-deployment"))
+					Expect(ownerRef.Values).To(ContainElement("clx-test-2/Deployment/nginx-deployment"))
 					//Image sha will be different for multi-arch images so checking substring
 					Expect(images1[0].PackageURL).To(ContainSubstring("pkg:oci/library/nginx@sha256:"))
-					Expect(images1[0].PackageURL).To(ContainSubstring("?namespace=clx-test-2&ownerRef=Deployment%2Fnginx-deployment&repository_url=index.docker.io%2Flibrary%2Fnginx"))
+					Expect(images1[0].PackageURL).To(ContainSubstring("?repository_url=index.docker.io%2Flibrary%2Fnginx"))
 				} else {
 					Expect(len(images1)).To(BeNumerically("==", 0))
 				}
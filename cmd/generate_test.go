@@ -2,6 +2,7 @@ package cmd_test
 
 import (
 	. "cluster-codex/cmd"
+	"cluster-codex/internal/k8"
 	"cluster-codex/internal/k8/k8fakes"
 	"cluster-codex/internal/model"
 	"context"
@@ -11,6 +12,8 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"time"
 )
 
 var _ = Describe("constructPath", Label("unit"), func() {
@@ -75,7 +78,7 @@ var _ = Describe("GenerateBOM - Unit", Label("unit"), func() {
 				return mockResponse, []string{}, nil
 			}
 
-			bom, err := GenerateBOM(fakeK8sClient)
+			bom, err := GenerateBOM(context.Background(), fakeK8sClient)
 
 			Expect(err).To(BeNil())
 			Expect(bom).ToNot(BeNil())
@@ -84,13 +87,46 @@ var _ = Describe("GenerateBOM - Unit", Label("unit"), func() {
 		})
 	})
 
+	Context("when GetClusterInfo returns the cluster info", func() {
+		It("should describe the cluster in the metadata component", func() {
+			fakeK8sClient.GetClusterInfoReturns(k8.ClusterInfo{
+				GitVersion: "v1.31.2-eks-7f9249a",
+				Platform:   "linux/amd64",
+				BuildDate:  "2024-10-23T05:24:49Z",
+				UID:        "1c4b5f1e-0d6a-4b43-9c55-7a4d0b6b0c1f",
+				Provider:   k8.ProviderEKS,
+			}, nil)
+
+			bom, err := GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bom.Metadata.Component.Version).To(Equal("v1.31.2-eks-7f9249a"))
+			Expect(bom.Metadata.Component.Properties).To(ConsistOf(
+				model.Property{Name: model.ClusterPlatform, Values: []string{"linux/amd64"}},
+				model.Property{Name: model.ClusterBuildDate, Values: []string{"2024-10-23T05:24:49Z"}},
+				model.Property{Name: model.ClusterUID, Values: []string{"1c4b5f1e-0d6a-4b43-9c55-7a4d0b6b0c1f"}},
+				model.Property{Name: model.ClusterProvider, Values: []string{k8.ProviderEKS}},
+			))
+		})
+
+		It("should not list the cluster when the cluster info cannot be read", func() {
+			fakeK8sClient.GetClusterInfoReturns(k8.ClusterInfo{}, assert.AnError)
+
+			bom, err := GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(bom).To(BeNil())
+			Expect(err).To(MatchError(assert.AnError))
+			Expect(fakeK8sClient.GetAllComponentsCallCount()).To(BeZero())
+		})
+	})
+
 	Context("when GetAllComponents returns an error", func() {
 		It("should return nil BOM", func() {
 			fakeK8sClient.GetAllComponentsStub = func(ctx context.Context) ([]model.Component, []string, error) {
 				return nil, []string{}, assert.AnError
 			}
 
-			bom, err := GenerateBOM(fakeK8sClient)
+			bom, err := GenerateBOM(context.Background(), fakeK8sClient)
 
 			Expect(bom).To(BeNil())
 			Expect(err).ToNot(BeNil())
@@ -247,6 +283,21 @@ var _ = Describe("InitializeFilterStruct", Label("unit"), func() {
 					},
 				}},
 		),
+		Entry("should not convert regular expressions in resources to lowercase",
+			`{
+				"namespaced-inclusions": [
+					{
+						"namespaces": ["*"],
+						"resources": ["Pod", "/^\\S+Set$/"]
+					}
+				]
+			}`,
+			&model.Filter{
+				NamespacedInclusions: []model.NamespacedInclusion{
+					{Namespaces: []string{"*"}, Resources: []string{"pod", "/^\\S+Set$/"}},
+				},
+			},
+		),
 		Entry("should handle case when namespace/resource is nil",
 			`{
 				"namespaced-inclusions": [
@@ -275,3 +326,68 @@ func loadFilterFromJSON(jsonData string) *model.Filter {
 	Expect(err).NotTo(HaveOccurred()) // Ensure JSON is valid
 	return &filter
 }
+
+var _ = Describe("Multi-cluster generation - Unit", Label("unit"), func() {
+	DescribeTable("RenderOutPath",
+		func(pathTemplate string, contextName string, expected string, expectError bool) {
+			path, err := RenderOutPath(pathTemplate, contextName)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).To(Equal(expected))
+		},
+		Entry("should put the context in the path", "./boms/{{.Context}}.json", "prod", "./boms/prod.json", false),
+		Entry("should replace characters that are not safe in a file name", "./{{.Context}}.json", "arn:aws:eks:us-east-1:123456789012:cluster/prod", "./arn_aws_eks_us-east-1_123456789012_cluster_prod.json", false),
+		Entry("should fail when the path has no template", "./output.json", "prod", "", true),
+		Entry("should fail for an unknown template field", "./{{.Cluster}}.json", "prod", "", true),
+		Entry("should fail for an invalid template", "./{{.Context.json", "prod", "", true),
+	)
+
+	It("should generate every cluster and report a failure without aborting the others", func() {
+		var calls atomic.Int32
+		generate := func(ctx context.Context, contextName string) (*model.BOM, error) {
+			calls.Add(1)
+			if contextName == "broken" {
+				return nil, errors.New("connection refused")
+			}
+			bom := model.NewBOM()
+			bom.Metadata.Component.AddProperty(model.ClusterContext, contextName)
+			return bom, nil
+		}
+
+		results := GenerateForContexts(context.Background(), []string{"dev", "broken", "prod"}, DefaultMaxParallelContexts, generate)
+
+		Expect(calls.Load()).To(BeEquivalentTo(3))
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].Context).To(Equal("dev"))
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].BOM).ToNot(BeNil())
+		Expect(results[1].Context).To(Equal("broken"))
+		Expect(results[1].Err).To(MatchError("connection refused"))
+		Expect(results[2].Context).To(Equal("prod"))
+		Expect(results[2].Err).ToNot(HaveOccurred())
+	})
+
+	It("should generate at most the given number of clusters at a time", func() {
+		var running, maxRunning atomic.Int32
+		generate := func(ctx context.Context, contextName string) (*model.BOM, error) {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				seen := maxRunning.Load()
+				if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return model.NewBOM(), nil
+		}
+
+		results := GenerateForContexts(context.Background(), []string{"a", "b", "c", "d", "e", "f"}, 2, generate)
+
+		Expect(results).To(HaveLen(6))
+		Expect(maxRunning.Load()).To(BeNumerically("<=", 2))
+	})
+})
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var (
+	contextNames        []string
+	allContexts         bool
+	aggregate           bool
+	maxParallelContexts int
+)
+
+// DefaultMaxParallelContexts is the default number of clusters whose BOMs are generated at the same time. Each one
+// lists its resource types with --concurrency workers, so the clusters are bounded too.
+const DefaultMaxParallelContexts = 4
+
+// Characters that are not safe in a file name are replaced when the context is used in --out-path, e.g. the colons
+// in an EKS context ARN.
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ClusterResult is the outcome of generating the BOM of a single cluster
+type ClusterResult struct {
+	Context string
+	BOM     *model.BOM
+	Err     error
+}
+
+// outPathData is the data available to the --out-path template
+type outPathData struct {
+	Context string
+}
+
+func init() {
+	GenerateCmd.Flags().StringSliceVar(&contextNames, "contexts", nil, "Comma separated kubeconfig contexts to generate a BOM for in parallel.")
+	GenerateCmd.Flags().BoolVar(&allContexts, "all-contexts", false, "Generate a BOM for every context in the kubeconfig in parallel.")
+	GenerateCmd.Flags().IntVar(&maxParallelContexts, "max-parallel-contexts", DefaultMaxParallelContexts, "Number of contexts generated in parallel with --contexts or --all-contexts.")
+	GenerateCmd.Flags().BoolVar(&aggregate, "aggregate", false, "With multiple contexts, write a single BOM with each cluster as a nested component instead of one BOM per cluster.")
+	GenerateCmd.MarkFlagsMutuallyExclusive("contexts", "all-contexts", "context")
+	GenerateCmd.MarkFlagsMutuallyExclusive("contexts", "from-manifests")
+	GenerateCmd.MarkFlagsMutuallyExclusive("all-contexts", "from-manifests")
+}
+
+// getContexts returns the contexts selected by --contexts or --all-contexts, or nil for a single cluster run
+func getContexts() ([]string, error) {
+	if allContexts {
+		return k8.GetContexts(clientOptions)
+	}
+	return contextNames, nil
+}
+
+func runMultiClusterGenerate(ctx context.Context, contexts []string, start time.Time) error {
+	// Check the out-path template before spending time on the clusters
+	if !aggregate {
+		if _, err := RenderOutPath(outPath, contexts[0]); err != nil {
+			return err
+		}
+	}
+
+	generate := func(ctx context.Context, contextName string) (*model.BOM, error) {
+		opts := clientOptions
+		opts.Context = contextName
+		return generateLiveBOM(ctx, opts)
+	}
+	results := GenerateForContexts(ctx, contexts, maxParallelContexts, generate)
+
+	var failed []error
+	var boms []*model.BOM
+	for _, result := range results {
+		if result.Err != nil {
+			log.Error().Msgf("Failed to generate BOM for context %s: %v", result.Context, result.Err)
+			failed = append(failed, fmt.Errorf("context %s: %w", result.Context, result.Err))
+			continue
+		}
+		if sort {
+			result.BOM.Sort()
+		}
+		if aggregate {
+			boms = append(boms, result.BOM)
+			continue
+		}
+		path, err := RenderOutPath(outPath, result.Context)
+		if err != nil {
+			return err
+		}
+		if err := writeJson(result.BOM, path); err != nil {
+			failed = append(failed, fmt.Errorf("context %s: %w", result.Context, err))
+			continue
+		}
+		fmt.Printf("Generate command output for context %s written to file %s\n", result.Context, path)
+	}
+
+	if aggregate && len(boms) > 0 {
+		bom := model.NewAggregateBOM(boms)
+		if sort {
+			bom.Sort()
+		}
+		if err := writeJson(bom, outPath); err != nil {
+			return err
+		}
+		fmt.Printf("Generate command output for %d clusters written to file %s\n", len(boms), outPath)
+	}
+
+	seconds := int64(time.Since(start).Round(time.Second) / time.Second)
+	fmt.Printf("Generated %d of %d cluster BOMs in %d seconds\n", len(results)-len(failed), len(results), seconds)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to generate BOM for %d of %d clusters: %w", len(failed), len(results), errors.Join(failed...))
+	}
+	return nil
+}
+
+// GenerateForContexts runs generate against every context, at most maxParallel at a time, and returns the results in
+// the order of the contexts. A failure on one cluster does not stop the others.
+func GenerateForContexts(ctx context.Context, contexts []string, maxParallel int, generate func(ctx context.Context, contextName string) (*model.BOM, error)) []ClusterResult {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	results := make([]ClusterResult, len(contexts))
+	semaphore := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for idx, contextName := range contexts {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			bom, err := generate(ctx, contextName)
+			results[idx] = ClusterResult{Context: contextName, BOM: bom, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// RenderOutPath renders the --out-path template for a context, e.g. "./boms/{{.Context}}.json"
+func RenderOutPath(pathTemplate string, contextName string) (string, error) {
+	tmpl, err := template.New("out-path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid out-path template %q: %w", pathTemplate, err)
+	}
+	var path bytes.Buffer
+	if err := tmpl.Execute(&path, outPathData{Context: unsafePathChars.ReplaceAllString(contextName, "_")}); err != nil {
+		return "", fmt.Errorf("invalid out-path template %q: %w", pathTemplate, err)
+	}
+	if path.String() == pathTemplate {
+		return "", fmt.Errorf("out-path %q must contain {{.Context}} to write one BOM per cluster, or use --aggregate", pathTemplate)
+	}
+	return path.String(), nil
+}
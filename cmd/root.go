@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"os"
+	"path/filepath"
 )
 
 var logLevel string
@@ -17,6 +18,10 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
+	// When installed on the PATH as kubectl-clx, clx runs as a kubectl plugin
+	if filepath.Base(os.Args[0]) == "kubectl-clx" {
+		rootCmd.Annotations = map[string]string{cobra.CommandDisplayNameAnnotation: "kubectl clx"}
+	}
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -26,5 +31,8 @@ func Execute() {
 func init() {
 	rootCmd.AddCommand(GenerateCmd)
 	rootCmd.AddCommand(CompareCmd)
+	rootCmd.AddCommand(WatchCmd)
+	rootCmd.AddCommand(ServeCmd)
+	rootCmd.AddCommand(FilterCmd)
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "warn", "Set the logging level (debug, info, warn, error)")
 }
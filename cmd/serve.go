@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/server"
+	"context"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	listenAddress string
+	serveInterval time.Duration
+)
+
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the Kubernetes BOM of the provided K8s cluster and its metrics over HTTP",
+	Long: `Serve the Kubernetes BOM of the provided K8s cluster over HTTP, regenerating it periodically.
+
+Endpoints:
+  /bom?format=cyclonedx-json|csv              The latest BOM
+  /components?kind=&namespace=&name=&type=    The components matching the query
+  /metrics                                    Prometheus metrics
+  /healthz                                    The time of the last successful generation`,
+	RunE: runServe,
+}
+
+func init() {
+	ServeCmd.Flags().StringVar(&listenAddress, "listen", ":8080", "Address to serve the HTTP API on.")
+	ServeCmd.Flags().DurationVar(&serveInterval, "interval", server.DefaultInterval, "How often the BOM is regenerated.")
+	ServeCmd.Flags().StringVarP(&filterPath, "filter-path", "i", "", filterPathUsage)
+	ServeCmd.Flags().StringVar(&imageRules, "image-rules", "", imageRulesUsage)
+	ServeCmd.Flags().StringVar(&versionRules, "version-rules", "", versionRulesUsage)
+	ServeCmd.Flags().StringVar(&helmDriver, "helm-driver", k8.HelmDriverSecret, helmDriverUsage)
+	ServeCmd.Flags().BoolVarP(&sort, "sort", "s", false, "Sort the generated BOM JSON in Application, Kind, Name, Namespace order")
+	ServeCmd.Flags().IntVarP(&concurrency, "concurrency", "c", k8.DefaultConcurrency, "Number of resource types listed from the cluster in parallel.")
+	addClientFlags(ServeCmd, &clientOptions)
+	addDigestFlags(ServeCmd)
+}
+
+func runServe(cmd *cobra.Command, _ []string) error {
+	log.Info().Msg("Starting serve command")
+
+	err := getInclusionFilter()
+	if err != nil {
+		return err
+	}
+	if err := loadRules(imageRules, versionRules); err != nil {
+		return err
+	}
+	if err := k8.ValidateHelmDriver(helmDriver); err != nil {
+		return err
+	}
+
+	digestResolver, err = getDigestResolver()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bomServer := server.New(func(ctx context.Context) (*model.BOM, error) {
+		bom, err := generateLiveBOM(ctx, clientOptions)
+		if err != nil {
+			return nil, err
+		}
+		if sort {
+			bom.Sort()
+		}
+		return bom, nil
+	})
+	bomServer.Interval = serveInterval
+	go bomServer.Run(ctx)
+
+	return bomServer.ListenAndServe(ctx, listenAddress)
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	watchOutPath  string
+	watchEvents   bool
+	watchDebounce time.Duration
+)
+
+var WatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Keep a Kubernetes BOM current by watching the provided K8s cluster for changes",
+	RunE:  runWatch,
+}
+
+// ComponentEvent is written for each component that changed when --events is set
+type ComponentEvent struct {
+	Type      string          `json:"type"`
+	Timestamp string          `json:"timestamp"`
+	Context   string          `json:"context"`
+	Component model.Component `json:"component"`
+}
+
+// Types of ComponentEvent
+const (
+	ComponentAdded   = "added"
+	ComponentRemoved = "removed"
+	ComponentChanged = "changed"
+)
+
+func init() {
+	WatchCmd.Flags().StringVarP(&watchOutPath, "out-path", "o", "./output.json", "Path and filename of the cluster codex file rewritten on every change.")
+	WatchCmd.Flags().BoolVar(&watchEvents, "events", false, "Write a JSON line to stdout for every component that is added, removed or changed.")
+	WatchCmd.Flags().DurationVar(&watchDebounce, "debounce", k8.DefaultDebounce, "How long to wait for further changes before rebuilding the BOM.")
+	WatchCmd.Flags().StringVarP(&filterPath, "filter-path", "i", "", filterPathUsage)
+	WatchCmd.Flags().StringVar(&imageRules, "image-rules", "", imageRulesUsage)
+	WatchCmd.Flags().StringVar(&versionRules, "version-rules", "", versionRulesUsage)
+	WatchCmd.Flags().StringVar(&helmDriver, "helm-driver", k8.HelmDriverSecret, helmDriverUsage)
+	WatchCmd.Flags().BoolVarP(&sort, "sort", "s", false, "Sort the generated BOM JSON in Application, Kind, Name, Namespace order")
+	addClientFlags(WatchCmd, &clientOptions)
+	addDigestFlags(WatchCmd)
+}
+
+func runWatch(cmd *cobra.Command, _ []string) error {
+	log.Info().Msg("Starting watch command")
+
+	err := getInclusionFilter()
+	if err != nil {
+		return err
+	}
+	if err := loadRules(imageRules, versionRules); err != nil {
+		return err
+	}
+	if err := k8.ValidateHelmDriver(helmDriver); err != nil {
+		return err
+	}
+	if err := ValidatePath(watchOutPath); err != nil {
+		return fmt.Errorf("error validating path: %w", err)
+	}
+
+	digestResolver, err = getDigestResolver()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	liveClient, err := getLiveClient(clientOptions)
+	if err != nil {
+		return err
+	}
+	watcher := k8.NewWatcher(liveClient)
+	watcher.Debounce = watchDebounce
+
+	var previous []model.Component
+	var events io.Writer
+	if watchEvents {
+		events = os.Stdout
+	}
+	err = watcher.Run(ctx, func() {
+		bom, err := GenerateBOM(ctx, watcher)
+		if err != nil {
+			log.Err(err).Msgf("Error in GenerateBOM")
+			return
+		}
+		bom.Metadata.Component.AddProperty(model.ClusterContext, liveClient.K8sContext)
+		saveDigestCache()
+		if sort {
+			bom.Sort()
+		}
+
+		changes, err := WriteBOMChanges(events, liveClient.K8sContext, previous, bom)
+		if err != nil {
+			log.Err(err).Msg("Error writing events")
+		}
+		if previous != nil && changes == 0 {
+			log.Debug().Msg("No components changed")
+			return
+		}
+		previous = bom.Components
+
+		if err := writeJsonAtomic(bom, watchOutPath); err != nil {
+			log.Err(err).Msgf("Error writing %s", watchOutPath)
+			return
+		}
+		log.Info().Msgf("Watch output with %d components written to file %s", len(bom.Components), watchOutPath)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msg("Watch stopped")
+	return nil
+}
+
+// WriteBOMChanges writes a ComponentEvent for every difference between the previous and current components when
+// events is not nil, and returns the number of differences.
+func WriteBOMChanges(events io.Writer, contextName string, previous []model.Component, bom *model.BOM) (int, error) {
+	added, removed, changed := model.DiffComponents(previous, bom.Components)
+	if events == nil {
+		return len(added) + len(removed) + len(changed), nil
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	encoder := json.NewEncoder(events)
+	encoder.SetEscapeHTML(false)
+	write := func(eventType string, components []model.Component) error {
+		for _, component := range components {
+			err := encoder.Encode(ComponentEvent{Type: eventType, Timestamp: timestamp, Context: contextName, Component: component})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := errors.Join(write(ComponentRemoved, removed), write(ComponentAdded, added), write(ComponentChanged, changed)); err != nil {
+		return 0, err
+	}
+	return len(added) + len(removed) + len(changed), nil
+}
+
+// writeJsonAtomic writes the BOM to a temporary file next to outPath and renames it, so readers of outPath never
+// see a partially written BOM.
+func writeJsonAtomic(bom *model.BOM, outPath string) error {
+	tmpPath := outPath + ".tmp"
+	if err := writeJson(bom, tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, outPath)
+}
@@ -0,0 +1,48 @@
+package cmd_test
+
+import (
+	"bytes"
+	. "cluster-codex/cmd"
+	"cluster-codex/internal/model"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = Describe("WriteBOMChanges - Unit", Label("unit"), func() {
+	web := model.Component{Type: "application", Name: "web", PackageURL: "pkg:k8s/Deployment/web"}
+	nginx := model.Component{Type: "container", Name: "nginx", PackageURL: "pkg:oci/nginx@sha256:aaaa"}
+	redis := model.Component{Type: "container", Name: "redis", PackageURL: "pkg:oci/redis@sha256:bbbb"}
+
+	It("should write a JSON line for every removed and added component", func() {
+		var events bytes.Buffer
+		bom := model.NewBOM()
+		bom.Components = []model.Component{web, redis}
+
+		changes, err := WriteBOMChanges(&events, "dev", []model.Component{web, nginx}, bom)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal(2))
+		lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		var event ComponentEvent
+		Expect(json.Unmarshal([]byte(lines[0]), &event)).To(Succeed())
+		Expect(event.Type).To(Equal(ComponentRemoved))
+		Expect(event.Context).To(Equal("dev"))
+		Expect(event.Component.Name).To(Equal("nginx"))
+		Expect(json.Unmarshal([]byte(lines[1]), &event)).To(Succeed())
+		Expect(event.Type).To(Equal(ComponentAdded))
+		Expect(event.Component.Name).To(Equal("redis"))
+	})
+
+	It("should only count the changes when no events are written", func() {
+		bom := model.NewBOM()
+		bom.Components = []model.Component{web}
+
+		changes, err := WriteBOMChanges(nil, "dev", []model.Component{web}, bom)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal(0))
+	})
+})
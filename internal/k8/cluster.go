@@ -0,0 +1,188 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+)
+
+// Distributions and managed Kubernetes providers detected by detectProvider
+const (
+	ProviderEKS       = "eks"
+	ProviderGKE       = "gke"
+	ProviderAKS       = "aks"
+	ProviderOpenShift = "openshift"
+	ProviderKind      = "kind"
+	ProviderK3s       = "k3s"
+)
+
+// Clouds detected from the provider IDs of the nodes when neither the version nor the labels name the provider, e.g.
+// a cluster installed with kubeadm on cloud VMs
+const (
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+)
+
+// The number of nodes read to detect the provider, since all the nodes of a cluster normally have the same one
+const providerNodeSample = 10
+
+// Node labels set by each provider
+var providerLabels = []struct {
+	label    string
+	provider string
+}{
+	{"eks.amazonaws.com/nodegroup", ProviderEKS},
+	{"eks.amazonaws.com/compute-type", ProviderEKS},
+	{"cloud.google.com/gke-nodepool", ProviderGKE},
+	{"kubernetes.azure.com/cluster", ProviderAKS},
+	{"node.openshift.io/os_id", ProviderOpenShift},
+}
+
+// Markers in the git version of the API server, e.g. v1.30.4-eks-a737599, v1.30.5-gke.1014001 or v1.30.5+k3s1
+var providerVersionMarkers = []struct {
+	marker   string
+	provider string
+}{
+	{"-eks-", ProviderEKS},
+	{"-gke.", ProviderGKE},
+	{"+k3s", ProviderK3s},
+}
+
+// Prefixes of the node provider IDs, e.g. kind://docker/kind/kind-control-plane. They are only checked when neither the
+// version nor the labels of any node name the provider, since the cloud prefixes are set by the cloud provider on any
+// cluster running on its VMs and not only by the managed service.
+var providerIDPrefixes = []struct {
+	prefix   string
+	provider string
+}{
+	{"aws://", ProviderAWS},
+	{"gce://", ProviderGCP},
+	{"azure://", ProviderAzure},
+	{"kind://", ProviderKind},
+	{"k3s://", ProviderK3s},
+}
+
+// ClusterInfo identifies the cluster a BOM is generated from
+type ClusterInfo struct {
+	// GitVersion is the version of the API server, e.g. v1.31.2
+	GitVersion string
+	// Platform is the OS and architecture of the API server, e.g. linux/amd64
+	Platform  string
+	BuildDate string
+	// UID is the UID of the kube-system namespace, which stays the same for the lifetime of the cluster
+	UID string
+	// Provider is the detected distribution or managed Kubernetes provider, or "" when it is not known
+	Provider string
+}
+
+// AddToComponent sets the version and identity properties of the component describing the cluster
+func (info ClusterInfo) AddToComponent(component *model.Component) {
+	component.Version = info.GitVersion
+	for property, value := range map[string]string{
+		model.ClusterPlatform:  info.Platform,
+		model.ClusterBuildDate: info.BuildDate,
+		model.ClusterUID:       info.UID,
+		model.ClusterProvider:  info.Provider,
+	} {
+		if value != "" {
+			component.AddProperty(property, value)
+		}
+	}
+}
+
+// GetClusterInfo returns the version of the API server, the UID of the kube-system namespace and the provider of the
+// cluster. Only failing to read the version is an error, since the other requests can be forbidden by RBAC.
+func (c *K8sClient) GetClusterInfo(ctx context.Context) (ClusterInfo, error) {
+	var info ClusterInfo
+	serverVersion, err := c.Discovery.ServerVersion()
+	if err != nil {
+		return info, fmt.Errorf("failed to get server version: %w", err)
+	}
+	info.GitVersion = serverVersion.GitVersion
+	c.gitVersion = serverVersion.GitVersion
+	info.Platform = serverVersion.Platform
+	info.BuildDate = serverVersion.BuildDate
+
+	kubeSystem, err := c.Client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		log.Warn().Msgf("Could not get the cluster UID from the %s namespace: %v", metav1.NamespaceSystem, err)
+	} else {
+		info.UID = string(kubeSystem.UID)
+	}
+
+	var nodes []corev1.Node
+	nodeList, err := c.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: providerNodeSample})
+	if err != nil {
+		log.Warn().Msgf("Could not list the nodes to detect the provider: %v", err)
+	} else {
+		nodes = nodeList.Items
+	}
+	info.Provider = detectProvider(info.GitVersion, nodes)
+	return info, nil
+}
+
+// GetClusterInfo returns the UID of the kube-system namespace and the provider from the nodes in the manifests. The
+// version of the API server is not part of the manifests.
+func (m *ManifestClient) GetClusterInfo(ctx context.Context) (ClusterInfo, error) {
+	var info ClusterInfo
+	var nodes []corev1.Node
+	for _, item := range m.objects {
+		if ctx.Err() != nil {
+			return info, ctx.Err()
+		}
+		if item.GroupVersionKind().Group != "" {
+			continue
+		}
+		switch item.GetKind() {
+		case "Namespace":
+			if item.GetName() == metav1.NamespaceSystem {
+				info.UID = string(item.GetUID())
+			}
+		case "Node":
+			var node corev1.Node
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &node); err != nil {
+				log.Err(err).Msgf("Could not read node %s", item.GetName())
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	info.Provider = detectProvider("", nodes)
+	return info, nil
+}
+
+// GetClusterInfo returns the cluster info from the API server
+func (w *Watcher) GetClusterInfo(ctx context.Context) (ClusterInfo, error) {
+	return w.client.GetClusterInfo(ctx)
+}
+
+// detectProvider detects the distribution or managed Kubernetes provider from the git version of the API server and
+// the labels and provider IDs of the nodes. It returns "" when none is detected.
+func detectProvider(gitVersion string, nodes []corev1.Node) string {
+	for _, versionMarker := range providerVersionMarkers {
+		if strings.Contains(gitVersion, versionMarker.marker) {
+			return versionMarker.provider
+		}
+	}
+	for _, node := range nodes {
+		for _, providerLabel := range providerLabels {
+			if _, found := node.Labels[providerLabel.label]; found {
+				return providerLabel.provider
+			}
+		}
+	}
+	for _, node := range nodes {
+		for _, providerID := range providerIDPrefixes {
+			if strings.HasPrefix(node.Spec.ProviderID, providerID.prefix) {
+				return providerID.provider
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package k8
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"maps"
+	"slices"
+)
+
+// NamespaceCounts are numbers of objects by namespace. The non-namespaced objects are counted under "".
+type NamespaceCounts map[string]int
+
+func (n *NamespaceCounts) add(namespace string) {
+	if *n == nil {
+		*n = make(NamespaceCounts)
+	}
+	(*n)[namespace]++
+}
+
+// Total returns the number of objects in all the namespaces
+func (n NamespaceCounts) Total() int {
+	total := 0
+	for _, count := range n {
+		total += count
+	}
+	return total
+}
+
+// Namespaces returns the namespaces with objects, sorted
+func (n NamespaceCounts) Namespaces() []string {
+	return slices.Sorted(maps.Keys(n))
+}
+
+// FilterReport records what K8Filter included and skipped while a K8sClient listed the cluster, so a filter can be
+// tried out without generating a BOM
+type FilterReport struct {
+	// Resources are the resource types GetAllComponents listed or skipped, in discovery order, followed by the Helm
+	// releases
+	Resources []ResourceReport
+	// Images are the sources GetAllImages took the images from
+	Images ImageReport
+}
+
+// ResourceReport is what K8Filter did with the objects of a single resource type. The objects the selectors sent to the
+// API server left out are not counted, since they were never listed.
+type ResourceReport struct {
+	GVR           schema.GroupVersionResource
+	Kind          string
+	LabelSelector string
+	FieldSelector string
+	// Purpose and Skip are from the ResourceQuery the resource type was listed with
+	Purpose  string
+	Skip     string
+	Included NamespaceCounts
+	Skipped  NamespaceCounts
+	// Err is why the resource type could not be listed
+	Err error
+}
+
+// ImageReport is what K8Filter did with the Pods and workloads the images are taken from
+type ImageReport struct {
+	// Namespaces are the namespaces the Pods and workloads were listed in
+	Namespaces []string
+	// The selectors sent to the API server when listing the Pods
+	LabelSelector     string
+	FieldSelector     string
+	IncludedPods      NamespaceCounts
+	SkippedPods       NamespaceCounts
+	IncludedWorkloads NamespaceCounts
+	SkippedWorkloads  NamespaceCounts
+}
+
+func (r *FilterReport) addResource(query ResourceQuery, result gvrResult) {
+	r.Resources = append(r.Resources, ResourceReport{
+		GVR:           query.GVR,
+		Kind:          query.Kind,
+		LabelSelector: query.LabelSelector,
+		FieldSelector: query.FieldSelector,
+		Purpose:       query.Purpose,
+		Skip:          query.Skip,
+		Included:      result.included,
+		Skipped:       result.skipped,
+		Err:           result.err,
+	})
+}
+
+func (r *FilterReport) addHelmReleases(driver string, included NamespaceCounts, skipped NamespaceCounts) {
+	if driver == HelmDriverNone {
+		return
+	}
+	release := guessResourceType(schema.FromAPIVersionAndKind(HelmReleaseAPIVersion, HelmReleaseKind))
+	r.Resources = append(r.Resources, ResourceReport{GVR: release.gvr, Kind: release.kind, Included: included, Skipped: skipped})
+}
+
+// count counts the Pods and workloads K8Filter selects the images of and the ones it skips
+func (i *ImageReport) count(pods []*corev1.Pod, templates []workloadTemplate, nsLabels namespaceLabels) {
+	for _, pod := range pods {
+		if nsLabels.selectsPod(pod) {
+			i.IncludedPods.add(pod.Namespace)
+		} else {
+			i.SkippedPods.add(pod.Namespace)
+		}
+	}
+	for _, workload := range templates {
+		if nsLabels.selectsWorkload(workload) {
+			i.IncludedWorkloads.add(workload.namespace)
+		} else {
+			i.SkippedWorkloads.add(workload.namespace)
+		}
+	}
+}
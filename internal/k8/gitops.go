@@ -0,0 +1,296 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+)
+
+// The GitOps tools whose objects clx records the provenance of
+const (
+	GitOpsFlux   = "flux"
+	GitOpsArgoCD = "argocd"
+)
+
+// The labels Flux sets on the objects a Kustomization or HelmRelease applies, and the annotation Argo CD sets on the
+// objects an Application syncs: <app>:<group>/<kind>:<namespace>/<name>, where the app is <namespace>_<name> for
+// Applications outside of the Argo CD namespace. Before Argo CD 3.0 the objects were tracked by default with the
+// instance label, set to the name of the Application.
+const (
+	fluxKustomizationNameLabel      = "kustomize.toolkit.fluxcd.io/name"
+	fluxKustomizationNamespaceLabel = "kustomize.toolkit.fluxcd.io/namespace"
+	fluxHelmReleaseNameLabel        = "helm.toolkit.fluxcd.io/name"
+	fluxHelmReleaseNamespaceLabel   = "helm.toolkit.fluxcd.io/namespace"
+	argoCDTrackingAnnotation        = "argocd.argoproj.io/tracking-id"
+	argoCDInstanceLabel             = "app.kubernetes.io/instance"
+)
+
+// The API groups of the GitOps objects
+const (
+	fluxSourceGroup        = "source.toolkit.fluxcd.io"
+	fluxKustomizationGroup = "kustomize.toolkit.fluxcd.io"
+	fluxHelmReleaseGroup   = "helm.toolkit.fluxcd.io"
+	argoCDGroup            = "argoproj.io"
+)
+
+// gitOpsKinds are the kinds of objects with GitOps provenance, by the tool that reconciles them. They are listed in full.
+var gitOpsKinds = map[schema.GroupKind]string{
+	{Group: fluxSourceGroup, Kind: "GitRepository"}:        GitOpsFlux,
+	{Group: fluxSourceGroup, Kind: "OCIRepository"}:        GitOpsFlux,
+	{Group: fluxSourceGroup, Kind: "HelmRepository"}:       GitOpsFlux,
+	{Group: fluxKustomizationGroup, Kind: "Kustomization"}: GitOpsFlux,
+	{Group: fluxHelmReleaseGroup, Kind: "HelmRelease"}:     GitOpsFlux,
+	{Group: argoCDGroup, Kind: "Application"}:              GitOpsArgoCD,
+}
+
+// gitOpsSource is the provenance of a GitOps object: where it reconciles from, what it applied and how that went
+type gitOpsSource struct {
+	tool          string
+	url           string
+	referenceType string // The CycloneDX type of the external reference to the URL
+	revision      string
+	syncStatus    string
+	healthStatus  string
+	sourceRef     objectRef // The source object the URL is read from when the object has no URL of its own
+}
+
+// objectRef names an object. The namespace is empty for cluster-scoped objects, and for Argo CD Applications tracked
+// without one.
+type objectRef struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+	// byLabel is set when an Argo CD Application was only found by the instance label, which Helm charts set to the
+	// release name as well. It is only taken as the manager when an Application of that name was listed.
+	byLabel bool
+}
+
+func (r objectRef) String() string {
+	if r.namespace == "" {
+		return fmt.Sprintf("%s/%s", r.kind, r.name)
+	}
+	return fmt.Sprintf("%s/%s/%s", r.kind, r.namespace, r.name)
+}
+
+// key returns the ownerKey of the object
+func (r objectRef) key() string {
+	return ownerKey(r.namespace, schema.GroupKind{Group: r.group, Kind: r.kind}, r.name)
+}
+
+// isGitOpsKind checks whether the objects of the group and kind have GitOps provenance
+func isGitOpsKind(gk schema.GroupKind) bool {
+	_, found := gitOpsKinds[gk]
+	return found
+}
+
+// gitOpsInfo returns the provenance of a GitOps object, or nil for any other object
+func gitOpsInfo(item unstructured.Unstructured) *gitOpsSource {
+	tool, found := gitOpsKinds[item.GroupVersionKind().GroupKind()]
+	if !found {
+		return nil
+	}
+	nested := func(fields ...string) string {
+		value, _, _ := unstructured.NestedString(item.Object, fields...)
+		return value
+	}
+	source := &gitOpsSource{tool: tool}
+	switch item.GetKind() {
+	case "GitRepository", "OCIRepository", "HelmRepository":
+		source.url = nested("spec", "url")
+		source.referenceType = "distribution"
+		if item.GetKind() == "GitRepository" {
+			source.referenceType = "vcs"
+		}
+		source.revision = nested("status", "artifact", "revision")
+	case "Kustomization":
+		source.sourceRef = fluxSourceRef(item, "spec", "sourceRef")
+		source.revision = nested("status", "lastAppliedRevision")
+	case "HelmRelease":
+		source.sourceRef = fluxSourceRef(item, "spec", "chart", "spec", "sourceRef")
+		if source.sourceRef.name == "" {
+			source.sourceRef = fluxSourceRef(item, "spec", "chartRef")
+		}
+		source.revision = nested("status", "lastAppliedRevision")
+		if history, _, _ := unstructured.NestedSlice(item.Object, "status", "history"); len(history) > 0 && source.revision == "" {
+			if latest, isMap := history[0].(map[string]interface{}); isMap {
+				source.revision, _, _ = unstructured.NestedString(latest, "chartVersion")
+			}
+		}
+	case "Application":
+		source.url = nested("spec", "source", "repoURL")
+		source.referenceType = "vcs"
+		if nested("spec", "source", "chart") != "" {
+			source.referenceType = "distribution"
+		}
+		if sources, _, _ := unstructured.NestedSlice(item.Object, "spec", "sources"); len(sources) > 0 && source.url == "" {
+			if first, isMap := sources[0].(map[string]interface{}); isMap {
+				source.url, _, _ = unstructured.NestedString(first, "repoURL")
+			}
+		}
+		source.revision = nested("status", "sync", "revision")
+		if revisions, _, _ := unstructured.NestedStringSlice(item.Object, "status", "sync", "revisions"); len(revisions) > 0 && source.revision == "" {
+			source.revision = revisions[0]
+		}
+		source.syncStatus = nested("status", "sync", "status")
+		source.healthStatus = nested("status", "health", "status")
+	}
+	if tool == GitOpsFlux {
+		source.syncStatus, source.healthStatus = fluxReadiness(item)
+	}
+	return source
+}
+
+// fluxSourceRef returns the source a Flux object references at the path. The source is in the namespace of the object
+// unless the reference names another one, and in the group of the Flux sources unless it names an apiVersion.
+func fluxSourceRef(item unstructured.Unstructured, fields ...string) objectRef {
+	sourceRef, _, _ := unstructured.NestedStringMap(item.Object, fields...)
+	ref := objectRef{group: fluxSourceGroup, kind: sourceRef["kind"], namespace: sourceRef["namespace"], name: sourceRef["name"]}
+	if apiVersion := sourceRef["apiVersion"]; apiVersion != "" {
+		ref.group = schema.FromAPIVersionAndKind(apiVersion, ref.kind).Group
+	}
+	if ref.namespace == "" {
+		ref.namespace = item.GetNamespace()
+	}
+	return ref
+}
+
+// fluxReadiness returns the reason of the Ready condition of a Flux object as its sync status, e.g.
+// ReconciliationSucceeded, and its status as the health status, e.g. Healthy
+func fluxReadiness(item unstructured.Unstructured) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, isMap := condition.(map[string]interface{})
+		if !isMap || fields["type"] != "Ready" {
+			continue
+		}
+		reason, _ := fields["reason"].(string)
+		switch fields["status"] {
+		case "True":
+			return reason, "Healthy"
+		case "False":
+			return reason, "Degraded"
+		default:
+			return reason, "Progressing"
+		}
+	}
+	return "", ""
+}
+
+// gitOpsManager returns the Flux Kustomization or HelmRelease, or the Argo CD Application, that applied the item. The
+// Application is taken from the instance label when the item has no tracking annotation.
+func gitOpsManager(item unstructured.Unstructured) (objectRef, bool) {
+	labels := item.GetLabels()
+	if name := labels[fluxKustomizationNameLabel]; name != "" {
+		return objectRef{group: fluxKustomizationGroup, kind: "Kustomization", namespace: labels[fluxKustomizationNamespaceLabel], name: name}, true
+	}
+	if name := labels[fluxHelmReleaseNameLabel]; name != "" {
+		return objectRef{group: fluxHelmReleaseGroup, kind: "HelmRelease", namespace: labels[fluxHelmReleaseNamespaceLabel], name: name}, true
+	}
+	if trackingID := item.GetAnnotations()[argoCDTrackingAnnotation]; trackingID != "" {
+		app, _, _ := strings.Cut(trackingID, ":")
+		ref := objectRef{group: argoCDGroup, kind: "Application", name: app}
+		if namespace, name, found := strings.Cut(app, "_"); found {
+			ref.namespace, ref.name = namespace, name
+		}
+		return ref, true
+	}
+	if app := labels[argoCDInstanceLabel]; app != "" {
+		return objectRef{group: argoCDGroup, kind: "Application", name: app, byLabel: true}, true
+	}
+	return objectRef{}, false
+}
+
+// addGitOpsInfo adds the provenance of a GitOps object, and the manager of an object applied by GitOps, to its
+// component. The URLs of the sources referenced by other objects are added by ownerGraph.addGitOpsProvenance.
+func addGitOpsInfo(item unstructured.Unstructured, component *model.Component) {
+	if source := gitOpsInfo(item); source != nil {
+		component.AddProperty(model.GitOpsTool, source.tool)
+		addSourceURL(component, source.url, source.referenceType, source.revision)
+		for _, field := range []struct{ property, value string }{
+			{model.GitOpsRevision, source.revision},
+			{model.GitOpsSyncStatus, source.syncStatus},
+			{model.GitOpsHealthStatus, source.healthStatus},
+		} {
+			if field.value != "" {
+				component.AddProperty(field.property, field.value)
+			}
+		}
+	}
+	if manager, managed := gitOpsManager(item); managed && !manager.byLabel {
+		component.AddProperty(model.GitOpsManagedBy, manager.String())
+	}
+}
+
+// addSourceURL adds the URL of the source as a property and as an external reference noting the revision applied from it
+func addSourceURL(component *model.Component, url string, referenceType string, revision string) {
+	if url == "" {
+		return
+	}
+	component.AddProperty(model.GitOpsSourceURL, url)
+	comment := ""
+	if revision != "" {
+		comment = "revision " + revision
+	}
+	component.ExternalReferences = append(component.ExternalReferences, model.ExternalReference{URL: url, Type: referenceType, Comment: comment})
+}
+
+// sourceOf returns the source object the GitOps object reconciles from: itself when it has a URL, otherwise the object
+// its source reference points to, if it was listed
+func (g *ownerGraph) sourceOf(source *gitOpsSource) *gitOpsSource {
+	if source.url != "" {
+		return source
+	}
+	if obj, found := g.objects[source.sourceRef.key()]; found && obj.gitOps != nil && obj.gitOps.url != "" {
+		return obj.gitOps
+	}
+	return nil
+}
+
+// managerOf returns the GitOps object that applied an object, if it was listed. Argo CD Applications tracked without a
+// namespace are looked up by name.
+func (g *ownerGraph) managerOf(ref objectRef) *gitOpsSource {
+	if ref.kind == "Application" && ref.namespace == "" {
+		return g.applications[ref.name]
+	}
+	if obj, found := g.objects[ref.key()]; found {
+		return obj.gitOps
+	}
+	return nil
+}
+
+// addGitOpsProvenance adds the URLs of the sources that Flux objects reference to their components, and the source URL
+// and revision of the GitOps object that applied each other component. The components tracked by the Argo CD instance
+// label get their manager here, once the Application is known to exist.
+func (g *ownerGraph) addGitOpsProvenance(components []model.Component) {
+	for i := range components {
+		obj, found := g.objects[componentKey(&components[i])]
+		if !found {
+			continue
+		}
+		if obj.gitOps != nil {
+			if source := g.sourceOf(obj.gitOps); source != nil && obj.gitOps.url == "" {
+				addSourceURL(&components[i], source.url, source.referenceType, obj.gitOps.revision)
+			}
+			continue
+		}
+		if obj.manager == nil {
+			continue
+		}
+		manager := g.managerOf(*obj.manager)
+		if manager == nil {
+			continue
+		}
+		if obj.manager.byLabel {
+			components[i].AddProperty(model.GitOpsManagedBy, obj.manager.String())
+		}
+		if manager.revision != "" {
+			components[i].AddProperty(model.GitOpsRevision, manager.revision)
+		}
+		if source := g.sourceOf(manager); source != nil {
+			addSourceURL(&components[i], source.url, source.referenceType, manager.revision)
+		}
+	}
+}
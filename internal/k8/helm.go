@@ -0,0 +1,326 @@
+package k8
+
+import (
+	"bytes"
+	"cluster-codex/internal/model"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The storage drivers of Helm that clx reads releases from, named like the HELM_DRIVER values
+const (
+	HelmDriverSecret    = "secret"
+	HelmDriverConfigMap = "configmap"
+	HelmDriverNone      = "none"
+)
+
+// HelmDrivers are the valid values of K8sClient.HelmDriver
+var HelmDrivers = []string{HelmDriverSecret, HelmDriverConfigMap, HelmDriverNone}
+
+// The kind and apiVersion of the components of Helm releases. Helm releases are not Kubernetes objects, so these
+// follow the type of the Secrets Helm stores them in rather than an API served by the cluster.
+const (
+	HelmReleaseKind       = "Release"
+	HelmReleaseAPIVersion = "helm.sh/release.v1"
+)
+
+// The annotations Helm sets on every object it renders
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// helmReleaseLabels select the current revision of every release in the Helm storage. Superseded revisions are
+// labelled status=superseded.
+var helmReleaseLabels = labels.Set{"owner": "helm", "status": "deployed"}
+
+// helmStorageResource returns the resource the driver stores releases in
+func helmStorageResource(driver string) schema.GroupVersionResource {
+	if driver == HelmDriverConfigMap {
+		return corev1.SchemeGroupVersion.WithResource("configmaps")
+	}
+	return corev1.SchemeGroupVersion.WithResource("secrets")
+}
+
+// listHelmReleases lists the current releases from the Helm storage in the namespaces of the query. The namespaces the
+// storage is not allowed to be listed in are left out with a warning, the same as with the none driver, so reading Helm
+// releases doesn't need the permission to list every Secret of the cluster.
+func listHelmReleases(ctx context.Context, client kubernetes.Interface, query ResourceQuery) ([]unstructured.Unstructured, error) {
+	if query.Skip != "" {
+		return nil, nil
+	}
+	var secrets []*corev1.Secret
+	var configMaps []*corev1.ConfigMap
+	var errs []error
+	for _, namespace := range query.namespaces() {
+		listOptions := metav1.ListOptions{}
+		query.listOptions(&listOptions)
+		var err error
+		if query.GVR.Resource == "configmaps" {
+			var list *corev1.ConfigMapList
+			if list, err = client.CoreV1().ConfigMaps(namespace).List(ctx, listOptions); err == nil {
+				configMaps = append(configMaps, pointers(list.Items)...)
+			}
+		} else {
+			var list *corev1.SecretList
+			if list, err = client.CoreV1().Secrets(namespace).List(ctx, listOptions); err == nil {
+				secrets = append(secrets, pointers(list.Items)...)
+			}
+		}
+		switch {
+		case apierrors.IsForbidden(err):
+			log.Warn().Msgf("Leaving out the Helm releases %s: not allowed to list %s, use --helm-driver none to not read them - error: %v",
+				namespaceDescription(namespace), query.GVR.Resource, err)
+		case err != nil && namespace != metav1.NamespaceAll:
+			errs = append(errs, fmt.Errorf("namespace %s: %w", namespace, err))
+		case err != nil:
+			errs = append(errs, err)
+		}
+	}
+	if query.GVR.Resource == "configmaps" {
+		return helmReleasesFromConfigMaps(configMaps), errors.Join(errs...)
+	}
+	return helmReleasesFromSecrets(secrets), errors.Join(errs...)
+}
+
+// namespaceDescription describes the namespace a request is sent to in the logs
+func namespaceDescription(namespace string) string {
+	if namespace == metav1.NamespaceAll {
+		return "in all namespaces"
+	}
+	return "in namespace " + namespace
+}
+
+// helmReleasesFromObjects decodes the current releases in the Helm storage of the driver from the objects, e.g. the
+// Secrets in a set of manifests
+func helmReleasesFromObjects(objects []unstructured.Unstructured, driver string) []unstructured.Unstructured {
+	storage := helmStorageResource(driver)
+	var secrets []*corev1.Secret
+	var configMaps []*corev1.ConfigMap
+	for _, item := range objects {
+		if driver == HelmDriverNone || item.GroupVersionKind().Group != "" || !helmReleaseLabels.AsSelector().Matches(labels.Set(item.GetLabels())) {
+			continue
+		}
+		var err error
+		switch {
+		case item.GetKind() == "Secret" && storage.Resource == "secrets":
+			var secret corev1.Secret
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &secret)
+			secrets = append(secrets, &secret)
+		case item.GetKind() == "ConfigMap" && storage.Resource == "configmaps":
+			var configMap corev1.ConfigMap
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &configMap)
+			configMaps = append(configMaps, &configMap)
+		}
+		if err != nil {
+			log.Warn().Msgf("Skipping Helm release %s/%s that cannot be read: %v", item.GetNamespace(), item.GetName(), err)
+		}
+	}
+	if storage.Resource == "configmaps" {
+		return helmReleasesFromConfigMaps(configMaps)
+	}
+	return helmReleasesFromSecrets(secrets)
+}
+
+// addHelmReleases adds the releases matching the filter to the components, and all of them to the owner graph so the
+// objects they rendered can be linked to them. It returns the number of releases included and skipped by namespace.
+func addHelmReleases(releases []unstructured.Unstructured, graph *ownerGraph, k8sResourceList *[]model.Component, nsLabels namespaceLabels) (included NamespaceCounts, skipped NamespaceCounts) {
+	for _, release := range releases {
+		isIncluded := shouldIncludeItem(release, guessResourceType(release.GroupVersionKind()), nsLabels)
+		graph.add(release, isIncluded)
+		if isIncluded {
+			addToComponentList(release, k8sResourceList)
+			included.add(release.GetNamespace())
+		} else {
+			skipped.add(release.GetNamespace())
+		}
+	}
+	return included, skipped
+}
+
+// pointers returns pointers to the items of a list
+func pointers[T any](items []T) []*T {
+	result := make([]*T, len(items))
+	for i := range items {
+		result[i] = &items[i]
+	}
+	return result
+}
+
+// helmReleasesFromSecrets decodes the releases stored in the Secrets, skipping the ones that are not Helm releases
+func helmReleasesFromSecrets(secrets []*corev1.Secret) []unstructured.Unstructured {
+	var releases []unstructured.Unstructured
+	for _, secret := range secrets {
+		if secret.Type != corev1.SecretType(HelmReleaseAPIVersion) {
+			continue
+		}
+		if release, decoded := decodeStoredRelease(secret.Namespace, secret.Name, string(secret.Data["release"])); decoded {
+			releases = append(releases, release)
+		}
+	}
+	return currentReleases(releases)
+}
+
+// helmReleasesFromConfigMaps decodes the releases stored in the ConfigMaps, skipping the ones that are not Helm releases
+func helmReleasesFromConfigMaps(configMaps []*corev1.ConfigMap) []unstructured.Unstructured {
+	var releases []unstructured.Unstructured
+	for _, configMap := range configMaps {
+		if configMap.Labels["owner"] != "helm" {
+			continue
+		}
+		if release, decoded := decodeStoredRelease(configMap.Namespace, configMap.Name, configMap.Data["release"]); decoded {
+			releases = append(releases, release)
+		}
+	}
+	return currentReleases(releases)
+}
+
+// decodeStoredRelease decodes a release the way Helm stores it: base64 encoded JSON, gzipped by Helm 3. The rendered
+// manifests, values and chart files are dropped since only the metadata of the release is used.
+func decodeStoredRelease(namespace string, storageName string, encoded string) (unstructured.Unstructured, bool) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Warn().Msgf("Skipping Helm release %s/%s that is not base64 encoded: %v", namespace, storageName, err)
+		return unstructured.Unstructured{}, false
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			log.Warn().Msgf("Skipping Helm release %s/%s that cannot be decompressed: %v", namespace, storageName, err)
+			return unstructured.Unstructured{}, false
+		}
+		data, err = io.ReadAll(reader)
+		if err != nil {
+			log.Warn().Msgf("Skipping Helm release %s/%s that cannot be decompressed: %v", namespace, storageName, err)
+			return unstructured.Unstructured{}, false
+		}
+	}
+
+	var release map[string]interface{}
+	if err := json.Unmarshal(data, &release); err != nil {
+		log.Warn().Msgf("Skipping Helm release %s/%s that is not valid JSON: %v", namespace, storageName, err)
+		return unstructured.Unstructured{}, false
+	}
+	name, _ := release["name"].(string)
+	if name == "" {
+		log.Warn().Msgf("Skipping Helm release %s/%s without a name", namespace, storageName)
+		return unstructured.Unstructured{}, false
+	}
+	for _, field := range []string{"manifest", "config", "hooks"} {
+		delete(release, field)
+	}
+	if chart, isMap := release["chart"].(map[string]interface{}); isMap {
+		release["chart"] = map[string]interface{}{"metadata": chart["metadata"]}
+	}
+
+	item := unstructured.Unstructured{Object: release}
+	item.SetAPIVersion(HelmReleaseAPIVersion)
+	item.SetKind(HelmReleaseKind)
+	item.SetName(name)
+	item.SetNamespace(namespace)
+	return item, true
+}
+
+// currentReleases keeps the latest revision of each release, sorted by namespace and name. Helm labels a single
+// revision as deployed, but more than one can be left behind by an interrupted upgrade.
+func currentReleases(releases []unstructured.Unstructured) []unstructured.Unstructured {
+	latest := make(map[string]unstructured.Unstructured)
+	for _, release := range releases {
+		key := release.GetNamespace() + "/" + release.GetName()
+		if current, found := latest[key]; !found || helmRevision(release) > helmRevision(current) {
+			latest[key] = release
+		}
+	}
+	current := make([]unstructured.Unstructured, 0, len(latest))
+	for _, release := range latest {
+		current = append(current, release)
+	}
+	slices.SortFunc(current, func(a, b unstructured.Unstructured) int {
+		return strings.Compare(a.GetNamespace()+"/"+a.GetName(), b.GetNamespace()+"/"+b.GetName())
+	})
+	return current
+}
+
+// helmRevision returns the revision of the release, which Helm stores as its version
+func helmRevision(release unstructured.Unstructured) int64 {
+	revision, _, _ := unstructured.NestedFieldNoCopy(release.Object, "version")
+	switch typed := revision.(type) {
+	case int64:
+		return typed
+	case float64:
+		return int64(typed)
+	}
+	return 0
+}
+
+// isHelmRelease checks whether the item is a release decoded from the Helm storage
+func isHelmRelease(item unstructured.Unstructured) bool {
+	return item.GetKind() == HelmReleaseKind && item.GetAPIVersion() == HelmReleaseAPIVersion
+}
+
+// addHelmReleaseInfo adds the chart, revision, status and deployment time of the release to its component
+func addHelmReleaseInfo(item unstructured.Unstructured, component *model.Component) {
+	for _, field := range []struct {
+		property string
+		path     []string
+	}{
+		{model.HelmChart, []string{"chart", "metadata", "name"}},
+		{model.HelmChartVersion, []string{"chart", "metadata", "version"}},
+		{model.HelmAppVersion, []string{"chart", "metadata", "appVersion"}},
+		{model.HelmStatus, []string{"info", "status"}},
+	} {
+		if value, _, _ := unstructured.NestedString(item.Object, field.path...); value != "" {
+			component.AddProperty(field.property, value)
+		}
+	}
+	if revision := helmRevision(item); revision > 0 {
+		component.AddProperty(model.HelmRevision, strconv.FormatInt(revision, 10))
+	}
+	if lastDeployed, _, _ := unstructured.NestedString(item.Object, "info", "last_deployed"); lastDeployed != "" {
+		if deployed, err := time.Parse(time.RFC3339Nano, lastDeployed); err == nil {
+			lastDeployed = deployed.UTC().Format(time.RFC3339)
+		}
+		component.AddProperty(model.HelmLastDeployed, lastDeployed)
+	}
+}
+
+// helmReleaseKey returns the ownerKey of the Helm release that rendered the item, or "" when Helm did not render it.
+// Objects rendered into another namespace, or cluster-scoped ones, name the namespace of the release in an annotation.
+func helmReleaseKey(item unstructured.Unstructured) string {
+	annotations := item.GetAnnotations()
+	name := annotations[helmReleaseNameAnnotation]
+	if name == "" || isHelmRelease(item) {
+		return ""
+	}
+	namespace := annotations[helmReleaseNamespaceAnnotation]
+	if namespace == "" {
+		namespace = item.GetNamespace()
+	}
+	return ownerKey(namespace, schema.FromAPIVersionAndKind(HelmReleaseAPIVersion, HelmReleaseKind).GroupKind(), name)
+}
+
+// ValidateHelmDriver checks that the driver is one of the HelmDrivers
+func ValidateHelmDriver(driver string) error {
+	if !slices.Contains(HelmDrivers, driver) {
+		return fmt.Errorf("unknown Helm driver %q, expected one of %s", driver, strings.Join(HelmDrivers, ", "))
+	}
+	return nil
+}
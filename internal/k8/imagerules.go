@@ -0,0 +1,187 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// The source of the images found by the image rules
+const ruleSource = "rule"
+
+// imageRules are the compiled rules set by SetImageRules
+var imageRules []imageRule
+
+// imageRule is an ImageRule with its JSONPath expressions checked
+type imageRule struct {
+	gvk   schema.GroupVersionKind // The version is empty when the rule applies to every version
+	paths []string
+}
+
+// ruleImage is an image reference found by an image rule
+type ruleImage struct {
+	image string
+	path  string // The JSONPath expression that found the image
+}
+
+// customResourceImages are the images the image rules found in a single object
+type customResourceImages struct {
+	groupKind       schema.GroupKind
+	namespace       string
+	name            string
+	ownerReferences []metav1.OwnerReference
+	images          []ruleImage
+}
+
+// SetImageRules parses the JSONPath expressions of the rules and uses them for the images of custom resources
+func SetImageRules(rules model.ImageRules) error {
+	compiled := make([]imageRule, 0, len(rules.Rules))
+	for idx, rule := range rules.Rules {
+		if rule.Kind == "" {
+			return fmt.Errorf("image rule %d has no kind", idx+1)
+		}
+		if len(rule.Paths) == 0 {
+			return fmt.Errorf("image rule for %s has no paths", rule.Kind)
+		}
+		parsed := imageRule{gvk: schema.GroupVersionKind{Group: rule.Group, Version: rule.Version, Kind: rule.Kind}}
+		for _, path := range rule.Paths {
+			if _, err := parsePath(rule.Kind, path); err != nil {
+				return fmt.Errorf("image rule for %s has an invalid path %s: %w", rule.Kind, path, err)
+			}
+			parsed.paths = append(parsed.paths, path)
+		}
+		compiled = append(compiled, parsed)
+	}
+	imageRules = compiled
+	return nil
+}
+
+// parsePath parses a JSONPath expression, adding the braces around it when they are left out. Missing keys are not an
+// error since optional fields are left out of most objects.
+func parsePath(name string, path string) (*jsonpath.JSONPath, error) {
+	expression := path
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+	parser := jsonpath.New(name).AllowMissingKeys(true)
+	if err := parser.Parse(expression); err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+// findPath evaluates the JSONPath expression against the object. A parsed expression keeps the state of its last
+// evaluation, so each evaluation parses its own rather than sharing one between the workers.
+func findPath(name string, path string, object map[string]interface{}) ([][]reflect.Value, error) {
+	parser, err := parsePath(name, path)
+	if err != nil {
+		return nil, err
+	}
+	return parser.FindResults(object)
+}
+
+// matches checks whether the rule applies to the objects of the group, version and kind
+func (r imageRule) matches(gvk schema.GroupVersionKind) bool {
+	return r.gvk.Group == gvk.Group && r.gvk.Kind == gvk.Kind && (r.gvk.Version == "" || r.gvk.Version == gvk.Version)
+}
+
+// hasImageRule checks whether any image rule applies to the objects of the group, version and kind
+func hasImageRule(gvk schema.GroupVersionKind) bool {
+	return slices.ContainsFunc(imageRules, func(rule imageRule) bool { return rule.matches(gvk) })
+}
+
+// findRuleImages returns the images the image rules find in the object, or nil when none apply. Values that are not
+// image references are skipped.
+func findRuleImages(item unstructured.Unstructured) *customResourceImages {
+	gvk := item.GroupVersionKind()
+	var images []ruleImage
+	for _, rule := range imageRules {
+		if !rule.matches(gvk) {
+			continue
+		}
+		for _, path := range rule.paths {
+			results, err := findPath(rule.gvk.Kind, path, item.Object)
+			if err != nil {
+				log.Debug().Msgf("Image rule path %s does not match %s %s/%s: %v", path, item.GetKind(), item.GetNamespace(), item.GetName(), err)
+				continue
+			}
+			for _, values := range results {
+				for _, value := range values {
+					image, isString := value.Interface().(string)
+					if !isString || image == "" {
+						continue
+					}
+					if _, err := name.ParseReference(image); err != nil {
+						log.Warn().Msgf("Skipping %q found by %s in %s %s/%s: not an image reference", image, path, item.GetKind(), item.GetNamespace(), item.GetName())
+						continue
+					}
+					if !slices.ContainsFunc(images, func(found ruleImage) bool { return found.image == image }) {
+						images = append(images, ruleImage{image: image, path: path})
+					}
+				}
+			}
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	return &customResourceImages{
+		groupKind:       item.GroupVersionKind().GroupKind(),
+		namespace:       item.GetNamespace(),
+		name:            item.GetName(),
+		ownerReferences: item.GetOwnerReferences(),
+		images:          images,
+	}
+}
+
+// ruleContainer is an image found by an image rule, in the shape of a container
+type ruleContainer struct {
+	ruleImage
+}
+
+func (c ruleContainer) GetName() string  { return c.path }
+func (c ruleContainer) GetImage() string { return c.image }
+
+// ruleImageReferences returns the image references the image rules found
+func ruleImageReferences(resources []customResourceImages) []string {
+	var images []string
+	for _, resource := range resources {
+		for _, found := range resource.images {
+			images = append(images, found.image)
+		}
+	}
+	return images
+}
+
+// inNamespaces returns the objects in the namespaces, along with all cluster-scoped objects
+func inNamespaces(resources []customResourceImages, namespaceList []string) []customResourceImages {
+	var filtered []customResourceImages
+	for _, resource := range resources {
+		if resource.namespace == "" || slices.Contains(namespaceList, resource.namespace) {
+			filtered = append(filtered, resource)
+		}
+	}
+	return filtered
+}
+
+// addRuleImages adds or updates the images the image rules found. Each image is attributed to the custom resource
+// declaring it, and is declared rather than running since no Pod status vouches for it.
+func (ic *imageCollector) addRuleImages(resources []customResourceImages) {
+	for _, resource := range resources {
+		primaryOwnerRef := fmt.Sprintf("%s/%s", resource.groupKind.Kind, resource.name)
+		owners := ic.graph.selfOrOwners(resource.namespace, resource.groupKind, resource.name, resource.ownerReferences)
+		for _, found := range resource.images {
+			image := addOrUpdateImageInComponentList(ruleContainer{found}, resource.namespace, &ic.componentList, nil, ruleSource, primaryOwnerRef, ic.imageMap, ic.templateDigest)
+			linkImage(image, "", owners)
+			ic.addOccurrence(image, resource.namespace, primaryOwnerRef, "", found.path, ruleSource)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/registry"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/set"
+	"slices"
+	"strings"
+)
+
+// Values of the image state property
+const (
+	// ImageStateRunning is the state of an image used by at least one Pod
+	ImageStateRunning = "running"
+	// ImageStateDeclared is the state of an image that is only declared by pod templates, e.g. of a CronJob between
+	// runs or a Deployment scaled to zero
+	ImageStateDeclared = "declared"
+)
+
+// ownerLookup returns the owner references of the named object. It is used to climb from a Pod's ReplicaSet or Job to
+// the workload that manages it.
+type ownerLookup func(ctx context.Context, groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error)
+
+// digestLookup returns the digest of an image whose Pod status does not have one, or "" when it is not known
+type digestLookup func(image string) string
+
+// imageCollector builds the list of image components from the containers of Pods, making sure each image appears
+// only once however many workloads run it.
+type imageCollector struct {
+	lookup        ownerLookup
+	graph         *ownerGraph                 // nil when the owners of the images are not known
+	digests       digestLookup                // nil unless digests are resolved from the registry
+	imageMap      map[string]*model.Component // A map of the image purl to make sure each one appears only once
+	occurrences   map[*model.Component][]*occurrence
+	componentList []*model.Component
+	// The digests of the images run by Pods, keyed by the image reference in their spec. Pod templates referencing the
+	// same image get the same digest, so the image a workload declares and the image its Pods run are one component.
+	runningDigests map[string]string
+}
+
+// occurrence is a container of a workload that runs an image
+type occurrence struct {
+	namespace string
+	owner     string // Kind/Name of the workload, or of the Pod when it has no owner
+	container string
+	role      string   // ephemeral, init or main
+	pods      []string // The Pods of the workload running the container, none for a pod template
+	replicas  int32    // The replicas the pod templates of the workload declare
+}
+
+func newImageCollector(lookup ownerLookup, graph *ownerGraph) *imageCollector {
+	return &imageCollector{
+		lookup:         lookup,
+		graph:          graph,
+		imageMap:       make(map[string]*model.Component),
+		occurrences:    make(map[*model.Component][]*occurrence),
+		runningDigests: make(map[string]string),
+	}
+}
+
+// resolveDigests makes the collector look up the digests that are missing from the Pod statuses in the registry.
+// The images are resolved up front, in parallel.
+func (ic *imageCollector) resolveDigests(ctx context.Context, resolver *registry.Resolver, images []string) {
+	if resolver == nil {
+		return
+	}
+	resolver.Prefetch(ctx, images)
+	ic.digests = func(image string) string {
+		return resolver.Digest(ctx, image)
+	}
+}
+
+// specImages returns the images of the containers of the pod specs
+func specImages(specs []*corev1.PodSpec) []string {
+	var images []string
+	for _, spec := range specs {
+		for _, container := range spec.EphemeralContainers {
+			images = append(images, container.Image)
+		}
+		for _, container := range spec.InitContainers {
+			images = append(images, container.Image)
+		}
+		for _, container := range spec.Containers {
+			images = append(images, container.Image)
+		}
+	}
+	return images
+}
+
+// podSpecs returns the specs of the Pods
+func podSpecs(pods []*corev1.Pod) []*corev1.PodSpec {
+	specs := make([]*corev1.PodSpec, 0, len(pods))
+	for _, pod := range pods {
+		specs = append(specs, &pod.Spec)
+	}
+	return specs
+}
+
+// addPod adds or updates the images of all the ephemeral, init and main containers of the Pod
+func (ic *imageCollector) addPod(ctx context.Context, pod *corev1.Pod) {
+	var primaryOwnerRef string
+	namespace := pod.Namespace
+	ownerReferenceSet := set.Set[string]{}
+	if len(pod.OwnerReferences) == 0 {
+		log.Info().Msgf("No owner reference found for pod: %s", pod.Name)
+	} else {
+		//Special cases for Owner References:
+		//	1. ReplicaSets can be owned by Deployments or custom CRDs
+		//	2. Jobs can be owned by CronJobs or custom CRDs
+		primaryOwnerRef = getPrimaryOwnerReference(ctx, ic.lookup, pod.OwnerReferences, &ownerReferenceSet, namespace)
+	}
+	owners := ic.graph.selfOrOwners(namespace, schema.GroupKind{Kind: "Pod"}, pod.Name, pod.OwnerReferences)
+	ic.addPodSpec(namespace, pod.Name, &pod.Spec, &pod.Status, primaryOwnerRef, owners, 0)
+}
+
+// addTemplates adds the images declared by the pod templates of the workloads. It is called after all the Pods are
+// added, so the images that are also running get the digest from the Pod statuses.
+func (ic *imageCollector) addTemplates(ctx context.Context, templates []workloadTemplate) {
+	for _, workload := range templates {
+		primaryOwnerRef := fmt.Sprintf("%s/%s", workload.kind, workload.name)
+		if len(workload.ownerReferences) > 0 {
+			// e.g. a ReplicaSet template is attributed to its Deployment, the same as its Pods would be
+			ownerReferenceSet := set.Set[string]{}
+			primaryOwnerRef = getPrimaryOwnerReference(ctx, ic.lookup, workload.ownerReferences, &ownerReferenceSet, workload.namespace)
+		}
+		owners := ic.graph.selfOrOwners(workload.namespace, schema.GroupKind{Group: workload.group, Kind: workload.kind}, workload.name, workload.ownerReferences)
+		ic.addPodSpec(workload.namespace, "", &workload.template.Spec, &corev1.PodStatus{}, primaryOwnerRef, owners, workload.replicas)
+	}
+}
+
+// addPodSpec adds or updates the images of the containers of a Pod, or of a pod template declaring replicas when podName
+// is ""
+func (ic *imageCollector) addPodSpec(namespace string, podName string, spec *corev1.PodSpec, status *corev1.PodStatus, primaryOwnerRef string, owners []string, replicas int32) {
+	digests := ic.digests
+	if podName == "" {
+		digests = ic.templateDigest
+	}
+	add := func(container ContainerLike, containerStatuses []v1.ContainerStatus, source string) {
+		image := addOrUpdateImageInComponentList(container, namespace, &ic.componentList, containerStatuses, source, primaryOwnerRef, ic.imageMap, digests)
+		if image == nil {
+			return
+		}
+		if podName != "" && len(image.Hashes) > 0 {
+			ic.runningDigests[container.GetImage()] = "sha256:" + image.Hashes[0].Value
+		}
+		linkImage(image, spec.NodeName, owners)
+		found := ic.addOccurrence(image, namespace, primaryOwnerRef, podName, container.GetName(), source)
+		// A Deployment and its old ReplicaSets, scaled to zero, are one occurrence
+		found.replicas = max(found.replicas, replicas)
+	}
+
+	// Ephemeral containers are added only after the Pod is running but do not affect pod health
+	for _, container := range spec.EphemeralContainers {
+		add(EphemeralContainerWrapper{container}, status.EphemeralContainerStatuses, "ephemeral")
+	}
+	for _, container := range spec.InitContainers {
+		add(ContainerWrapper{container}, status.InitContainerStatuses, "init")
+	}
+	for _, container := range spec.Containers {
+		add(ContainerWrapper{container}, status.ContainerStatuses, "main")
+	}
+}
+
+// templateDigest returns the digest of an image declared by a pod template: the digest Pods run it with, otherwise the
+// digest from the registry when digests are resolved
+func (ic *imageCollector) templateDigest(image string) string {
+	if digest, running := ic.runningDigests[image]; running {
+		return digest
+	}
+	if ic.digests != nil {
+		return ic.digests(image)
+	}
+	return ""
+}
+
+// linkImage records the node the image runs on and the components that own it. Pod templates and Pods that are not
+// scheduled yet have no node.
+func linkImage(image *model.Component, nodeName string, owners []string) {
+	if image == nil {
+		return
+	}
+	if nodeName != "" {
+		image.AddProperty(model.ComponentNode, nodeName)
+	}
+	addImageOwners(image, owners)
+}
+
+// addOccurrence records that the container of the workload runs the image, and returns the occurrence. The Pods of the
+// same workload running the same container are counted as replicas of a single occurrence.
+func (ic *imageCollector) addOccurrence(image *model.Component, namespace string, primaryOwnerRef string, podName string, container string, role string) *occurrence {
+	owner := primaryOwnerRef
+	if owner == "" {
+		owner = fmt.Sprintf("Pod/%s", podName)
+	}
+	var found *occurrence
+	for _, o := range ic.occurrences[image] {
+		if o.namespace == namespace && o.owner == owner && o.container == container && o.role == role {
+			found = o
+			break
+		}
+	}
+	if found == nil {
+		found = &occurrence{namespace: namespace, owner: owner, container: container, role: role}
+		ic.occurrences[image] = append(ic.occurrences[image], found)
+	}
+	if podName != "" {
+		found.pods = append(found.pods, podName)
+	}
+	return found
+}
+
+// evidence returns the occurrences of the image in the order they were first seen
+func (ic *imageCollector) evidence(image *model.Component) *model.Evidence {
+	occurrences := ic.occurrences[image]
+	if len(occurrences) == 0 {
+		return nil
+	}
+	evidence := &model.Evidence{}
+	for _, o := range occurrences {
+		// The replicas are the Pods that run the container, or the replicas the workload declares when none does
+		replicas := o.replicas
+		if len(o.pods) > 0 {
+			replicas = int32(len(o.pods))
+		}
+		details := fmt.Sprintf("role=%s, replicas=%d", o.role, replicas)
+		if len(o.pods) > 0 {
+			details = fmt.Sprintf("%s, pods=%s", details, strings.Join(o.pods, " "))
+		}
+		evidence.Occurrences = append(evidence.Occurrences, model.Occurrence{
+			Location:          namespacedOwner(o.namespace, o.owner),
+			Symbol:            o.container,
+			AdditionalContext: details,
+		})
+	}
+	return evidence
+}
+
+// namespacedOwner returns the <namespace>/<kind>/<name> of the owner of an image, or its <kind>/<name> when it is
+// cluster-scoped, since workloads of the same name can run in several namespaces
+func namespacedOwner(namespace string, owner string) string {
+	if namespace == "" {
+		return owner
+	}
+	return namespace + "/" + owner
+}
+
+// state returns whether any Pod runs the image, or the image is only declared by pod templates
+func (ic *imageCollector) state(image *model.Component) string {
+	for _, o := range ic.occurrences[image] {
+		if len(o.pods) > 0 {
+			return ImageStateRunning
+		}
+	}
+	return ImageStateDeclared
+}
+
+// components returns the collected image components in the order they were first seen
+func (ic *imageCollector) components() []model.Component {
+	var finalList []model.Component
+	for _, compPtr := range ic.componentList {
+		compPtr.Evidence = ic.evidence(compPtr)
+		compPtr.AddProperty(model.ImageState, ic.state(compPtr))
+		finalList = append(finalList, *compPtr) // Dereference pointers before returning
+	}
+	return finalList
+}
+
+func getPrimaryOwnerReference(ctx context.Context, lookup ownerLookup, ownerRefs []metav1.OwnerReference, ownerReferenceSet *set.Set[string], namespace string) string {
+
+	//Special cases for Owner References:
+	//	1. ReplicaSets can be owned by Deployments or custom CRDs
+	//	2. Jobs can be owned by CronJobs or custom CRDs
+	ownerReference := getOnePrimaryOwnerReference(ownerRefs)
+
+	ownerReferenceKey := fmt.Sprintf("%s/%s", ownerReference.Kind, ownerReference.Name)
+	if ownerReferenceSet.Has(ownerReferenceKey) {
+		// Already identified the owner reference for this pod
+		return ownerReferenceKey
+	}
+
+	if groupKind := ownerGroupKind(ownerReference); slices.Contains(podOwnerKinds, groupKind) { //ReplicaSets can be managed by Deployments and Jobs by CronJobs
+		parentRefs, err := lookup(ctx, groupKind, namespace, ownerReference.Name)
+		if err != nil {
+			log.Err(err).Msgf("Error retrieving %s: %s", ownerReference.Kind, ownerReference.Name)
+			return ownerReferenceKey
+		}
+
+		if len(parentRefs) > 0 {
+			primaryOwnerRef := getOnePrimaryOwnerReference(parentRefs)
+			ownerReferenceKey = fmt.Sprintf("%s/%s", primaryOwnerRef.Kind, primaryOwnerRef.Name)
+		}
+	}
+	ownerReferenceSet.Insert(ownerReferenceKey)
+	return ownerReferenceKey
+}
+
+func addOrUpdateImageInComponentList(container ContainerLike, namespace string, k8sResourceList *[]*model.Component, containerStatuses []v1.ContainerStatus, source string, primaryOwnerRef string, imageMap map[string]*model.Component, digests digestLookup) *model.Component {
+	var properties []model.Property
+	var imageId = ""
+	var imageSha = ""
+	var version = ""
+	for _, containerStatus := range containerStatuses {
+		if containerStatus.Name == container.GetName() {
+			if containerStatus.State.Terminated != nil {
+				return nil // Ignore the container which is already terminated
+			}
+
+			imageId = containerStatus.ImageID
+			sha256 := "sha256:"
+			if strings.Contains(imageId, sha256) {
+				imageSha = fmt.Sprintf("%s%s", sha256, strings.Split(imageId, sha256)[1])
+			} else if digests == nil {
+				log.Error().Msgf("SHA256 digest not found in image: %s - continuing.", imageId)
+			}
+			break
+		}
+	}
+	// Pending Pods, pod templates and some runtimes have no digest in the status
+	if imageSha == "" && digests != nil {
+		imageSha = digests(container.GetImage())
+	}
+
+	component := &model.Component{
+		Type:       "container",
+		Name:       container.GetImage(), //Pass the full image name and split it into name and version in the function addPropertiesForImageComponent
+		PackageURL: "",
+		Properties: properties,
+		Licenses:   nil,
+		Hashes:     nil,
+	}
+	component.PackageURL, version = GetImagePkgID(component, imageSha)
+	if algorithm, hash, found := strings.Cut(imageSha, ":"); found && algorithm == "sha256" {
+		component.Hashes = []model.Hash{{Algorithm: "SHA-256", Value: hash}}
+	}
+
+	if c, exists := imageMap[component.PackageURL]; exists {
+		updateImageInComponentList(c, namespace, source, primaryOwnerRef, version)
+		log.Debug().Msgf("Updated existing image for resource: %s, kind: image, namespace: %s", container.GetImage(), namespace)
+		return c
+	}
+	c := addImageToComponentList(component, namespace, k8sResourceList, source, primaryOwnerRef, imageMap)
+	log.Debug().Msgf("Added new image for resource: %s, kind: image, namespace: %s", container.GetImage(), namespace)
+	return c
+}
+
+// updateImageInComponentList adds the namespace, source, owner and version of another container running the image.
+// An image pinned by digest can be referenced by several tags, so each one is kept.
+func updateImageInComponentList(component *model.Component, namespace string, source string, primaryOwner string, version string) {
+	if namespace != "" {
+		component.AddProperty(model.ComponentNamespace, namespace)
+	}
+	component.AddProperty(model.ComponentSourceRef, source)
+	if primaryOwner != "" {
+		component.AddProperty(model.ComponentOwnerRef, namespacedOwner(namespace, primaryOwner))
+	}
+	component.AddProperty(model.ComponentVersion, version)
+}
+
+func addImageToComponentList(component *model.Component, namespace string, k8sResourceList *[]*model.Component, source string, primaryOwner string, imageMap map[string]*model.Component) *model.Component {
+	// Add a new component
+	imageMap[component.PackageURL] = component
+	component.AddProperty(model.ComponentKind, "Image")
+	// Images found in cluster-scoped custom resources have no namespace
+	if namespace != "" {
+		component.AddProperty(model.ComponentNamespace, namespace)
+	}
+	component.AddProperty(model.ComponentSourceRef, source)
+	if primaryOwner != "" {
+		component.AddProperty(model.ComponentOwnerRef, namespacedOwner(namespace, primaryOwner))
+	}
+	component.AddProperty(model.ComponentVersion, component.Version)
+
+	*k8sResourceList = append(*k8sResourceList, component)
+	log.Debug().Msgf("Created new image for resource: %s, kind: image, namespace: %s", component.Name, namespace)
+	return component
+}
@@ -2,31 +2,34 @@ package k8
 
 import (
 	"cluster-codex/internal/model"
-	"cluster-codex/internal/utils"
+	"cluster-codex/internal/registry"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/utils/set"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"net/url"
-	"os"
-	"strings"
+	"slices"
+	"sync"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . K8sClientInterface
 type K8sClientInterface interface {
 	GetAllComponents(ctx context.Context) ([]model.Component, []string, error)
 	GetAllImages(ctx context.Context, namespaceList []string) ([]model.Component, error)
+	GetClusterInfo(ctx context.Context) (ClusterInfo, error)
 }
 
 // K8sClient is the concrete implementation of the K8sClientInterface
@@ -35,7 +38,61 @@ type K8sClient struct {
 	Config        *rest.Config
 	Client        kubernetes.Interface
 	DynamicClient dynamic.Interface
-	Discovery     discovery.DiscoveryInterface
+	// MetadataClient is used to list resources whose spec is never read. When nil, full objects are listed with the DynamicClient.
+	MetadataClient metadata.Interface
+	Discovery      discovery.DiscoveryInterface
+	// Concurrency is the number of GVRs listed in parallel. Values <= 0 use DefaultConcurrency.
+	Concurrency int
+	// ResourceErrors holds the GVRs that could not be listed during the last GetAllComponents call.
+	ResourceErrors []ResourceError
+	// DigestResolver looks up the digests missing from the Pod statuses in the registry. Nil disables the lookups.
+	DigestResolver *registry.Resolver
+	// HelmDriver is the storage the Helm releases are read from, one of the HelmDrivers. Empty means HelmDriverSecret.
+	HelmDriver string
+	// Report records what K8Filter included and skipped during the GetAllComponents and GetAllImages calls. Nil
+	// disables the recording.
+	Report *FilterReport
+
+	owners     *ownerGraph            // The owner references of the objects from the last GetAllComponents call
+	ruleImages []customResourceImages // The images the image rules found during the last GetAllComponents call
+	// The labels of the namespaces, listed by the last GetAllComponents call when K8Filter selects namespaces by label
+	namespaceLabels namespaceLabels
+	gitVersion      string // The version of the API server read by the last GetClusterInfo call
+}
+
+// DefaultConcurrency is the number of GVRs listed in parallel when K8sClient.Concurrency is not set
+const DefaultConcurrency = 8
+
+// ResourceError records a failure to list a single GVR
+type ResourceError struct {
+	GVR schema.GroupVersionResource
+	Err error
+}
+
+func (e ResourceError) Error() string {
+	return fmt.Sprintf("failed to list %s: %v", e.GVR.String(), e.Err)
+}
+
+func (e ResourceError) Unwrap() error { return e.Err }
+
+// resourceType is a single GVR from discovery along with its kind, and the other names a filter can refer to it by
+type resourceType struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	singular   string
+	shortNames []string
+	verbs      metav1.Verbs
+	namespaced bool
+}
+
+// gvrResult is what a worker collected for a single GVR
+type gvrResult struct {
+	components []model.Component
+	namespaces []string
+	ruleImages []customResourceImages
+	included   NamespaceCounts // The objects the filter included and skipped
+	skipped    NamespaceCounts
+	err        error
 }
 
 // pod.Status.EphemeralContainerStatuses has a different return type from
@@ -63,6 +120,12 @@ type EphemeralContainerWrapper struct {
 func (c EphemeralContainerWrapper) GetName() string  { return c.Name }
 func (c EphemeralContainerWrapper) GetImage() string { return c.Image }
 
+// Kinds whose spec or status is read, e.g. the node info in addNodeInfo. Kinds with an image rule or a version rule
+// reading a path, and the gitOpsKinds, are read as well. All other kinds are listed metadata-only, which keeps payloads like Secret and ConfigMap data out of memory.
+var fullObjectKinds = map[string]struct{}{
+	"Node": {},
+}
+
 var unnecessaryResources = map[string]struct{}{
 	"bindings":                  {},
 	"tokenreviews":              {},
@@ -75,227 +138,472 @@ var unnecessaryResources = map[string]struct{}{
 	"ksh":                       {},
 }
 
-// NewClientset takes a path to a kubeconfig file and returns a Kubernetes clientset.
-func GetClient() (*K8sClient, error) {
+// InClusterContext is the context name recorded for clients using the in-cluster service account
+const InClusterContext = "in-cluster"
+
+// ClientOptions holds the standard kubectl connection flags
+type ClientOptions struct {
+	// Kubeconfig is the path to the kubeconfig file. When empty, $KUBECONFIG or ~/.kube/config is used.
+	Kubeconfig string
+	// Context is the kubeconfig context to use. When empty, the current context is used.
+	Context string
+	// Impersonate is the user to impersonate for the requests
+	Impersonate string
+	// ImpersonateGroups are the groups to impersonate for the requests
+	ImpersonateGroups []string
+	// RequestTimeout is how long to wait for a single request, e.g. "30s". Zero or empty means no timeout.
+	RequestTimeout string
+	// InCluster uses the service account of the Pod clx is running in instead of a kubeconfig
+	InCluster bool
+}
+
+// GetRestConfig builds the REST config from the options and returns it along with the name of the context in use.
+// When no kubeconfig can be found, it falls back to the in-cluster service account so clx can run as a CronJob.
+func GetRestConfig(opts ClientOptions) (*rest.Config, string, error) {
+	var config *rest.Config
+	var contextName string
+	var err error
+
+	if opts.InCluster {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, "", fmt.Errorf("error creating in-cluster config: %w", err)
+		}
+		contextName = InClusterContext
+	} else {
+		clientConfig := getClientConfig(opts)
+		rawConfig, rawErr := clientConfig.RawConfig()
+		config, err = clientConfig.ClientConfig()
+		if err != nil {
+			// No kubeconfig at all, so try the service account if we're running in a Pod
+			if !clientcmd.IsEmptyConfig(err) || opts.Kubeconfig != "" || opts.Context != "" {
+				return nil, "", fmt.Errorf("error creating config: %w", err)
+			}
+			var inClusterErr error
+			config, inClusterErr = rest.InClusterConfig()
+			if inClusterErr != nil {
+				return nil, "", fmt.Errorf("no kubeconfig found and not running in a cluster: %w", err)
+			}
+			contextName = InClusterContext
+		} else {
+			contextName = opts.Context
+			if contextName == "" && rawErr == nil {
+				contextName = rawConfig.CurrentContext
+			}
+		}
+	}
+
+	// The kubeconfig overrides already handle impersonation, but the in-cluster config has to be set directly
+	if opts.Impersonate != "" || len(opts.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{UserName: opts.Impersonate, Groups: opts.ImpersonateGroups}
+	}
+	if opts.RequestTimeout != "" {
+		timeout, err := clientcmd.ParseTimeout(opts.RequestTimeout)
+		if err != nil {
+			return nil, "", err
+		}
+		config.Timeout = timeout
+	}
+	return config, contextName, nil
+}
 
-	kubeConfigPath := os.Getenv("KUBECONFIG")
-	log.Info().Msgf("Reading kubeconfig path %s", kubeConfigPath)
+// GetContexts returns the names of all the contexts in the kubeconfig, sorted by name
+func GetContexts(opts ClientOptions) ([]string, error) {
+	rawConfig, err := getClientConfig(opts).RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error reading kubeconfig: %w", err)
+	}
+	var contexts []string
+	for name := range rawConfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	slices.Sort(contexts)
+	return contexts, nil
+}
 
-	if kubeConfigPath == "" {
-		kubeConfigPath = os.Getenv("HOME") + "/.kube/config"
+func getClientConfig(opts ClientOptions) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if opts.Kubeconfig != "" {
+		loadingRules.ExplicitPath = opts.Kubeconfig
 	}
-	if _, err := os.Stat(kubeConfigPath); os.IsNotExist(err) {
-		log.Fatal().Msgf("File does not exist: %s", kubeConfigPath)
-	} else if err != nil {
-		log.Fatal().Msgf("Error accessing file: %v", err)
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: opts.Context,
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       opts.Impersonate,
+			ImpersonateGroups: opts.ImpersonateGroups,
+		},
 	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+// GetClient creates a client for the cluster selected by the options
+func GetClient(opts ClientOptions) (*K8sClient, error) {
+	config, contextName, err := GetRestConfig(opts)
 	if err != nil {
-		log.Printf("Error creating config: %v", err)
 		return nil, err
 	}
+	log.Info().Msgf("Using context %s", contextName)
+	return NewClientForConfig(config, contextName)
+}
 
+// NewClientForConfig creates all the clients clx needs from the REST config
+func NewClientForConfig(config *rest.Config, contextName string) (*K8sClient, error) {
 	// Create the clientset from the config.
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Err(err).Msg("Error creating clientset")
-		return nil, err
+		return nil, fmt.Errorf("error creating clientset: %w", err)
 	}
 
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
-		log.Err(err).Msg("Error creating dynamic client")
-		return nil, err
+		return nil, fmt.Errorf("error creating dynamic client: %w", err)
 	}
 
-	// Suppress API server warnings in Kubernetes client-go
-	rest.SetDefaultWarningHandler(rest.NoWarnings{})
-
-	K8sClient := &K8sClient{
-		K8sContext:    "default",
-		Config:        config,
-		Client:        clientset,
-		DynamicClient: dynamicClient,
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating metadata client: %w", err)
 	}
+
 	// Create discovery client
-	K8sClient.Discovery, err = discovery.NewDiscoveryClientForConfig(config)
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		log.Err(err).Msg("Failed to create discovery client")
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
 	}
-	return K8sClient, nil
+
+	// Suppress API server warnings in Kubernetes client-go
+	rest.SetDefaultWarningHandler(rest.NoWarnings{})
+
+	return &K8sClient{
+		K8sContext:     contextName,
+		Config:         config,
+		Client:         clientset,
+		DynamicClient:  dynamicClient,
+		MetadataClient: metadataClient,
+		Discovery:      discoveryClient,
+	}, nil
 }
 
 func (c *K8sClient) GetAllComponents(ctx context.Context) ([]model.Component, []string, error) {
 	var namespaces []string
+	nsLabels, err := listNamespaceLabels(ctx, c.Client)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.namespaceLabels = nsLabels
+	resourceTypes := c.discoverResourceTypes()
+	graph := newOwnerGraph()
+
+	// Each worker writes only to its own slot so the results can be merged in discovery order afterward,
+	// which keeps the output deterministic regardless of the number of workers.
+	results := make([]gvrResult, len(resourceTypes))
+	// The Namespaces are listed first, since the namespace patterns of K8Filter are matched against them
+	namespaceIdx := slices.IndexFunc(resourceTypes, resourceType.isNamespace)
+	var clusterNamespaces []string
+	if namespaceIdx >= 0 {
+		results[namespaceIdx] = c.listResources(ctx, namespaceQuery(resourceTypes[namespaceIdx]), graph)
+		clusterNamespaces = results[namespaceIdx].namespaces
+	}
+	plan := planQueries(resourceTypes, clusterNamespaces, nsLabels, c.HelmDriver)
+	c.parallel(ctx, len(resourceTypes), func(idx int) {
+		if query := plan.Queries[idx]; idx != namespaceIdx && query.Skip == "" {
+			results[idx] = c.listResources(ctx, query, graph)
+		}
+	})
+
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	var k8sResourceList []model.Component
+	c.ResourceErrors = nil
+	c.ruleImages = nil
+	if c.Report != nil {
+		c.Report.Resources = nil
+	}
+	for idx, result := range results {
+		if result.err != nil {
+			c.ResourceErrors = append(c.ResourceErrors, ResourceError{GVR: resourceTypes[idx].gvr, Err: result.err})
+		}
+		if c.Report != nil {
+			c.Report.addResource(plan.Queries[idx], result)
+		}
+		k8sResourceList = append(k8sResourceList, result.components...)
+		namespaces = append(namespaces, result.namespaces...)
+		c.ruleImages = append(c.ruleImages, result.ruleImages...)
+	}
+	releases, err := listHelmReleases(ctx, c.Client, plan.HelmReleases)
+	if err != nil {
+		log.Warn().Msgf("Failed to list Helm releases - error: %v", err)
+		c.ResourceErrors = append(c.ResourceErrors, ResourceError{GVR: helmStorageResource(c.HelmDriver), Err: err})
+	}
+	included, skipped := addHelmReleases(releases, graph, &k8sResourceList, c.namespaceLabels)
+	if c.Report != nil {
+		c.Report.addHelmReleases(c.HelmDriver, included, skipped)
+	}
+	addVersionSkew(k8sResourceList, c.serverVersion())
+	graph.addOwners(k8sResourceList)
+	graph.addGitOpsProvenance(k8sResourceList)
+	c.owners = graph
+	return k8sResourceList, namespaces, nil
+}
+
+// discoverResourceTypes returns the preferred version of every resource type served by the cluster, in discovery order
+func (c *K8sClient) discoverResourceTypes() []resourceType {
 	// Get all API resources
 	apiResourceLists, err := c.Discovery.ServerPreferredResources()
 	if err != nil {
 		log.Err(err).Msg("Failed to list API groups and resources")
 	}
 
-	var k8sResourceList []model.Component
-
+	var resourceTypes []resourceType
 	for _, resourceList := range apiResourceLists {
 		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
 		if err != nil {
 			log.Err(err).Msg("Could not retrieve group version")
 		}
-		// First, go through all the non namespaced resources, store them, and get the list of namespaces
 		for _, resource := range resourceList.APIResources {
-			log.Info().Msgf("Processing resource: %s", resource.Name)
-			gvr := schema.GroupVersionResource{
-				Group:    gv.Group,
-				Version:  gv.Version,
-				Resource: resource.Name,
-			}
-			// Handle pagination while fetching resources
-			var continueToken string
-			for {
-				listOptions := metav1.ListOptions{
-					Continue: continueToken, // Use pagination token if present
-				}
-
-				k8sResources, k8serr := c.DynamicClient.Resource(gvr).List(ctx, listOptions)
-				if k8serr != nil {
-					if _, exists := unnecessaryResources[gvr.Resource]; exists {
-						log.Debug().Msgf("Failed to list resources for less common resource: %v - error: %v", gvr.Resource, k8serr)
-					} else {
-						log.Warn().Msgf("Failed to list resources for resource: %v - error: %v", gvr.Resource, k8serr)
-					}
-					break
-				}
-				if k8sResources == nil || len(k8sResources.Items) == 0 {
-					log.Debug().Msgf("No resources found for GVR: %v", gvr)
-					break
-				}
-
-				for _, item := range k8sResources.Items {
-					namespace := item.GetNamespace()
-					if item.GetKind() == "Namespace" {
-						namespaces = append(namespaces, item.GetName())
-					}
-					// For namespaced resources check based on the filter
-					if namespace != "" && !K8Filter.ShouldIncludeThisResource(namespace, item.GetKind()) {
-						continue
-					}
-
-					// For non-namespaced resources
-					if !K8Filter.IncludesAllKindsNonNamespaced() {
-						if namespace == "" && !utils.Contains(K8Filter.NonNamespacedInclusions.Resources, item.GetKind()) {
-							continue
-						}
-					}
-					addToComponentList(item, &k8sResourceList)
-				}
-
-				// Handle pagination
-				continueToken = k8sResources.GetContinue()
-				if continueToken == "" {
-					break
-				}
+			resourceTypes = append(resourceTypes, resourceType{
+				gvr: schema.GroupVersionResource{
+					Group:    gv.Group,
+					Version:  gv.Version,
+					Resource: resource.Name,
+				},
+				kind:       resource.Kind,
+				singular:   resource.SingularName,
+				shortNames: resource.ShortNames,
+				verbs:      resource.Verbs,
+				namespaced: resource.Namespaced,
+			})
+		}
+	}
+	// A partial discovery would report the resources of the groups it missed as unknown
+	if err == nil {
+		warnUnknownResources(resourceTypes)
+	}
+	return resourceTypes
+}
+
+// listResources lists the objects of a single GVR as planned by the query, following pagination, and converts the ones
+// matching the filter into components.
+func (c *K8sClient) listResources(ctx context.Context, query ResourceQuery, graph *ownerGraph) gvrResult {
+	var result gvrResult
+	log.Info().Msgf("Processing resource: %s", query.GVR.Resource)
+	// The other namespaces are still listed when one of them cannot be, e.g. when only some of them are allowed
+	var errs []error
+	for _, namespace := range query.namespaces() {
+		if err := c.listNamespace(ctx, query, namespace, graph, &result); err != nil {
+			if namespace != metav1.NamespaceAll {
+				err = fmt.Errorf("namespace %s: %w", namespace, err)
 			}
+			errs = append(errs, err)
 		}
 	}
-	return k8sResourceList, namespaces, nil
+	result.err = errors.Join(errs...)
+	return result
 }
 
-func (c *K8sClient) GetAllImages(ctx context.Context, namespaceList []string) ([]model.Component, error) {
-	imageMap := make(map[string]*model.Component) // A map of the image purl to make sure each one appears only once
-	var componentList []*model.Component
-	var primaryOwnerRef string
-	for _, namespace := range namespaceList {
-		pods, err := c.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to list pods: %w", err)
+// listNamespace lists the objects of the query in a single namespace, or in all of them for metav1.NamespaceAll, into
+// the result
+func (c *K8sClient) listNamespace(ctx context.Context, query ResourceQuery, namespace string, graph *ownerGraph, result *gvrResult) error {
+	resource, gvr := query.resource, query.GVR
+	// The server leaves out the objects no inclusion could match, the rest of the filter is applied to each item
+	var continueToken string
+	for {
+		listOptions := metav1.ListOptions{
+			LabelSelector: query.LabelSelector,
+			FieldSelector: query.FieldSelector,
+			Continue:      continueToken, // Use pagination token if present
 		}
-		log.Info().Msgf("Listing pods in namespace: %s", namespace)
-		for _, pod := range pods.Items {
-			ownerReferenceSet := set.Set[string]{}
-			if len(pod.OwnerReferences) == 0 {
-				log.Info().Msgf("No owner reference found for pod: %s", pod.Name)
+
+		k8sResources, k8serr := c.listPage(ctx, resource, namespace, listOptions)
+		if k8serr != nil {
+			if _, exists := unnecessaryResources[gvr.Resource]; exists {
+				log.Debug().Msgf("Failed to list resources for less common resource: %v - error: %v", gvr.Resource, k8serr)
 			} else {
-				//Special cases for Owner References:
-				//	1. ReplicaSets can be owned by Deployments or custom CRDs
-				//	2. Jobs can be owned by CronJobs or custom CRDs
-				primaryOwnerRef = getPrimaryOwnerReference(c, pod.OwnerReferences, &ownerReferenceSet, namespace)
+				log.Warn().Msgf("Failed to list resources for resource: %v - error: %v", gvr.Resource, k8serr)
 			}
+			return k8serr
+		}
+		if k8sResources == nil || len(k8sResources.Items) == 0 {
+			log.Debug().Msgf("No resources found for GVR: %v", gvr)
+			return nil
+		}
 
-			// Ephemeral containers are added only after the Pod is running but do not affect pod health
-			ephemeralContainerStatuses := pod.Status.EphemeralContainerStatuses
-			for _, container := range pod.Spec.EphemeralContainers {
-				addOrUpdateImageInComponentList(EphemeralContainerWrapper{container}, namespace, &componentList, ephemeralContainerStatuses, "ephemeral", ownerReferenceSet, primaryOwnerRef, imageMap)
+		for _, item := range k8sResources.Items {
+			if item.GetKind() == "Namespace" {
+				result.namespaces = append(result.namespaces, item.GetName())
 			}
-
-			initContainerStatuses := pod.Status.InitContainerStatuses
-			for _, container := range pod.Spec.InitContainers {
-				addOrUpdateImageInComponentList(ContainerWrapper{container}, namespace, &componentList, initContainerStatuses, "init", ownerReferenceSet, primaryOwnerRef, imageMap)
+			included := shouldIncludeItem(item, resource, c.namespaceLabels)
+			graph.add(item, included)
+			if !included {
+				result.skipped.add(item.GetNamespace())
+				continue
 			}
-
-			containerStatuses := pod.Status.ContainerStatuses
-			for _, container := range pod.Spec.Containers {
-				addOrUpdateImageInComponentList(ContainerWrapper{container}, namespace, &componentList, containerStatuses, "main", ownerReferenceSet, primaryOwnerRef, imageMap)
+			result.included.add(item.GetNamespace())
+			addToComponentList(item, &result.components)
+			if found := findRuleImages(item); found != nil {
+				result.ruleImages = append(result.ruleImages, *found)
 			}
 		}
+
+		// Handle pagination
+		continueToken = k8sResources.GetContinue()
+		if continueToken == "" {
+			return nil
+		}
 	}
-	var finalList []model.Component
-	for _, compPtr := range componentList {
-		finalList = append(finalList, *compPtr) // Dereference pointers before returning
-	}
-	return finalList, nil
 }
 
-func getPrimaryOwnerReference(k *K8sClient, ownerRefs []metav1.OwnerReference, ownerReferenceSet *set.Set[string], namespace string) string {
-
-	//Special cases for Owner References:
-	//	1. ReplicaSets can be owned by Deployments or custom CRDs
-	//	2. Jobs can be owned by CronJobs or custom CRDs
-	ownerReference := getOnePrimaryOwnerReference(ownerRefs)
+// shouldIncludeItem checks the item of the resource type against K8Filter, matching its namespace selectors against the
+// namespace labels
+func shouldIncludeItem(item unstructured.Unstructured, resource resourceType, nsLabels namespaceLabels) bool {
+	return K8Filter.ShouldIncludeObject(nsLabels.itemTarget(item, resource))
+}
 
-	//for _, ownerReference := range ownerRefs {
-	ownerReferenceKey := fmt.Sprintf("%s/%s", ownerReference.Kind, ownerReference.Name)
-	if ownerReferenceSet.Has(ownerReferenceKey) {
-		// Already identified the owner reference for this pod
-		//continue
-		return ownerReferenceKey
+// listPage lists a single page of a GVR in the namespace, or in all of them for metav1.NamespaceAll. Kinds that never
+// have their spec read are listed metadata-only.
+func (c *K8sClient) listPage(ctx context.Context, resource resourceType, namespace string, listOptions metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if c.needsFullObject(resource) {
+		return c.DynamicClient.Resource(resource.gvr).Namespace(namespace).List(ctx, listOptions)
 	}
 
-	if ownerReference.Kind == "ReplicaSet" { //ReplicaSets can be managed by Deployments
-		replicaSetName := ownerReference.Name
-
-		// Retrieve the ReplicaSet
-		replicaSet, err := k.Client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), replicaSetName, metav1.GetOptions{})
+	metadataList, err := c.MetadataClient.Resource(resource.gvr).Namespace(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetContinue(metadataList.GetContinue())
+	for i := range metadataList.Items {
+		item, err := metadataToUnstructured(&metadataList.Items[i], resource)
 		if err != nil {
-			log.Err(err).Msgf("Error retrieving Replicaset: %s", replicaSetName)
-			return ownerReferenceKey
+			return nil, err
 		}
+		list.Items = append(list.Items, item)
+	}
+	return list, nil
+}
 
-		if len(replicaSet.OwnerReferences) > 0 {
-			primaryOwnerRef := getOnePrimaryOwnerReference(replicaSet.OwnerReferences)
-			//continue
-			ownerReferenceKey = fmt.Sprintf("%s/%s", primaryOwnerRef.Kind, primaryOwnerRef.Name)
+// metadataToUnstructured converts the metadata of an object into the unstructured form addToComponentList expects
+func metadataToUnstructured(objectMetadata *metav1.PartialObjectMetadata, resource resourceType) (unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(objectMetadata)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	item := unstructured.Unstructured{Object: obj}
+	// Items in a PartialObjectMetadataList are typed as PartialObjectMetadata, so restore the real kind and apiVersion
+	item.SetAPIVersion(resource.gvr.GroupVersion().String())
+	item.SetKind(resource.kind)
+	return item, nil
+}
+
+// needsFullObject checks whether the spec of the resource type is read, in which case the full objects are listed.
+// The field selectors of K8Filter may read the spec or status as well.
+func (c *K8sClient) needsFullObject(resource resourceType) bool {
+	_, needsSpec := fullObjectKinds[resource.kind]
+	gvk := resource.gvr.GroupVersion().WithKind(resource.kind)
+	return needsSpec || hasImageRule(gvk) || hasVersionPath(gvk) || isGitOpsKind(gvk.GroupKind()) || K8Filter.HasFieldSelector(resource.filterTarget()) || c.MetadataClient == nil
+}
+
+// parallel runs the tasks on the workers, and stops handing them out once the context is cancelled
+func (c *K8sClient) parallel(ctx context.Context, tasks int, task func(idx int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.workerCount(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				task(idx)
+			}
+		}()
+	}
+
+feed:
+	for idx := 0; idx < tasks; idx++ {
+		select {
+		case indexes <- idx:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(indexes)
+	wg.Wait()
+}
 
-	if ownerReference.Kind == "Job" { //Jobs can be managed by CronJobs
-		jobName := ownerReference.Name
-		job, err := k.Client.BatchV1().Jobs(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+// workerCount returns the number of workers to use for the given number of tasks.
+func (c *K8sClient) workerCount(tasks int) int {
+	workers := c.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	if workers > tasks {
+		workers = tasks
+	}
+	return workers
+}
 
+func (c *K8sClient) GetAllImages(ctx context.Context, namespaceList []string) ([]model.Component, error) {
+	images := newImageCollector(c.lookupOwnerReferences, c.owners)
+	var pods []*corev1.Pod
+	namespaceList = imageNamespaces(namespaceList, c.namespaceLabels)
+	labelSelector, fieldSelector := K8Filter.ListSelectors(true, podResource.filterTarget())
+	if c.Report != nil {
+		c.Report.Images = ImageReport{Namespaces: namespaceList, LabelSelector: labelSelector, FieldSelector: fieldSelector}
+	}
+	for _, namespace := range namespaceList {
+		podList, err := c.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector, FieldSelector: fieldSelector})
 		if err != nil {
-			log.Err(err).Msgf("Error retrieving Job: %s", jobName)
-			return ownerReferenceKey
+			return nil, fmt.Errorf("failed to list pods: %w", err)
 		}
+		log.Info().Msgf("Listing pods in namespace: %s", namespace)
+		for i := range podList.Items {
+			pods = append(pods, &podList.Items[i])
+		}
+	}
+	// The workloads declare images that no Pod runs right now, e.g. CronJobs between runs
+	templates := c.listWorkloadTemplates(ctx, namespaceList)
+	if c.Report != nil {
+		c.Report.Images.count(pods, templates, c.namespaceLabels)
+	}
+	pods, templates = selectImageSources(pods, templates, c.namespaceLabels)
+	ruleImages := inNamespaces(c.ruleImages, namespaceList)
+	images.resolveDigests(ctx, c.DigestResolver, append(specImages(append(podSpecs(pods), templateSpecs(templates)...)), ruleImageReferences(ruleImages)...))
+	for _, pod := range pods {
+		images.addPod(ctx, pod)
+	}
+	images.addTemplates(ctx, templates)
+	images.addRuleImages(ruleImages)
+	return images.components(), nil
+}
 
-		if len(job.OwnerReferences) > 0 {
-			primaryOwnerRef := getOnePrimaryOwnerReference(job.OwnerReferences)
-			//continue
-			ownerReferenceKey = fmt.Sprintf("%s/%s", primaryOwnerRef.Kind, primaryOwnerRef.Name)
+// lookupOwnerReferences returns the owner references of the ReplicaSet or Job from the objects listed by
+// GetAllComponents, and only gets the ones that were not listed from the cluster
+func (c *K8sClient) lookupOwnerReferences(ctx context.Context, groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error) {
+	if c.owners != nil {
+		if ownerRefs, err := c.owners.ownerReferences(groupKind, namespace, name); err == nil {
+			return ownerRefs, nil
+		}
+	}
+	return c.getOwnerReferences(ctx, groupKind, namespace, name)
+}
 
+// getOwnerReferences returns the owner references of the ReplicaSet or Job with the given name
+func (c *K8sClient) getOwnerReferences(ctx context.Context, groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error) {
+	switch groupKind {
+	case replicaSetKind:
+		replicaSet, err := c.Client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return replicaSet.OwnerReferences, nil
+	case jobKind:
+		job, err := c.Client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
 		}
+		return job.OwnerReferences, nil
 	}
-	ownerReferenceSet.Insert(ownerReferenceKey)
-	return ownerReferenceKey
-	//}
+	return nil, nil
 }
 
 func getOnePrimaryOwnerReference(ownerRefs []metav1.OwnerReference) metav1.OwnerReference {
@@ -326,83 +634,6 @@ func getOnePrimaryOwnerReference(ownerRefs []metav1.OwnerReference) metav1.Owner
 	return primaryOwner
 }
 
-func addOrUpdateImageInComponentList(container ContainerLike, namespace string, k8sResourceList *[]*model.Component, containerStatuses []v1.ContainerStatus, source string, ownerRefs set.Set[string], primaryOwnerRef string, imageMap map[string]*model.Component) {
-	var properties []model.Property
-	var imageId = ""
-	var imageSha = ""
-	var version = ""
-	for _, containerStatus := range containerStatuses {
-		if containerStatus.Name == container.GetName() {
-			if containerStatus.State.Terminated != nil {
-				return // Ignore the container which is already terminated
-			}
-
-			imageId = containerStatus.ImageID
-			sha256 := "sha256:"
-			if strings.Contains(imageId, sha256) {
-				imageSha = fmt.Sprintf("%s%s", sha256, strings.Split(imageId, sha256)[1])
-			} else {
-				log.Error().Msgf("SHA256 digest not found in image: %s - continuing.", imageId)
-			}
-			break
-		}
-	}
-
-	component := &model.Component{
-		Type:       "container",
-		Name:       container.GetImage(), //Pass the full image name and split it into name and version in the function addPropertiesForImageComponent
-		PackageURL: "",
-		Properties: properties,
-		Licenses:   nil,
-		Hashes:     nil,
-	}
-	component.AddProperty(model.ComponentNamespace, namespace)
-	component.PackageURL, version = GetImagePkgID(component, imageSha, primaryOwnerRef)
-
-	if c, exists := imageMap[component.PackageURL]; exists {
-		updateImageInComponentList(c, source, ownerRefs, primaryOwnerRef, version)
-		log.Debug().Msgf("Updated existing image for resource: %s, kind: image, namespace: %s", container.GetImage(), namespace)
-	} else {
-		c = addImageToComponentList(component, namespace, k8sResourceList, source, ownerRefs, primaryOwnerRef, imageMap)
-		log.Debug().Msgf("Added new image for resource: %s, kind: image, namespace: %s", container.GetImage(), namespace)
-		//add to imagemap
-		imageMap[component.PackageURL] = c
-	}
-}
-
-func updateImageInComponentList(component *model.Component, source string, ownerRefs set.Set[string], primaryOwner string, version string) {
-	prop, exists := component.GetPropertyObject(model.ComponentOwnerRef)
-	//Note: Handle multiple containers owner references properly, currently assuming single primary owner reference
-	prop, exists = component.GetPropertyObject(model.ComponentSourceRef)
-	if exists {
-		prop.Values = []string{source}
-	} else {
-		component.AddProperty("clx:k8s:source", source)
-	}
-	prop, exists = component.GetPropertyObject(model.ComponentVersion)
-	if exists {
-		prop.Values = []string{version}
-	} else {
-		component.AddProperty(model.ComponentVersion, version)
-	}
-}
-
-func addImageToComponentList(component *model.Component, namespace string, k8sResourceList *[]*model.Component, source string, ownerRefs set.Set[string], primaryOwner string, imageMap map[string]*model.Component) *model.Component {
-	// Add a new component
-	imageMap[component.PackageURL] = component
-	component.AddProperty(model.ComponentKind, "Image")
-	component.AddProperty(model.ComponentNamespace, namespace)
-	component.AddProperty("clx:k8s:source", source)
-	component.AddProperty("clx:k8s:ownerRef", primaryOwner)
-	component.AddProperty(model.ComponentVersion, component.Version)
-
-	//TODO: capture multipe ownerRefs in pedigree or externalReferences property in component
-
-	*k8sResourceList = append(*k8sResourceList, component)
-	log.Debug().Msgf("Created new image for resource: %s, kind: image, namespace: %s", component.Name, namespace)
-	return component
-}
-
 func addToComponentList(item unstructured.Unstructured, k8sResourceList *[]model.Component) {
 	var properties []model.Property
 	component := model.Component{
@@ -417,7 +648,14 @@ func addToComponentList(item unstructured.Unstructured, k8sResourceList *[]model
 
 	component.AddProperty(model.ComponentKind, item.GetKind())
 	component.AddProperty(model.ComponentNamespace, item.GetNamespace())
-	addVersionForComponent(item, &component, "clx:k8s:componentVersion")
+	addVersionForComponent(item, &component, model.ComponentVersion)
+	if item.GetKind() == "Node" {
+		addNodeInfo(item, &component)
+	}
+	if isHelmRelease(item) {
+		addHelmReleaseInfo(item, &component)
+	}
+	addGitOpsInfo(item, &component)
 	component.PackageURL = GetAppPkgId(item.GetKind(), item.GetName(), item.GetNamespace(), item.GetAPIVersion())
 	*k8sResourceList = append(*k8sResourceList, component)
 	log.Debug().Msgf("Created new component for resource: %s, kind: %s, namespace: %s", item.GetName(), item.GetKind(), item.GetNamespace())
@@ -437,56 +675,14 @@ func GetAppPkgId(kind string, name string, namespace string, apiVersion string)
 	return fmt.Sprintf("%s?%s", baseUrl, urlValues.Encode())
 }
 
-func addVersionForComponent(item unstructured.Unstructured, component *model.Component, key string) {
-	componentKind := item.GetKind()
-	// Get the version based on component kind since there is no standard way of setting component's version in custom resources
-	switch componentKind {
-	case "HelmChart":
-		componentSpec, ok := item.Object["spec"].(map[string]interface{})
-		componentVersion, err := componentSpec["version"].(string)
-		if !ok || !err {
-			log.Info().Msgf("Fetching version from label helm.sh/chart for component: %s", component.Name)
-			addLabelIfExists(item, "helm.sh/chart", component, "clx:k8s:componentVersion")
-		} else {
-			component.AddProperty(key, componentVersion)
-		}
-	default:
-		addLabelIfExists(item, "helm.sh/chart", component, "clx:k8s:componentVersion")
-	}
-}
-
-func addLabelIfExists(item unstructured.Unstructured, label string, component *model.Component, propertyKey string) {
-	// Get labels map safely
-	labels, ok := item.Object["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	// Get the label safely
-	labelValue, exists := labels[label]
-	if !exists {
-		log.Debug().Msgf("Item %s does not have a label %s", item.GetName(), label)
-		return
-	}
-
-	// Ensure it's a string before returning
-	labelValueStr, valid := labelValue.(string)
-	if !valid {
-		log.Error().Msgf("Item %s has a label %s with value %v which is not a string.", item.GetName(), label, labelValue)
-		return
-	}
-
-	component.AddProperty(propertyKey, labelValueStr)
-}
-
-func GetImagePkgID(imageComponent *model.Component, imageSha string, primaryOwnerRef string) (string, string) {
+func GetImagePkgID(imageComponent *model.Component, imageSha string) (string, string) {
 	ref, err := name.ParseReference(imageComponent.Name)
 	if err != nil {
 		log.Err(err).Msgf("No reference found for Image: %s", imageComponent.Name)
-	} else {
-		imageComponent.Version = ref.Identifier()
-		imageComponent.Name = strings.Split(ref.Name(), ":")[0]
+		return fmt.Sprintf("%s:%s/%s", model.PkgPrefix, model.OciPrefix, url.PathEscape(imageComponent.Name)), ""
 	}
+	imageComponent.Version = ref.Identifier()
+	imageComponent.Name = ref.Context().Name() // The registry can have a port, so the tag is not simply after the first colon
 
 	baseUrl := ref.Context().RepositoryStr()
 
@@ -496,12 +692,16 @@ func GetImagePkgID(imageComponent *model.Component, imageSha string, primaryOwne
 		"repository_url": []string{imageComponent.Name},
 	}
 
-	urlValues.Add("ownerRef", primaryOwnerRef)
-	urlValues.Add("namespace", imageComponent.GetNamespace())
+	// The digest identifies the image wherever it runs. Without one the tag is the best identity there is.
+	if digest, isDigest := ref.(name.Digest); isDigest && imageSha == "" {
+		imageSha = digest.DigestStr()
+	}
 	if imageSha != "" {
 		baseName = fmt.Sprintf("%s@%s", baseName, imageSha)
+	} else if tag, isTag := ref.(name.Tag); isTag {
+		urlValues.Add("tag", tag.TagStr())
 	}
 
-	//Format:  pkg:oci/{imageName}/{@ImageSha}?namespace={namespace}&ownerRef={primaryOwnerRef}&repository_url={repourl}&
+	//Format:  pkg:oci/{imageName}@{ImageSha}?repository_url={repourl} or pkg:oci/{imageName}?repository_url={repourl}&tag={tag}
 	return fmt.Sprintf("%s?%s", baseName, urlValues.Encode()), imageComponent.Version
 }
@@ -1,25 +1,42 @@
 package k8_test
 
 import (
+	"bytes"
 	"cluster-codex/cmd"
 	"cluster-codex/internal/k8"
 	"cluster-codex/internal/model"
 	"cluster-codex/internal/utils"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	. "github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apimachinery/pkg/watch"
 	fakediscovery "k8s.io/client-go/discovery/fake"
 	dynamicfakeclient "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	metadatafakeclient "k8s.io/client-go/metadata/fake"
+	clienttesting "k8s.io/client-go/testing"
 	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // ✅ Defines all commonly used GVRs
@@ -29,6 +46,8 @@ var gvrs = map[string]schema.GroupVersionResource{
 	"deployments":       {Group: "apps", Version: "v1", Resource: "deployments"},
 	"namespaces":        {Group: "", Version: "v1", Resource: "namespaces"},
 	"persistentvolumes": {Group: "", Version: "v1", Resource: "persistentvolumes"},
+	"helmcharts":        {Group: "helm.cattle.io", Version: "v1", Resource: "helmcharts"},
+	"nodes":             {Group: "", Version: "v1", Resource: "nodes"},
 }
 
 var kinds = map[string]string{
@@ -37,6 +56,8 @@ var kinds = map[string]string{
 	"deployments":       "Deployment",
 	"namespaces":        "Namespace",
 	"persistentvolumes": "PersistentVolume",
+	"helmcharts":        "HelmChart",
+	"nodes":             "Node",
 }
 
 // ✅ Generates mock Kubernetes resources dynamically
@@ -104,6 +125,67 @@ func createMockResources(resourceType string, names []string, namespace string)
 	return resources
 }
 
+// newCronJob returns a CronJob running a single container with the image
+func newCronJob(name string, namespace string, image string) *batchv1.CronJob {
+	return &batchv1.CronJob{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: batchv1.CronJobSpec{
+			Schedule: "0 * * * *",
+			JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: image}}},
+			}}},
+		},
+	}
+}
+
+// helmReleaseData encodes a release of the web chart the way Helm stores it: gzipped JSON, base64 encoded
+func helmReleaseData(name string, namespace string, revision int, status string) string {
+	release, err := json.Marshal(map[string]interface{}{
+		"name":      name,
+		"namespace": namespace,
+		"version":   revision,
+		"info":      map[string]interface{}{"status": status, "last_deployed": "2025-03-04T10:15:30.123456789+01:00"},
+		"chart": map[string]interface{}{
+			"metadata":  map[string]interface{}{"name": "web", "version": fmt.Sprintf("1.%d.0", revision), "appVersion": "2.4.1"},
+			"templates": []interface{}{map[string]interface{}{"name": "templates/deployment.yaml", "data": "a2luZDogRGVwbG95bWVudA=="}},
+		},
+		"manifest": "kind: Deployment",
+	})
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(release)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	gomega.Expect(writer.Close()).To(gomega.Succeed())
+	return base64.StdEncoding.EncodeToString(compressed.Bytes())
+}
+
+// newHelmReleaseSecret returns the Secret Helm stores a revision of a release in
+func newHelmReleaseSecret(name string, namespace string, revision int, status string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision),
+			Namespace: namespace,
+			Labels:    map[string]string{"owner": "helm", "name": name, "status": status, "version": fmt.Sprint(revision)},
+		},
+		Type: "helm.sh/release.v1",
+		Data: map[string][]byte{"release": []byte(helmReleaseData(name, namespace, revision, status))},
+	}
+}
+
+// Converts an unstructured mock resource into the metadata-only form served by the metadata client
+func toPartialObjectMetadata(obj unstructured.Unstructured) *v1.PartialObjectMetadata {
+	return &v1.PartialObjectMetadata{
+		TypeMeta: v1.TypeMeta{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind()},
+		ObjectMeta: v1.ObjectMeta{
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			Labels:          obj.GetLabels(),
+			OwnerReferences: obj.GetOwnerReferences(),
+		},
+	}
+}
+
 // ✅ Custom FakeDiscovery that overrides ServerPreferredResources
 type CustomFakeDiscovery struct {
 	fakediscovery.FakeDiscovery
@@ -118,10 +200,11 @@ func (c *CustomFakeDiscovery) ServerPreferredResources() ([]*v1.APIResourceList,
 var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 	mockNamespaceList := []string{"default", "kube-system"}
 	var (
-		fakeK8sClient     *k8.K8sClient
-		fakeClientset     *fake.Clientset
-		fakeDynamicClient *dynamicfakeclient.FakeDynamicClient
-		fakeDiscovery     *CustomFakeDiscovery
+		fakeK8sClient      *k8.K8sClient
+		fakeClientset      *fake.Clientset
+		fakeDynamicClient  *dynamicfakeclient.FakeDynamicClient
+		fakeMetadataClient *metadatafakeclient.FakeMetadataClient
+		fakeDiscovery      *CustomFakeDiscovery
 	)
 
 	BeforeEach(func() {
@@ -137,8 +220,13 @@ var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 				gvrs["deployments"]:       "DeploymentList",
 				gvrs["namespaces"]:        "NamespaceList",
 				gvrs["persistentvolumes"]: "PersistentvolumesList",
+				gvrs["helmcharts"]:        "HelmChartList",
+				gvrs["nodes"]:             "NodeList",
 			},
 		)
+		metadataScheme := metadatafakeclient.NewTestScheme()
+		gomega.Expect(v1.AddMetaToScheme(metadataScheme)).To(Succeed())
+		fakeMetadataClient = metadatafakeclient.NewSimpleMetadataClient(metadataScheme)
 
 		// ✅ Create mock resources
 		mockPods := createMockResources("pods", []string{"pod-1", "pod-2"}, "default")
@@ -147,6 +235,13 @@ var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 		mockNamespaces := createMockResources("namespaces", mockNamespaceList, "")
 		mockPersistentVolumes := createMockResources("persistentvolumes", []string{"pv-1"}, "")
 
+		// ✅ Add the same mock objects to the FakeMetadataClient tracker
+		for _, resources := range [][]unstructured.Unstructured{mockPods, mockPods2, mockDeployments, mockNamespaces, mockPersistentVolumes} {
+			for _, resource := range resources {
+				gomega.Expect(fakeMetadataClient.Tracker().Add(toPartialObjectMetadata(resource))).To(Succeed())
+			}
+		}
+
 		// ✅ Add mock objects to FakeDynamicClient tracker
 		for _, pod := range mockPods {
 			_, err := fakeDynamicClient.Resource(gvrs["pods"]).Namespace("default").Create(context.TODO(), &pod, v1.CreateOptions{})
@@ -276,11 +371,12 @@ var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 
 		// ✅ Initialize K8sClient with fake clients
 		fakeK8sClient = &k8.K8sClient{
-			K8sContext:    "test-cluster",
-			Config:        nil,
-			Client:        fakeClientset,     // Fake Clientset
-			DynamicClient: fakeDynamicClient, // Fake Dynamic Client
-			Discovery:     fakeDiscovery,
+			K8sContext:     "test-cluster",
+			Config:         nil,
+			Client:         fakeClientset,      // Fake Clientset
+			DynamicClient:  fakeDynamicClient,  // Fake Dynamic Client
+			MetadataClient: fakeMetadataClient, // Fake Metadata Client
+			Discovery:      fakeDiscovery,
 		}
 	})
 
@@ -408,50 +504,1515 @@ var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 		)
 	})
 
+	Context("when the filter has exclusions", func() {
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{
+				NamespacedExclusions:    []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}}},
+				NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"PersistentVolume"}},
+			}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			DeferCleanup(func() { k8.K8Filter = model.Filter{} })
+		})
+
+		It("should leave the excluded objects out of the components", func() {
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ContainElements("pod-1", "pod-2", "deployment-1", "default", "kube-system"))
+			Expect(names).ToNot(ContainElement("pod-3"))
+			Expect(names).ToNot(ContainElement("pod-4"))
+			Expect(names).ToNot(ContainElement("pv-1"))
+		})
+
+		It("should leave the images only found in excluded namespaces out", func() {
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(components).To(HaveLen(2))
+			for _, component := range components {
+				Expect(component.Properties).To(ContainElement(model.Property{Name: model.ComponentNamespace, Values: []string{"default"}}))
+				for _, occurrence := range component.Evidence.Occurrences {
+					Expect(occurrence.Location).To(HavePrefix("default/"))
+				}
+			}
+		})
+
+		It("should leave the images of excluded kinds out", func() {
+			k8.K8Filter.NamespacedExclusions = []model.NamespacedExclusion{{Resources: []string{"Pod"}}}
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(components).To(HaveLen(1))
+			Expect(components[0].Name + ":" + components[0].Version).To(Equal("index.docker.io/library/busybox:1.36"))
+		})
+	})
+
+	Context("when a FilterReport records what the filter did", func() {
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{
+				NamespacedExclusions: []model.NamespacedExclusion{
+					{Namespaces: []string{"kube-system"}},
+					{Namespaces: []string{"default"}, Resources: []string{"Pod"}, Selectors: model.Selectors{Names: []string{"pod-2"}}},
+				},
+				NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"PersistentVolume"}},
+			}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			DeferCleanup(func() { k8.K8Filter = model.Filter{} })
+			fakeK8sClient.Report = &k8.FilterReport{}
+		})
+
+		It("should count the objects included and skipped of each resource type by namespace", func() {
+			_, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeK8sClient.Report.Resources).To(HaveLen(6))
+			reports := make(map[string]k8.ResourceReport)
+			for _, report := range fakeK8sClient.Report.Resources {
+				reports[report.Kind] = report
+			}
+			Expect(reports["Pod"].Included).To(Equal(k8.NamespaceCounts{"default": 1}))
+			Expect(reports["Pod"].Skipped).To(Equal(k8.NamespaceCounts{"default": 1, "kube-system": 2}))
+			Expect(reports["Deployment"].Included).To(Equal(k8.NamespaceCounts{"default": 1}))
+			Expect(reports["Namespace"].Included).To(Equal(k8.NamespaceCounts{"": 2}))
+			// An exclusion drops all of them, so they are not listed
+			Expect(reports["PersistentVolume"].Skip).ToNot(BeEmpty())
+			Expect(reports["PersistentVolume"].Included.Total() + reports["PersistentVolume"].Skipped.Total()).To(BeZero())
+			Expect(reports["Service"].Included.Total() + reports["Service"].Skipped.Total()).To(BeZero())
+			Expect(reports).To(HaveKey(k8.HelmReleaseKind))
+		})
+
+		It("should count the Pods the images are taken from and the ones skipped", func() {
+			_, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeK8sClient.Report.Images.Namespaces).To(Equal([]string{"default"}))
+			Expect(fakeK8sClient.Report.Images.IncludedPods).To(Equal(k8.NamespaceCounts{"default": 1}))
+			Expect(fakeK8sClient.Report.Images.SkippedPods).To(Equal(k8.NamespaceCounts{"default": 1}))
+		})
+
+		It("should count the objects of every resource type without the filter", func() {
+			counts, err := fakeK8sClient.CountResources(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(counts).To(ConsistOf(
+				k8.ResourceCount{GVR: gvrs["pods"], Kind: "Pod", Namespaced: true, Objects: k8.NamespaceCounts{"default": 2, "kube-system": 2}},
+				k8.ResourceCount{GVR: gvrs["services"], Kind: "Service", Namespaced: true},
+				k8.ResourceCount{GVR: gvrs["namespaces"], Kind: "Namespace", Objects: k8.NamespaceCounts{"": 2}},
+				k8.ResourceCount{GVR: gvrs["persistentvolumes"], Kind: "PersistentVolume", Objects: k8.NamespaceCounts{"": 1}},
+				k8.ResourceCount{GVR: gvrs["deployments"], Kind: "Deployment", Namespaced: true, Objects: k8.NamespaceCounts{"default": 1}},
+			))
+		})
+	})
+
+	Context("when the filter names resources by their other names", func() {
+		var logs bytes.Buffer
+
+		BeforeEach(func() {
+			fakeDiscovery.Resources[0].APIResources[0].SingularName = "pod"
+			fakeDiscovery.Resources[0].APIResources[0].ShortNames = []string{"po"}
+			fakeDiscovery.Resources[1].APIResources[0].ShortNames = []string{"deploy"}
+			logs.Reset()
+			logger := zlog.Logger
+			// The workers listing the resources log concurrently
+			zlog.Logger = zerolog.New(zerolog.SyncWriter(&logs)).Level(zerolog.WarnLevel)
+			DeferCleanup(func() {
+				zlog.Logger = logger
+				k8.K8Filter = model.Filter{}
+			})
+		})
+
+		It("should match the short names, plurals and group-qualified kinds from discovery", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{
+				{Namespaces: []string{"default"}, Resources: []string{"po"}},
+				{Resources: []string{"deployments.apps"}},
+			}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ContainElements("pod-1", "pod-2", "deployment-1"))
+			Expect(names).ToNot(ContainElement("pod-3"))
+			Expect(logs.String()).ToNot(ContainSubstring("Unknown resource"))
+		})
+
+		It("should warn once about an unknown resource with its close matches", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{
+				{Resources: []string{"Deploymnet", "deployments.example.com"}},
+			}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			_, _, err := fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(strings.Count(logs.String(), "Unknown resource in filter: Deploymnet")).To(Equal(1))
+			Expect(logs.String()).To(ContainSubstring("did you mean one of: Deployment.apps"))
+			Expect(logs.String()).To(ContainSubstring("Unknown resource in filter: deployments.example.com"))
+		})
+	})
+
+	Context("when the filter has selectors", func() {
+		AfterEach(func() {
+			k8.K8Filter = model.Filter{}
+		})
+
+		// listSelectors returns the label and field selectors of the list actions on the resource
+		listSelectors := func(actions []clienttesting.Action, resource string) []string {
+			var selectors []string
+			for _, action := range actions {
+				if list, isList := action.(clienttesting.ListAction); isList && action.GetResource().Resource == resource {
+					restrictions := list.GetListRestrictions()
+					selectors = append(selectors, restrictions.Labels.String()+";"+restrictions.Fields.String())
+				}
+			}
+			return selectors
+		}
+
+		It("should send the selectors every inclusion of a kind has to the API server", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{
+				{Resources: []string{"Pod"}, Selectors: model.Selectors{LabelSelector: "app=web"}},
+				{Resources: []string{"Deployment"}},
+			}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			web := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.27"}}},
+			}
+			Expect(fakeClientset.Tracker().Add(web)).To(Succeed())
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listSelectors(fakeMetadataClient.Actions(), "pods")).To(Equal([]string{"app=web;"}))
+			Expect(listSelectors(fakeMetadataClient.Actions(), "deployments")).To(Equal([]string{";"}))
+			// None of the Pods listed have the label
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ConsistOf("deployment-1", "default", "kube-system", "pv-1"))
+
+			images, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listSelectors(fakeClientset.Actions(), "pods")).To(Equal([]string{"app=web;", "app=web;"}))
+			Expect(images).To(HaveLen(1))
+			Expect(images[0].Name + ":" + images[0].Version).To(Equal("index.docker.io/library/nginx:1.27"))
+		})
+
+		It("should select the objects and images in the namespaces whose labels match the namespace selector", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{NamespaceSelector: "team=payments"}}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			Expect(fakeClientset.Tracker().Add(&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "payments"}}})).To(Succeed())
+			Expect(fakeClientset.Tracker().Add(&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "kube-system"}})).To(Succeed())
+
+			components, namespaces, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ContainElements("pod-1", "pod-2", "deployment-1"))
+			Expect(names).ToNot(ContainElement("pod-3"))
+
+			images, err := fakeK8sClient.GetAllImages(context.Background(), namespaces)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(images).ToNot(BeEmpty())
+			for _, image := range images {
+				Expect(image.Properties).To(ContainElement(model.Property{Name: model.ComponentNamespace, Values: []string{"default"}}))
+			}
+		})
+
+		It("should list the full objects of the kinds a field selector applies to", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{
+				{Resources: []string{"Pod"}, Selectors: model.Selectors{FieldSelector: "metadata.name=pod-1"}},
+			}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listSelectors(fakeDynamicClient.Actions(), "pods")).To(Equal([]string{";metadata.name=pod-1"}))
+			Expect(listSelectors(fakeMetadataClient.Actions(), "pods")).To(BeEmpty())
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ContainElement("pod-1"))
+			Expect(names).ToNot(ContainElement("pod-2"))
+		})
+	})
+
+	Context("when GetAllComponents lists resource types concurrently", func() {
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+		})
+
+		It("should return the same components in the same order regardless of the number of workers", func() {
+			fakeK8sClient.Concurrency = 1
+			sequential, sequentialNamespaces, err := fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).To(BeNil())
+			Expect(sequential).To(HaveLen(8))
+
+			for _, workers := range []int{2, 5, 16} {
+				fakeK8sClient.Concurrency = workers
+				components, namespaces, err := fakeK8sClient.GetAllComponents(context.Background())
+				Expect(err).To(BeNil())
+				Expect(components).To(Equal(sequential))
+				Expect(namespaces).To(Equal(sequentialNamespaces))
+			}
+		})
+
+		It("should keep the error for each resource type that could not be listed", func() {
+			fakeMetadataClient.PrependReactor("list", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("services are unavailable")
+			})
+			fakeK8sClient.Concurrency = 4
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).To(BeNil())
+			Expect(components).To(HaveLen(8))
+			Expect(fakeK8sClient.ResourceErrors).To(HaveLen(1))
+			Expect(fakeK8sClient.ResourceErrors[0].GVR).To(Equal(gvrs["services"]))
+			Expect(fakeK8sClient.ResourceErrors[0].Error()).To(ContainSubstring("services are unavailable"))
+		})
+
+		It("should stop and return the context error when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			fakeK8sClient.Concurrency = 2
+
+			components, namespaces, err := fakeK8sClient.GetAllComponents(ctx)
+
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(components).To(BeNil())
+			Expect(namespaces).To(BeNil())
+		})
+	})
+
+	Context("when GetAllComponents chooses between metadata-only and full listing", func() {
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+		})
+
+		It("should list resources whose spec is never read with the metadata client", func() {
+			fakeDynamicClient.ClearActions()
+			fakeMetadataClient.ClearActions()
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).To(BeNil())
+			Expect(components).To(HaveLen(8))
+			Expect(fakeDynamicClient.Actions()).To(BeEmpty())
+			Expect(fakeMetadataClient.Actions()).ToNot(BeEmpty())
+
+			// The kind and apiVersion come from discovery since metadata list items are typed as PartialObjectMetadata
+			for _, comp := range components {
+				if comp.Name == "deployment-1" {
+					Expect(comp.Version).To(Equal("apps/v1"))
+					Expect(comp.GetKind()).To(Equal("Deployment"))
+				}
+			}
+		})
+
+		It("should list full objects for kinds whose version is read from the spec", func() {
+			helmChart := unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "helm.cattle.io/v1",
+				"kind":       "HelmChart",
+				"metadata":   map[string]interface{}{"name": "traefik", "namespace": "kube-system"},
+				"spec":       map[string]interface{}{"version": "27.0.201"},
+			}}
+			_, err := fakeDynamicClient.Resource(gvrs["helmcharts"]).Namespace("kube-system").Create(context.TODO(), &helmChart, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &v1.APIResourceList{
+				GroupVersion: "helm.cattle.io/v1",
+				APIResources: []v1.APIResource{{Name: "helmcharts", Namespaced: true, Kind: "HelmChart"}},
+			})
+			fakeDynamicClient.ClearActions()
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).To(BeNil())
+			Expect(components).To(HaveLen(9))
+			Expect(fakeDynamicClient.Actions()).To(HaveLen(1))
+			Expect(fakeDynamicClient.Actions()[0].GetResource()).To(Equal(gvrs["helmcharts"]))
+			componentMap := make(map[string]model.Component)
+			for _, comp := range components {
+				componentMap[comp.Name] = comp
+			}
+			Expect(componentMap["traefik"].Properties).To(ContainElement(
+				model.Property{Name: model.ComponentVersion, Values: []string{"27.0.201"}},
+			))
+		})
+	})
+
+	Context("when GetAllComponents follows the query plan of the filter", func() {
+		// listedIn returns the namespaces of the list actions on the resource, with "*" for all namespaces
+		listedIn := func(resource string) []string {
+			var namespaces []string
+			for _, action := range append(fakeMetadataClient.Actions(), fakeDynamicClient.Actions()...) {
+				if action.GetVerb() == "list" && action.GetResource().Resource == resource {
+					namespace := action.GetNamespace()
+					if namespace == "" {
+						namespace = "*"
+					}
+					namespaces = append(namespaces, namespace)
+				}
+			}
+			return namespaces
+		}
+
+		BeforeEach(func() {
+			fakeDiscovery.Resources[1].APIResources = append(fakeDiscovery.Resources[1].APIResources, v1.APIResource{Name: "replicasets", Namespaced: true, Kind: "ReplicaSet"})
+			DeferCleanup(func() { k8.K8Filter = model.Filter{} })
+		})
+
+		It("should only list the included kinds in the included namespaces", func() {
+			k8.K8Filter = model.Filter{
+				NamespacedInclusions:    []model.NamespacedInclusion{{Namespaces: []string{"kube-*"}, Resources: []string{"Pod"}}},
+				NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"PersistentVolume"}},
+			}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			components, namespaces, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedIn("pods")).To(Equal([]string{"kube-system"}))
+			Expect(listedIn("persistentvolumes")).To(Equal([]string{"*"}))
+			Expect(listedIn("deployments")).To(BeEmpty())
+			Expect(listedIn("services")).To(BeEmpty())
+			// The ReplicaSets link the Pods the images are taken from to their owners
+			Expect(listedIn("replicasets")).To(Equal([]string{"kube-system"}))
+			// The Namespaces are listed for the images even though the filter includes none of them
+			Expect(listedIn("namespaces")).To(Equal([]string{"*"}))
+			Expect(namespaces).To(ConsistOf(mockNamespaceList))
+			names := make([]string, 0, len(components))
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			Expect(names).To(ConsistOf("pod-3", "pod-4", "pv-1"))
+		})
+
+		It("should list a kind across all namespaces with a single request when an inclusion covers all of them", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{
+				{Namespaces: []string{"default"}, Resources: []string{"Pod"}},
+				{Namespaces: []string{"*"}, Resources: []string{"pods"}},
+			}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			_, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedIn("pods")).To(Equal([]string{"*"}))
+			Expect(listedIn("replicasets")).To(Equal([]string{"*"}))
+		})
+
+		It("should keep listing the other namespaces when one of them cannot be listed", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default", "kube-system"}, Resources: []string{"Pod"}}}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			fakeMetadataClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if action.GetNamespace() == "default" {
+					return true, nil, errors.New("pods are forbidden")
+				}
+				return false, nil, nil
+			})
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(components).To(ContainElement(HaveField("Name", "pod-3")))
+			Expect(fakeK8sClient.ResourceErrors).To(HaveLen(1))
+			Expect(fakeK8sClient.ResourceErrors[0].Error()).To(Equal("failed to list /v1, Resource=pods: namespace default: pods are forbidden"))
+		})
+
+		It("should plan the queries without listing anything but the namespaces", func() {
+			k8.K8Filter = model.Filter{
+				NamespacedInclusions:    []model.NamespacedInclusion{{Namespaces: []string{"default"}, Resources: []string{"deployments"}, Selectors: model.Selectors{LabelSelector: "app=web"}}},
+				NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"persistentvolumes"}},
+			}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			plan, err := fakeK8sClient.PlanQueries(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedIn("namespaces")).To(Equal([]string{"*"}))
+			Expect(listedIn("pods")).To(BeEmpty())
+			Expect(listedIn("deployments")).To(BeEmpty())
+			queries := make(map[string]k8.ResourceQuery)
+			for _, query := range plan.Queries {
+				queries[query.Kind] = query
+			}
+			Expect(queries).To(HaveLen(6))
+			Expect(queries["Deployment"]).To(And(HaveField("Namespaces", []string{"default"}), HaveField("LabelSelector", "app=web"), HaveField("Skip", "")))
+			Expect(queries["Pod"].Skip).ToNot(BeEmpty())
+			Expect(queries["Service"].Skip).ToNot(BeEmpty())
+			Expect(queries["PersistentVolume"].Skip).ToNot(BeEmpty())
+			Expect(queries["ReplicaSet"]).To(And(HaveField("Namespaces", []string{"default"}), HaveField("Purpose", Not(BeEmpty())), HaveField("Skip", "")))
+			Expect(queries["Namespace"]).To(And(HaveField("Namespaces", BeNil()), HaveField("Purpose", ""), HaveField("Skip", "")))
+			Expect(plan.Requests()).To(Equal(3))
+		})
+	})
+
+	Context("when GetAllComponents lists Nodes", func() {
+		createNode := func(name string, kubeletVersion string) {
+			node := unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Node",
+				"metadata": map[string]interface{}{
+					"name":   name,
+					"labels": map[string]interface{}{"node.kubernetes.io/instance-type": "m5.large"},
+				},
+				"status": map[string]interface{}{
+					"nodeInfo": map[string]interface{}{
+						"kubeletVersion":          kubeletVersion,
+						"kubeProxyVersion":        kubeletVersion,
+						"containerRuntimeVersion": "containerd://1.7.22",
+						"operatingSystem":         "linux",
+						"osImage":                 "Ubuntu 22.04.4 LTS",
+						"kernelVersion":           "5.15.0-1051-aws",
+						"architecture":            "amd64",
+					},
+				},
+			}}
+			_, err := fakeDynamicClient.Resource(gvrs["nodes"]).Create(context.TODO(), &node, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			fakeDiscovery.Resources[0].APIResources = append(fakeDiscovery.Resources[0].APIResources, v1.APIResource{Name: "nodes", Namespaced: false, Kind: "Node"})
+			fakeDiscovery.FakedServerVersion = &version.Info{GitVersion: "v1.31.2"}
+		})
+
+		It("should add the node info as properties of a platform component", func() {
+			createNode("node-1", "v1.31.1")
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			nodes := (&model.BOM{Components: components}).FindPlatforms("node-1", "Node", "")
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].Version).To(Equal("v1.31.1"))
+			Expect(nodes[0].Properties).To(ContainElements(
+				model.Property{Name: model.NodeKubeletVersion, Values: []string{"v1.31.1"}},
+				model.Property{Name: model.NodeKubeProxyVersion, Values: []string{"v1.31.1"}},
+				model.Property{Name: model.NodeContainerRuntime, Values: []string{"containerd"}},
+				model.Property{Name: model.NodeContainerRuntimeVersion, Values: []string{"1.7.22"}},
+				model.Property{Name: model.NodeOperatingSystem, Values: []string{"linux"}},
+				model.Property{Name: model.NodeOSImage, Values: []string{"Ubuntu 22.04.4 LTS"}},
+				model.Property{Name: model.NodeKernelVersion, Values: []string{"5.15.0-1051-aws"}},
+				model.Property{Name: model.NodeArchitecture, Values: []string{"amd64"}},
+				model.Property{Name: model.NodeInstanceType, Values: []string{"m5.large"}},
+			))
+		})
+
+		DescribeTable("should check the kubelet version against the version skew policy",
+			func(kubeletVersion string, expected string) {
+				createNode("node-1", kubeletVersion)
+
+				components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+				Expect(err).ToNot(HaveOccurred())
+				nodes := (&model.BOM{Components: components}).FindPlatforms("node-1", "Node", "")
+				Expect(nodes).To(HaveLen(1))
+				Expect(nodes[0].Properties).To(ContainElement(model.Property{Name: model.NodeVersionSkew, Values: []string{expected}}))
+			},
+			Entry("same version", "v1.31.2", k8.VersionSkewSupported),
+			Entry("three minor versions older", "v1.28.9", k8.VersionSkewSupported),
+			Entry("four minor versions older", "v1.27.16", k8.VersionSkewUnsupported),
+			Entry("newer than the API server", "v1.32.0", k8.VersionSkewUnsupported),
+		)
+
+		It("should reuse the server version read for the cluster info", func() {
+			createNode("node-1", "v1.27.16")
+			versionRequests := func() int {
+				count := 0
+				for _, action := range fakeClientset.Actions() {
+					if action.GetResource().Resource == "version" {
+						count++
+					}
+				}
+				return count
+			}
+
+			_, err := fakeK8sClient.GetClusterInfo(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versionRequests()).To(Equal(1))
+			nodes := (&model.BOM{Components: components}).FindPlatforms("node-1", "Node", "")
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].Properties).To(ContainElement(model.Property{Name: model.NodeVersionSkew, Values: []string{k8.VersionSkewUnsupported}}))
+		})
+	})
+
+	Context("when GetClusterInfo is called with a K8s client", func() {
+		addNode := func(name string, labels map[string]string, providerID string) {
+			node := &corev1.Node{
+				ObjectMeta: v1.ObjectMeta{Name: name, Labels: labels},
+				Spec:       corev1.NodeSpec{ProviderID: providerID},
+			}
+			Expect(fakeClientset.Tracker().Add(node)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			kubeSystem := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "kube-system", UID: "6f1d3c2a-9b8e-4c7d-a5f4-3e2d1c0b9a87"}}
+			Expect(fakeClientset.Tracker().Add(kubeSystem)).To(Succeed())
+		})
+
+		It("should return the server version and the UID of the kube-system namespace", func() {
+			fakeDiscovery.FakedServerVersion = &version.Info{GitVersion: "v1.31.2", Platform: "linux/arm64", BuildDate: "2024-10-22T20:28:14Z"}
+
+			info, err := fakeK8sClient.GetClusterInfo(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info).To(Equal(k8.ClusterInfo{
+				GitVersion: "v1.31.2",
+				Platform:   "linux/arm64",
+				BuildDate:  "2024-10-22T20:28:14Z",
+				UID:        "6f1d3c2a-9b8e-4c7d-a5f4-3e2d1c0b9a87",
+			}))
+		})
+
+		DescribeTable("should detect the provider from the server version and the nodes",
+			func(gitVersion string, labels map[string]string, providerID string, expected string) {
+				fakeDiscovery.FakedServerVersion = &version.Info{GitVersion: gitVersion}
+				addNode("node-1", labels, providerID)
+
+				info, err := fakeK8sClient.GetClusterInfo(context.Background())
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Provider).To(Equal(expected))
+			},
+			Entry("EKS from the version", "v1.30.4-eks-a737599", nil, "aws:///us-east-1a/i-0abc", k8.ProviderEKS),
+			Entry("EKS from the node group label", "v1.30.4", map[string]string{"eks.amazonaws.com/nodegroup": "default"}, "", k8.ProviderEKS),
+			Entry("GKE from the version", "v1.30.5-gke.1014001", nil, "gce://project/us-central1-a/node-1", k8.ProviderGKE),
+			Entry("AKS from the cluster label", "v1.30.3", map[string]string{"kubernetes.azure.com/cluster": "MC_rg_aks"}, "azure:///subscriptions/1", k8.ProviderAKS),
+			Entry("OpenShift from the OS label", "v1.29.8+f10c92d", map[string]string{"node.openshift.io/os_id": "rhcos"}, "", k8.ProviderOpenShift),
+			Entry("only AWS from the provider ID", "v1.30.4", nil, "aws:///us-east-1a/i-0abc", k8.ProviderAWS),
+			Entry("only GCP from the provider ID", "v1.30.5", nil, "gce://project/us-central1-a/node-1", k8.ProviderGCP),
+			Entry("only Azure from the provider ID", "v1.30.3", nil, "azure:///subscriptions/1/resourceGroups/MC_rg_aks/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1/virtualMachines/0", k8.ProviderAzure),
+			Entry("kind from the provider ID", "v1.31.0", nil, "kind://docker/kind/kind-control-plane", k8.ProviderKind),
+			Entry("k3s from the version", "v1.30.5+k3s1", nil, "k3s://node-1", k8.ProviderK3s),
+			Entry("an unknown provider", "v1.31.2", nil, "", ""),
+		)
+
+		It("should prefer the labels of any node to the provider ID", func() {
+			fakeDiscovery.FakedServerVersion = &version.Info{GitVersion: "v1.30.4"}
+			addNode("node-1", nil, "aws:///us-east-1a/i-0abc")
+			addNode("node-2", map[string]string{"eks.amazonaws.com/nodegroup": "default"}, "aws:///us-east-1b/i-0def")
+
+			info, err := fakeK8sClient.GetClusterInfo(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Provider).To(Equal(k8.ProviderEKS))
+		})
+
+		It("should return an error when the server version cannot be read", func() {
+			fakeClientset.PrependReactor("get", "version", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("connection refused")
+			})
+
+			_, err := fakeK8sClient.GetClusterInfo(context.Background())
+
+			Expect(err).To(MatchError(ContainSubstring("connection refused")))
+		})
+	})
+
+	Context("when a Watcher keeps the BOM current", func() {
+		var (
+			ctx             context.Context
+			cancel          context.CancelFunc
+			boms            chan *model.BOM
+			podWatchStarted chan struct{}
+		)
+
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			DeferCleanup(func() { k8.K8Filter = model.Filter{} })
+			ctx, cancel = context.WithCancel(context.Background())
+			boms = make(chan *model.BOM, 10)
+
+			// Events sent before the informer starts watching would be lost, so wait for the watch before changing Pods
+			podWatchStarted = make(chan struct{})
+			var podWatchOnce sync.Once
+			tracker := fakeClientset.Tracker()
+			fakeClientset.PrependWatchReactor("pods", func(action clienttesting.Action) (bool, watch.Interface, error) {
+				podWatch, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+				podWatchOnce.Do(func() { close(podWatchStarted) })
+				return true, podWatch, err
+			})
+		})
+
+		runWatcher := func() chan error {
+			watcher := k8.NewWatcher(fakeK8sClient)
+			watcher.Debounce = 10 * time.Millisecond
+			done := make(chan error, 1)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				done <- watcher.Run(ctx, func() {
+					bom, err := cmd.GenerateBOM(ctx, watcher)
+					if err != nil {
+						return
+					}
+					select {
+					case boms <- bom:
+					case <-ctx.Done():
+					}
+				})
+			}()
+			// Stop the informers before the next spec replaces the fake clients
+			DeferCleanup(func() {
+				cancel()
+				Eventually(stopped).Should(BeClosed())
+			})
+			return done
+		}
+
+		It("should build the same BOM from the informer caches as from listing the cluster", func() {
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+			Expect(fakeClientset.Tracker().Add(newHelmReleaseSecret("web", "default", 1, "deployed"))).To(Succeed())
+			expected, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+			Expect(err).To(BeNil())
+			Expect(expected.FindApplicationsByKind(k8.HelmReleaseKind, "default")).To(HaveLen(1))
+
+			done := runWatcher()
+
+			var bom *model.BOM
+			Eventually(boms).Should(Receive(&bom))
+			Expect(bom.Components).To(ConsistOf(expected.Components))
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should rebuild the BOM when a Pod with a new image is added", func() {
+			runWatcher()
+			var initial *model.BOM
+			Eventually(boms).Should(Receive(&initial))
+			Expect(initial.FindContainersByKind("Image", "default")).ToNot(BeEmpty())
+			Eventually(podWatchStarted).Should(BeClosed())
+
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Name: "redis-1", Namespace: "default"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "redis", Image: "redis:7.4"}}},
+			}
+			_, err := fakeClientset.CoreV1().Pods("default").Create(ctx, pod, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			var bom *model.BOM
+			Eventually(boms).Should(Receive(&bom))
+			added, removed, _ := model.DiffComponents(initial.Components, bom.Components)
+			Expect(removed).To(BeEmpty())
+			Expect(added).To(HaveLen(1))
+			Expect(added[0].Name).To(Equal("index.docker.io/library/redis"))
+		})
+
+		It("should rebuild the BOM when a Helm release is upgraded", func() {
+			secretWatchStarted := make(chan struct{})
+			var secretWatchOnce sync.Once
+			tracker := fakeClientset.Tracker()
+			fakeClientset.PrependWatchReactor("secrets", func(action clienttesting.Action) (bool, watch.Interface, error) {
+				secretWatch, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+				secretWatchOnce.Do(func() { close(secretWatchStarted) })
+				return true, secretWatch, err
+			})
+			Expect(tracker.Add(newHelmReleaseSecret("web", "default", 1, "deployed"))).To(Succeed())
+			// revision returns the revision of the web release in the BOM, or "" when it is not there
+			revision := func(bom *model.BOM) string {
+				for _, release := range bom.FindApplicationsByKind(k8.HelmReleaseKind, "default") {
+					value, _ := release.GetProperty(model.HelmRevision)
+					return value
+				}
+				return ""
+			}
+
+			runWatcher()
+			var initial *model.BOM
+			Eventually(boms).Should(Receive(&initial))
+			Expect(revision(initial)).To(Equal("1"))
+			Eventually(secretWatchStarted).Should(BeClosed())
+
+			Expect(tracker.Update(corev1.SchemeGroupVersion.WithResource("secrets"), newHelmReleaseSecret("web", "default", 1, "superseded"), "default")).To(Succeed())
+			Expect(tracker.Add(newHelmReleaseSecret("web", "default", 2, "deployed"))).To(Succeed())
+
+			Eventually(func() string {
+				var bom *model.BOM
+				Eventually(boms).Should(Receive(&bom))
+				return revision(bom)
+			}).Should(Equal("2"))
+		})
+
+		It("should not watch resource types it is not allowed to list", func() {
+			fakeMetadataClient.PrependReactor("list", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("services are forbidden")
+			})
+
+			runWatcher()
+
+			var bom *model.BOM
+			Eventually(boms).Should(Receive(&bom))
+			Expect(bom.FindApplicationsByKind("Deployment", "default")).To(HaveLen(1))
+		})
+
+		It("should only watch the resource types and namespaces the filter lists", func() {
+			k8.K8Filter = model.Filter{
+				NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Namespace"}},
+				NamespacedInclusions:    []model.NamespacedInclusion{{Namespaces: []string{"default"}, Resources: []string{"Pod", "Deployment"}}},
+			}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			runWatcher()
+
+			var bom *model.BOM
+			Eventually(boms).Should(Receive(&bom))
+			Expect(bom.FindApplicationsByKind("Deployment", "default")).To(HaveLen(1))
+			Expect(bom.FindApplicationsByKind("Pod", "kube-system")).To(BeEmpty())
+			for _, action := range append(fakeMetadataClient.Actions(), fakeClientset.Actions()...) {
+				if !action.Matches("list", action.GetResource().Resource) && !action.Matches("watch", action.GetResource().Resource) {
+					continue
+				}
+				Expect(action.GetResource().Resource).ToNot(BeElementOf("services", "persistentvolumes"))
+				if resource := action.GetResource().Resource; resource == "pods" || resource == "deployments" {
+					Expect(action.GetNamespace()).To(Equal("default"), "%s %s", action.GetVerb(), resource)
+				}
+			}
+		})
+
+		It("should start watching a namespace the filter matches once it is created", func() {
+			namespaceWatchStarted := make(chan struct{})
+			var namespaceWatchOnce sync.Once
+			tracker := fakeMetadataClient.Tracker()
+			fakeMetadataClient.PrependWatchReactor("namespaces", func(action clienttesting.Action) (bool, watch.Interface, error) {
+				namespaceWatch, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+				namespaceWatchOnce.Do(func() { close(namespaceWatchStarted) })
+				return true, namespaceWatch, err
+			})
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*"}, Resources: []string{"Deployment"}}}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			runWatcher()
+			var initial *model.BOM
+			Eventually(boms).Should(Receive(&initial))
+			Expect(initial.FindApplicationsByKind("Deployment", "team-a")).To(BeEmpty())
+			Eventually(namespaceWatchStarted).Should(BeClosed())
+
+			Expect(tracker.Add(toPartialObjectMetadata(createMockResources("deployments", []string{"web"}, "team-a")[0]))).To(Succeed())
+			Expect(tracker.Add(toPartialObjectMetadata(createMockResources("namespaces", []string{"team-a"}, "")[0]))).To(Succeed())
+
+			Eventually(func() []model.Component {
+				var bom *model.BOM
+				Eventually(boms).Should(Receive(&bom))
+				return bom.FindApplicationsByKind("Deployment", "team-a")
+			}).Should(HaveLen(1))
+		})
+	})
+
+	Context("when GetAllImages collects the pod templates of workloads", func() {
+		// imagesByName returns the images by name and version
+		imagesByName := func(components []model.Component) map[string]model.Component {
+			images := make(map[string]model.Component)
+			for _, component := range components {
+				images[component.Name+":"+component.Version] = component
+			}
+			return images
+		}
+
+		It("should mark the images no Pod runs as declared", func() {
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			images := imagesByName(components)
+			Expect(images).To(HaveKey("index.docker.io/library/busybox:1.36"))
+			busybox := images["index.docker.io/library/busybox:1.36"]
+			Expect(busybox.Properties).To(ContainElements(
+				model.Property{Name: model.ImageState, Values: []string{k8.ImageStateDeclared}},
+				model.Property{Name: model.ComponentOwnerRef, Values: []string{"default/CronJob/cleanup"}},
+			))
+			Expect(busybox.Evidence.Occurrences).To(Equal([]model.Occurrence{
+				{Location: "default/CronJob/cleanup", Symbol: "cleanup", AdditionalContext: "role=main, replicas=0"},
+			}))
+			Expect(images["index.docker.io/library/nginx:latest"].Properties).To(ContainElement(
+				model.Property{Name: model.ImageState, Values: []string{k8.ImageStateRunning}},
+			))
+		})
+
+		It("should merge the image a workload declares with the image its Pods run", func() {
+			replicas := int32(0)
+			statefulSet := &appsv1.StatefulSet{
+				ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: appsv1.StatefulSetSpec{
+					Replicas: &replicas,
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.27"}}}},
+				},
+			}
+			Expect(fakeClientset.Tracker().Add(statefulSet)).To(Succeed())
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:            "web-0",
+					Namespace:       "default",
+					OwnerReferences: []v1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web"}},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.27"}}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:    "web",
+					ImageID: "docker.io/library/nginx@sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a",
+					State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				}}},
+			}
+			Expect(fakeClientset.Tracker().Add(pod)).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			images := imagesByName(components)
+			Expect(images).To(HaveKey("index.docker.io/library/nginx:1.27"))
+			nginx := images["index.docker.io/library/nginx:1.27"]
+			Expect(nginx.Hashes).To(HaveLen(1))
+			Expect(nginx.Evidence.Occurrences).To(Equal([]model.Occurrence{
+				{Location: "default/StatefulSet/web", Symbol: "web", AdditionalContext: "role=main, replicas=1, pods=web-0"},
+			}))
+			Expect(nginx.Properties).To(ContainElement(model.Property{Name: model.ImageState, Values: []string{k8.ImageStateRunning}}))
+		})
+
+		It("should record the replicas a workload declares when no Pod runs its image", func() {
+			replicas := int32(3)
+			deployment := &appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "api", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: &replicas,
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "api", Image: "nginx:1.27"}}}},
+				},
+			}
+			Expect(fakeClientset.Tracker().Add(deployment)).To(Succeed())
+			statefulSet := &appsv1.StatefulSet{
+				ObjectMeta: v1.ObjectMeta{Name: "db", Namespace: "default"},
+				Spec: appsv1.StatefulSetSpec{
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "db", Image: "nginx:1.27"}}}},
+				},
+			}
+			Expect(fakeClientset.Tracker().Add(statefulSet)).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			images := imagesByName(components)
+			Expect(images).To(HaveKey("index.docker.io/library/nginx:1.27"))
+			Expect(images["index.docker.io/library/nginx:1.27"].Evidence.Occurrences).To(ConsistOf(
+				model.Occurrence{Location: "default/Deployment/api", Symbol: "api", AdditionalContext: "role=main, replicas=3"},
+				model.Occurrence{Location: "default/StatefulSet/db", Symbol: "db", AdditionalContext: "role=main, replicas=1"},
+			))
+		})
+
+		It("should skip the kinds of workloads it is not allowed to list", func() {
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+			fakeClientset.PrependReactor("list", "cronjobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("cronjobs are forbidden")
+			})
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			images := imagesByName(components)
+			Expect(images).ToNot(HaveKey("index.docker.io/library/busybox:1.36"))
+			Expect(images).To(HaveKey("index.docker.io/library/nginx:latest"))
+		})
+
+		It("should list each kind of workload once across the namespaces and keep only the ones asked for", func() {
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+			Expect(fakeClientset.Tracker().Add(newCronJob("report", "other", "alpine:3.20"))).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			var cronJobLists []string
+			for _, action := range fakeClientset.Actions() {
+				if action.GetVerb() == "list" && action.GetResource().Resource == "cronjobs" {
+					cronJobLists = append(cronJobLists, action.GetNamespace())
+				}
+			}
+			Expect(cronJobLists).To(Equal([]string{v1.NamespaceAll}))
+			images := imagesByName(components)
+			Expect(images).To(HaveKey("index.docker.io/library/busybox:1.36"))
+			Expect(images).ToNot(HaveKey("index.docker.io/library/alpine:3.20"))
+		})
+
+		It("should list each namespace when it is not allowed to list the workloads across all of them", func() {
+			Expect(fakeClientset.Tracker().Add(newCronJob("cleanup", "default", "busybox:1.36"))).To(Succeed())
+			fakeClientset.PrependReactor("list", "cronjobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if action.GetNamespace() == v1.NamespaceAll {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "cronjobs"}, "", errors.New("cluster-wide"))
+				}
+				return false, nil, nil
+			})
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imagesByName(components)).To(HaveKey("index.docker.io/library/busybox:1.36"))
+		})
+
+		It("should leave out the ReplicaSets a Deployment scaled down", func() {
+			replicas := int32(0)
+			controller := true
+			replicaSet := &appsv1.ReplicaSet{
+				ObjectMeta: v1.ObjectMeta{
+					Name:            "web-5f6b",
+					Namespace:       "default",
+					OwnerReferences: []v1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: &controller}},
+				},
+				Spec: appsv1.ReplicaSetSpec{
+					Replicas: &replicas,
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.26"}}}},
+				},
+			}
+			Expect(fakeClientset.Tracker().Add(replicaSet)).To(Succeed())
+			standalone := replicaSet.DeepCopy()
+			standalone.Name, standalone.OwnerReferences = "batch", nil
+			standalone.Spec.Template.Spec.Containers[0].Image = "busybox:1.36"
+			Expect(fakeClientset.Tracker().Add(standalone)).To(Succeed())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			images := imagesByName(components)
+			Expect(images).ToNot(HaveKey("index.docker.io/library/nginx:1.26"))
+			Expect(images).To(HaveKey("index.docker.io/library/busybox:1.36"))
+		})
+	})
+
+	Context("when image rules find the images of custom resources", func() {
+		knativeServices := schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "services"}
+
+		BeforeEach(func() {
+			Expect(k8.SetImageRules(model.ImageRules{Rules: []model.ImageRule{{
+				Group: "serving.knative.dev",
+				Kind:  "Service",
+				Paths: []string{".spec.template.spec.containers[*].image", "{.spec.template.spec.initContainers[*].image}"},
+			}}})).To(Succeed())
+			fakeDynamicClient = dynamicfakeclient.NewSimpleDynamicClientWithCustomListKinds(
+				runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{knativeServices: "ServiceList"},
+			)
+			fakeK8sClient.DynamicClient = fakeDynamicClient
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &v1.APIResourceList{
+				GroupVersion: "serving.knative.dev/v1",
+				APIResources: []v1.APIResource{{Name: "services", Namespaced: true, Kind: "Service"}},
+			})
+			service := unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "serving.knative.dev/v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "hello", "namespace": "default"},
+				"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"image": "gcr.io/knative-samples/helloworld-go:v1"},
+						map[string]interface{}{"image": "Not An Image!"},
+					},
+				}}},
+			}}
+			_, err := fakeDynamicClient.Resource(knativeServices).Namespace("default").Create(context.TODO(), &service, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(k8.SetImageRules(model.ImageRules{})).To(Succeed())
+		})
+
+		It("should add the images as declared containers owned by the custom resource", func() {
+			fakeDynamicClient.ClearActions()
+			_, _, err := fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			// Only the kind with a rule is listed in full
+			Expect(fakeDynamicClient.Actions()).To(HaveLen(1))
+			Expect(fakeDynamicClient.Actions()[0].GetResource()).To(Equal(knativeServices))
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			var hello []model.Component
+			for _, component := range components {
+				if component.Name == "gcr.io/knative-samples/helloworld-go" {
+					hello = append(hello, component)
+				}
+			}
+			Expect(hello).To(HaveLen(1))
+			Expect(hello[0].Type).To(Equal("container"))
+			Expect(hello[0].Version).To(Equal("v1"))
+			Expect(hello[0].Properties).To(ContainElements(
+				model.Property{Name: model.ComponentNamespace, Values: []string{"default"}},
+				model.Property{Name: model.ComponentSourceRef, Values: []string{"rule"}},
+				model.Property{Name: model.ComponentOwnerRef, Values: []string{"default/Service/hello"}},
+				model.Property{Name: model.ImageState, Values: []string{k8.ImageStateDeclared}},
+			))
+			Expect(hello[0].Evidence.Occurrences).To(Equal([]model.Occurrence{{
+				Location:          "default/Service/hello",
+				Symbol:            ".spec.template.spec.containers[*].image",
+				AdditionalContext: "role=rule, replicas=0",
+			}}))
+			Expect(hello[0].Owners).To(HaveLen(1))
+			Expect(components).To(HaveLen(3))
+		})
+
+		It("should skip the custom resources outside of the namespaces", func() {
+			_, _, err := fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), []string{"kube-system"})
+
+			Expect(err).ToNot(HaveOccurred())
+			for _, component := range components {
+				Expect(component.Name).ToNot(Equal("gcr.io/knative-samples/helloworld-go"))
+			}
+		})
+
+		It("should reject rules with invalid paths", func() {
+			err := k8.SetImageRules(model.ImageRules{Rules: []model.ImageRule{{Kind: "Workflow", Paths: []string{"{.spec.templates[*"}}}})
+			Expect(err).To(MatchError(ContainSubstring("image rule for Workflow has an invalid path")))
+			Expect(k8.SetImageRules(model.ImageRules{Rules: []model.ImageRule{{Kind: "Workflow"}}})).To(MatchError("image rule for Workflow has no paths"))
+		})
+	})
+
+	Context("when version rules find the versions of objects", func() {
+		// addDeployment adds a Deployment with the labels and annotations to the metadata client
+		addDeployment := func(name string, labels map[string]string, annotations map[string]string) {
+			Expect(fakeMetadataClient.Tracker().Add(&v1.PartialObjectMetadata{
+				TypeMeta:   v1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default", Labels: labels, Annotations: annotations},
+			})).To(Succeed())
+		}
+
+		// versions returns the versions of the components by name
+		versions := func() map[string]string {
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			found := make(map[string]string)
+			for _, component := range components {
+				if version, exists := component.GetProperty(model.ComponentVersion); exists {
+					found[component.Name] = version
+				}
+			}
+			return found
+		}
+
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			addDeployment("chart", map[string]string{"helm.sh/chart": "chart-1.0.0", "app.kubernetes.io/version": "1.0"}, nil)
+			addDeployment("app", map[string]string{"app.kubernetes.io/version": "2.3.1"}, nil)
+			addDeployment("annotated", nil, map[string]string{"acme.io/release": "2025.1"})
+		})
+
+		AfterEach(func() {
+			Expect(k8.SetVersionRules(model.VersionRules{})).To(Succeed())
+		})
+
+		It("should read the versions from the labels of the built-in rules", func() {
+			Expect(versions()).To(Equal(map[string]string{"chart": "chart-1.0.0", "app": "2.3.1"}))
+		})
+
+		It("should try the user rules before the built-in rules", func() {
+			Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{
+				{Group: "apps", Kind: "Deployment", Annotation: "acme.io/release"},
+				{Group: "*", Kind: "*", Label: "app.kubernetes.io/version"},
+			}})).To(Succeed())
+
+			Expect(versions()).To(Equal(map[string]string{"chart": "1.0", "app": "2.3.1", "annotated": "2025.1"}))
+		})
+
+		It("should list the kinds whose version rules read a path in full", func() {
+			Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{
+				{Group: "", Kind: "PersistentVolume", Path: ".spec.capacity.storage"},
+			}})).To(Succeed())
+			pv := createMockResources("persistentvolumes", []string{"pv-2"}, "")[0]
+			pv.Object["spec"] = map[string]interface{}{"capacity": map[string]interface{}{"storage": "10Gi"}}
+			_, err := fakeDynamicClient.Resource(gvrs["persistentvolumes"]).Create(context.TODO(), &pv, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			fakeDynamicClient.ClearActions()
+
+			found := versions()
+
+			Expect(found).To(HaveKeyWithValue("pv-2", "10Gi"))
+			Expect(fakeDynamicClient.Actions()).To(HaveLen(1))
+			Expect(fakeDynamicClient.Actions()[0].GetResource()).To(Equal(gvrs["persistentvolumes"]))
+		})
+
+		It("should reject invalid rules", func() {
+			Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{{Group: "apps", Kind: "Deployment"}}})).To(
+				MatchError("version rule for Deployment must have exactly one of path, label and annotation"))
+			Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{{Group: "*", Kind: "*", Path: ".status.version"}}})).To(
+				MatchError("version rule for * must name a single group and kind to use a path"))
+			Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{{Group: "apps", Kind: "Deployment", Path: "{.spec["}}})).To(
+				MatchError(ContainSubstring("version rule for Deployment has an invalid path")))
+		})
+	})
+
+	Context("when GetAllComponents reads the Helm releases", func() {
+		release := k8.GetAppPkgId(k8.HelmReleaseKind, "web", "default", k8.HelmReleaseAPIVersion)
+		deployment := k8.GetAppPkgId("Deployment", "web", "default", "apps/v1")
+		clusterRole := k8.GetAppPkgId("ClusterRole", "web", "", "rbac.authorization.k8s.io/v1")
+
+		BeforeEach(func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &v1.APIResourceList{
+				GroupVersion: "rbac.authorization.k8s.io/v1",
+				APIResources: []v1.APIResource{{Name: "clusterroles", Namespaced: false, Kind: "ClusterRole"}},
+			})
+			for _, object := range []*v1.PartialObjectMetadata{
+				{
+					TypeMeta:   v1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+					ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{"meta.helm.sh/release-name": "web"}},
+				},
+				{
+					TypeMeta: v1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+					ObjectMeta: v1.ObjectMeta{Name: "web", Annotations: map[string]string{
+						"meta.helm.sh/release-name":      "web",
+						"meta.helm.sh/release-namespace": "default",
+					}},
+				},
+			} {
+				Expect(fakeMetadataClient.Tracker().Add(object)).To(Succeed())
+			}
+			Expect(fakeClientset.Tracker().Add(newHelmReleaseSecret("web", "default", 1, "superseded"))).To(Succeed())
+			Expect(fakeClientset.Tracker().Add(newHelmReleaseSecret("web", "default", 2, "deployed"))).To(Succeed())
+		})
+
+		It("should add the deployed revision of each release with its chart", func() {
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			var releases []model.Component
+			for _, component := range components {
+				if component.GetKind() == k8.HelmReleaseKind {
+					releases = append(releases, component)
+				}
+			}
+			Expect(releases).To(HaveLen(1))
+			Expect(releases[0].Name).To(Equal("web"))
+			Expect(releases[0].PackageURL).To(Equal(release))
+			Expect(releases[0].Properties).To(ContainElements(
+				model.Property{Name: model.ComponentNamespace, Values: []string{"default"}},
+				model.Property{Name: model.ComponentVersion, Values: []string{"1.2.0"}},
+				model.Property{Name: model.HelmChart, Values: []string{"web"}},
+				model.Property{Name: model.HelmChartVersion, Values: []string{"1.2.0"}},
+				model.Property{Name: model.HelmAppVersion, Values: []string{"2.4.1"}},
+				model.Property{Name: model.HelmRevision, Values: []string{"2"}},
+				model.Property{Name: model.HelmStatus, Values: []string{"deployed"}},
+				model.Property{Name: model.HelmLastDeployed, Values: []string{"2025-03-04T09:15:30Z"}},
+			))
+		})
+
+		It("should link each release to the objects it rendered, in any namespace", func() {
+			bom, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bom.Dependencies).To(ContainElement(model.Dependency{Ref: release, DependsOn: []string{deployment, clusterRole}}))
+		})
+
+		It("should read the releases from ConfigMaps with the configmap driver", func() {
+			fakeK8sClient.HelmDriver = k8.HelmDriverConfigMap
+			Expect(fakeClientset.Tracker().Add(&corev1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "api.v3",
+					Namespace: "kube-system",
+					Labels:    map[string]string{"owner": "helm", "name": "api", "status": "deployed", "version": "3"},
+				},
+				Data: map[string]string{"release": helmReleaseData("api", "kube-system", 3, "deployed")},
+			})).To(Succeed())
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			var releases []string
+			for _, component := range components {
+				if component.GetKind() == k8.HelmReleaseKind {
+					releases = append(releases, component.GetNamespace()+"/"+component.Name)
+				}
+			}
+			Expect(releases).To(Equal([]string{"kube-system/api"}))
+		})
+
+		It("should report the Helm storage it is not allowed to list", func() {
+			fakeClientset.PrependReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("secrets are forbidden")
+			})
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(components).ToNot(BeEmpty())
+			Expect(fakeK8sClient.ResourceErrors).To(ContainElement(HaveField("GVR", corev1.SchemeGroupVersion.WithResource("secrets"))))
+		})
+
+		It("should only list the Helm storage in the namespaces the filter includes", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default"}}}}
+			DeferCleanup(func() { k8.K8Filter = model.Filter{} })
+			Expect(fakeClientset.Tracker().Add(newHelmReleaseSecret("api", "kube-system", 1, "deployed"))).To(Succeed())
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			var releases []string
+			for _, component := range components {
+				if component.GetKind() == k8.HelmReleaseKind {
+					releases = append(releases, component.GetNamespace()+"/"+component.Name)
+				}
+			}
+			Expect(releases).To(Equal([]string{"default/web"}))
+			var listed []string
+			for _, action := range fakeClientset.Actions() {
+				if action.GetVerb() == "list" && action.GetResource().Resource == "secrets" {
+					listed = append(listed, action.GetNamespace())
+				}
+			}
+			Expect(listed).To(Equal([]string{"default"}))
+		})
+
+		It("should leave out the Helm releases it is forbidden to list without failing", func() {
+			fakeClientset.PrependReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(corev1.Resource("secrets"), "", errors.New("no RBAC policy matched"))
+			})
+
+			components, _, err := fakeK8sClient.GetAllComponents(context.Background())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(components).ToNot(ContainElement(HaveField("Version", k8.HelmReleaseAPIVersion)))
+			Expect(fakeK8sClient.ResourceErrors).ToNot(ContainElement(HaveField("GVR", corev1.SchemeGroupVersion.WithResource("secrets"))))
+		})
+	})
+
+	Context("when the owner references are emitted as dependencies", func() {
+		// addOwned adds an object owned by the given owner to the metadata client
+		addOwned := func(apiVersion string, kind string, name string, owner *v1.OwnerReference) {
+			object := &v1.PartialObjectMetadata{
+				TypeMeta:   v1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+				ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"},
+			}
+			if owner != nil {
+				object.OwnerReferences = []v1.OwnerReference{*owner}
+			}
+			Expect(fakeMetadataClient.Tracker().Add(object)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			fakeDiscovery.Resources[1].APIResources = append(fakeDiscovery.Resources[1].APIResources, v1.APIResource{Name: "replicasets", Namespaced: true, Kind: "ReplicaSet"})
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &v1.APIResourceList{
+				GroupVersion: "helm.toolkit.fluxcd.io/v2",
+				APIResources: []v1.APIResource{{Name: "helmreleases", Namespaced: true, Kind: "HelmRelease"}},
+			})
+
+			// The version of a HelmRelease is read from its spec, so it is listed in full
+			helmReleases := schema.GroupVersionResource{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"}
+			fakeDynamicClient = dynamicfakeclient.NewSimpleDynamicClientWithCustomListKinds(
+				runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{helmReleases: "HelmReleaseList"},
+			)
+			fakeK8sClient.DynamicClient = fakeDynamicClient
+			release := unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "helm.toolkit.fluxcd.io/v2",
+				"kind":       "HelmRelease",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+				"spec": map[string]interface{}{"chart": map[string]interface{}{"spec": map[string]interface{}{
+					"chart":   "web",
+					"version": "4.2.0",
+				}}},
+			}}
+			_, err := fakeDynamicClient.Resource(helmReleases).Namespace("default").Create(context.TODO(), &release, v1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			addOwned("apps/v1", "Deployment", "web", &v1.OwnerReference{APIVersion: "helm.toolkit.fluxcd.io/v2", Kind: "HelmRelease", Name: "web"})
+			addOwned("apps/v1", "ReplicaSet", "web-7d9c", &v1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"})
+			addOwned("v1", "Pod", "web-7d9c-abcde", &v1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-7d9c"})
+
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:            "web-7d9c-abcde",
+					Namespace:       "default",
+					OwnerReferences: []v1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-7d9c"}},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "acme/web:1.2.0"}}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "web",
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				}}},
+			}
+			Expect(fakeClientset.Tracker().Add(pod)).To(Succeed())
+		})
+
+		helmRelease := k8.GetAppPkgId("HelmRelease", "web", "default", "helm.toolkit.fluxcd.io/v2")
+		deployment := k8.GetAppPkgId("Deployment", "web", "default", "apps/v1")
+		replicaSet := k8.GetAppPkgId("ReplicaSet", "web-7d9c", "default", "apps/v1")
+		pod := k8.GetAppPkgId("Pod", "web-7d9c-abcde", "default", "v1")
+
+		// imageRef returns the bom-ref of the web image
+		imageRef := func(bom *model.BOM) string {
+			for _, component := range bom.Components {
+				if component.Name == "index.docker.io/acme/web" {
+					return component.BOMRef
+				}
+			}
+			return ""
+		}
+
+		It("should link each owner to the objects it owns down to the images", func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			bom, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			image := imageRef(bom)
+			Expect(image).ToNot(BeEmpty())
+			Expect(bom.Dependencies).To(ContainElements(
+				model.Dependency{Ref: helmRelease, DependsOn: []string{deployment}},
+				model.Dependency{Ref: deployment, DependsOn: []string{replicaSet}},
+				model.Dependency{Ref: replicaSet, DependsOn: []string{pod}},
+				model.Dependency{Ref: pod, DependsOn: []string{image}},
+			))
+			var version string
+			for _, component := range bom.Components {
+				if component.PackageURL == helmRelease {
+					version, _ = component.GetProperty(model.ComponentVersion)
+				}
+			}
+			Expect(version).To(Equal("4.2.0"))
+		})
+
+		It("should link the owners to the nearest included objects when the filter excludes part of the chain", func() {
+			k8.K8Filter = model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"HelmRelease", "Deployment"}}}}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			bom, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bom.Dependencies).To(ContainElements(
+				model.Dependency{Ref: helmRelease, DependsOn: []string{deployment}},
+				model.Dependency{Ref: deployment, DependsOn: []string{imageRef(bom)}},
+			))
+		})
+
+		It("should tell apart the owners with the same kind and name in different groups", func() {
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &v1.APIResourceList{
+				GroupVersion: "releases.example.com/v1",
+				APIResources: []v1.APIResource{{Name: "helmreleases", Namespaced: true, Kind: "HelmRelease"}},
+			})
+			addOwned("releases.example.com/v1", "HelmRelease", "web", nil)
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			bom, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			otherRelease := k8.GetAppPkgId("HelmRelease", "web", "default", "releases.example.com/v1")
+			Expect(bom.Components).To(ContainElement(HaveField("PackageURL", otherRelease)))
+			Expect(bom.Dependencies).To(ContainElement(model.Dependency{Ref: helmRelease, DependsOn: []string{deployment}}))
+			Expect(bom.Dependencies).ToNot(ContainElement(HaveField("Ref", otherRelease)))
+		})
+
+		It("should read the owners of the Pods from the listed objects instead of getting each ReplicaSet", func() {
+			k8.K8Filter = model.Filter{}
+			cmd.InitializeFilterStruct(&k8.K8Filter)
+
+			_, err := cmd.GenerateBOM(context.Background(), fakeK8sClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			for _, action := range fakeClientset.Actions() {
+				Expect(action.Matches("get", "replicasets")).To(BeFalse())
+			}
+		})
+	})
+
 	Context("when GetAllImages is called with a K8s client", func() {
-		It("should return all the images in the cluster", func() {
+		It("should return one component per image with every place it runs", func() {
 
 			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
 
 			Expect(err).To(BeNil())
-			// ✅ Assert correct number of components (Pods + Deployments + Namespaces)
-			Expect(len(components)).To(Equal(4)) // busybox:latest, busybox:debug,nginx:latest
+			// nginx:latest and busybox:debug, the init containers using busybox:latest have terminated
+			Expect(components).To(HaveLen(2))
 
-			// ✅ Convert list into a map for easy lookup
 			componentMap := make(map[string]model.Component)
 			for _, comp := range components {
-				componentMap[comp.Name+":"+comp.GetNamespace()+":"+comp.Version] = comp
-			}
-
-			// ✅ Assert specific components exist with correct types
-			//Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:default:latest"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:default:debug"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:default:latest"))
-			// ✅ Assert individual component details
-			//Expect(componentMap["index.docker.io/library/busybox:default:latest"].PackageURL).To(Equal("pkg:oci/library/busybox?namespace=default&repository_url=index.docker.io%2Flibrary%2Fbusybox&version=latest"))
-			Expect(componentMap["index.docker.io/library/busybox:default:debug"].PackageURL).To(Equal("pkg:oci/library/busybox?namespace=default&ownerRef=&repository_url=index.docker.io%2Flibrary%2Fbusybox"))
-			Expect(componentMap["index.docker.io/library/nginx:default:latest"].PackageURL).To(Equal("pkg:oci/library/nginx?namespace=default&ownerRef=&repository_url=index.docker.io%2Flibrary%2Fnginx"))
-
-			componentPointer := componentMap["index.docker.io/library/nginx:default:latest"]
-			property, found := componentPointer.GetPropertyObject(model.ComponentNamespace)
-			Expect(found).To(Equal(true))
-			Expect(property).ToNot(BeNil())
-			Expect(len(property.Values)).To(Equal(1))
-			Expect(property.Values).To(ConsistOf("default"))
-
-			// Same image in a different namespace (kube-system in this case) should be added as a separate component to list
-			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:kube-system:debug"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:kube-system:latest"))
-			// ✅ Assert individual component details
-			Expect(componentMap["index.docker.io/library/busybox:kube-system:debug"].PackageURL).To(Equal("pkg:oci/library/busybox?namespace=kube-system&ownerRef=&repository_url=index.docker.io%2Flibrary%2Fbusybox"))
-			Expect(componentMap["index.docker.io/library/nginx:kube-system:latest"].PackageURL).To(Equal("pkg:oci/library/nginx?namespace=kube-system&ownerRef=&repository_url=index.docker.io%2Flibrary%2Fnginx"))
-
-			componentPointer = componentMap["index.docker.io/library/nginx:kube-system:latest"]
-			property, found = componentPointer.GetPropertyObject(model.ComponentNamespace)
-			Expect(found).To(Equal(true))
-			Expect(property).ToNot(BeNil())
-			Expect(len(property.Values)).To(Equal(1))
-			Expect(property.Values).To(ConsistOf("kube-system"))
+				componentMap[comp.Name+":"+comp.Version] = comp
+			}
+			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:latest"))
+			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:debug"))
+
+			// Without a digest the tag identifies the image, wherever it runs
+			nginx := componentMap["index.docker.io/library/nginx:latest"]
+			Expect(nginx.PackageURL).To(Equal("pkg:oci/library/nginx?repository_url=index.docker.io%2Flibrary%2Fnginx&tag=latest"))
+			Expect(nginx.Properties).To(ContainElements(
+				model.Property{Name: model.ComponentNamespace, Values: []string{"default", "kube-system"}},
+				model.Property{Name: model.ComponentSourceRef, Values: []string{"main"}},
+			))
+			Expect(nginx.Evidence).ToNot(BeNil())
+			Expect(nginx.Evidence.Occurrences).To(Equal([]model.Occurrence{
+				{Location: "default/Pod/pod-1", Symbol: "main-container", AdditionalContext: "role=main, replicas=1, pods=pod-1"},
+				{Location: "default/Pod/pod-2", Symbol: "main-container", AdditionalContext: "role=main, replicas=1, pods=pod-2"},
+				{Location: "kube-system/Pod/pod-3", Symbol: "main-container", AdditionalContext: "role=main, replicas=1, pods=pod-3"},
+				{Location: "kube-system/Pod/pod-4", Symbol: "main-container", AdditionalContext: "role=main, replicas=1, pods=pod-4"},
+			}))
+
+			busybox := componentMap["index.docker.io/library/busybox:debug"]
+			Expect(busybox.PackageURL).To(Equal("pkg:oci/library/busybox?repository_url=index.docker.io%2Flibrary%2Fbusybox&tag=debug"))
+			Expect(busybox.Evidence.Occurrences).To(HaveLen(4))
+			Expect(busybox.Evidence.Occurrences[0]).To(Equal(model.Occurrence{Location: "default/Pod/pod-1", Symbol: "debug-container", AdditionalContext: "role=ephemeral, replicas=1, pods=pod-1"}))
+		})
+
+		It("should count the Pods of a workload running the same image as replicas", func() {
+			for _, name := range []string{"web-5d8f-abcde", "web-5d8f-fghij"} {
+				pod := &corev1.Pod{
+					ObjectMeta: v1.ObjectMeta{
+						Name:            name,
+						Namespace:       "default",
+						OwnerReferences: []v1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web"}},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:latest"}}},
+					Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+						Name:    "web",
+						ImageID: "docker.io/library/nginx@sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a",
+						State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					}}},
+				}
+				Expect(fakeClientset.Tracker().Add(pod)).To(Succeed())
+			}
+
+			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
+
+			Expect(err).ToNot(HaveOccurred())
+			var pinned []model.Component
+			for _, component := range components {
+				if len(component.Hashes) > 0 {
+					pinned = append(pinned, component)
+				}
+			}
+			Expect(pinned).To(HaveLen(1))
+			Expect(pinned[0].PackageURL).To(Equal("pkg:oci/library/nginx@sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a?repository_url=index.docker.io%2Flibrary%2Fnginx"))
+			Expect(pinned[0].Properties).To(ContainElement(model.Property{Name: model.ComponentOwnerRef, Values: []string{"default/StatefulSet/web"}}))
+			Expect(pinned[0].Evidence.Occurrences).To(Equal([]model.Occurrence{
+				{Location: "default/StatefulSet/web", Symbol: "web", AdditionalContext: "role=main, replicas=2, pods=web-5d8f-abcde web-5d8f-fghij"},
+			}))
 		})
 
 		It("when GetAllImages is called when same image exists in same namespace but with different version", func() {
@@ -520,21 +2081,19 @@ var _ = Describe("Kubernetes - Unit", Label("unit"), func() {
 			components, err := fakeK8sClient.GetAllImages(context.Background(), mockNamespaceList)
 
 			Expect(err).To(BeNil())
-			// ✅ Assert correct number of components (Pods + Deployments + Namespaces)
-			Expect(len(components)).To(Equal(5)) // busybox:latest, busybox:debug,nginx:latest
+			// The same image with another tag is another component
+			Expect(components).To(HaveLen(3))
 
-			// ✅ Convert list into a map for easy lookup
 			componentMap := make(map[string]model.Component)
 			for _, comp := range components {
-				componentMap[comp.Name+":"+comp.GetNamespace()+":"+comp.Version] = comp
+				componentMap[comp.Name+":"+comp.Version] = comp
 			}
-
-			// ✅ Assert specific components exist with correct types
-			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:default:debug"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:kube-system:debug"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:default:latest"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:kube-system:latest"))
-			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:default:0.14.0"))
+			Expect(componentMap).To(HaveKey("index.docker.io/library/busybox:debug"))
+			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:latest"))
+			Expect(componentMap).To(HaveKey("index.docker.io/library/nginx:0.14.0"))
+			Expect(componentMap["index.docker.io/library/nginx:0.14.0"].Evidence.Occurrences).To(Equal([]model.Occurrence{
+				{Location: "default/Deployment/test-deployment", Symbol: "test-container", AdditionalContext: "role=main, replicas=1, pods=test-pod"},
+			}))
 
 		})
 	})
@@ -586,3 +2145,86 @@ func toUnstructured(obj runtime.Object, kind, apiVersion string) (*unstructured.
 
 	return &unstructured.Unstructured{Object: unstructuredMap}, nil
 }
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+  - name: dev
+    cluster:
+      server: https://dev.example.com:6443
+  - name: prod
+    cluster:
+      server: https://prod.example.com:6443
+users:
+  - name: admin
+    user:
+      token: abc
+contexts:
+  - name: dev
+    context:
+      cluster: dev
+      user: admin
+  - name: prod
+    context:
+      cluster: prod
+      user: admin
+`
+
+var _ = Describe("GetRestConfig", Label("unit"), func() {
+	var kubeconfigPath string
+
+	BeforeEach(func() {
+		kubeconfigPath = GinkgoT().TempDir() + "/config"
+		Expect(os.WriteFile(kubeconfigPath, []byte(testKubeconfig), 0o600)).To(Succeed())
+	})
+
+	It("should use the current context of the kubeconfig by default", func() {
+		config, contextName, err := k8.GetRestConfig(k8.ClientOptions{Kubeconfig: kubeconfigPath})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(contextName).To(Equal("dev"))
+		Expect(config.Host).To(Equal("https://dev.example.com:6443"))
+	})
+
+	It("should use the context, impersonation and timeout from the options", func() {
+		config, contextName, err := k8.GetRestConfig(k8.ClientOptions{
+			Kubeconfig:        kubeconfigPath,
+			Context:           "prod",
+			Impersonate:       "jane",
+			ImpersonateGroups: []string{"auditors", "viewers"},
+			RequestTimeout:    "45s",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(contextName).To(Equal("prod"))
+		Expect(config.Host).To(Equal("https://prod.example.com:6443"))
+		Expect(config.Impersonate.UserName).To(Equal("jane"))
+		Expect(config.Impersonate.Groups).To(Equal([]string{"auditors", "viewers"}))
+		Expect(config.Timeout).To(Equal(45 * time.Second))
+	})
+
+	It("should return an error instead of exiting when the context does not exist", func() {
+		_, _, err := k8.GetRestConfig(k8.ClientOptions{Kubeconfig: kubeconfigPath, Context: "staging"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error when the kubeconfig does not exist", func() {
+		_, _, err := k8.GetRestConfig(k8.ClientOptions{Kubeconfig: kubeconfigPath + ".missing"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error for an invalid request timeout", func() {
+		_, _, err := k8.GetRestConfig(k8.ClientOptions{Kubeconfig: kubeconfigPath, RequestTimeout: "soon"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error for in-cluster config outside of a cluster", func() {
+		_, _, err := k8.GetRestConfig(k8.ClientOptions{InCluster: true})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should list all the contexts in the kubeconfig", func() {
+		contexts, err := k8.GetContexts(k8.ClientOptions{Kubeconfig: kubeconfigPath})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(contexts).To(Equal([]string{"dev", "prod"}))
+	})
+})
@@ -38,6 +38,19 @@ type FakeK8sClientInterface struct {
 		result1 []model.Component
 		result2 error
 	}
+	GetClusterInfoStub        func(context.Context) (k8.ClusterInfo, error)
+	getClusterInfoMutex       sync.RWMutex
+	getClusterInfoArgsForCall []struct {
+		arg1 context.Context
+	}
+	getClusterInfoReturns struct {
+		result1 k8.ClusterInfo
+		result2 error
+	}
+	getClusterInfoReturnsOnCall map[int]struct {
+		result1 k8.ClusterInfo
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -179,6 +192,70 @@ func (fake *FakeK8sClientInterface) GetAllImagesReturnsOnCall(i int, result1 []m
 	}{result1, result2}
 }
 
+func (fake *FakeK8sClientInterface) GetClusterInfo(arg1 context.Context) (k8.ClusterInfo, error) {
+	fake.getClusterInfoMutex.Lock()
+	ret, specificReturn := fake.getClusterInfoReturnsOnCall[len(fake.getClusterInfoArgsForCall)]
+	fake.getClusterInfoArgsForCall = append(fake.getClusterInfoArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.GetClusterInfoStub
+	fakeReturns := fake.getClusterInfoReturns
+	fake.recordInvocation("GetClusterInfo", []interface{}{arg1})
+	fake.getClusterInfoMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeK8sClientInterface) GetClusterInfoCallCount() int {
+	fake.getClusterInfoMutex.RLock()
+	defer fake.getClusterInfoMutex.RUnlock()
+	return len(fake.getClusterInfoArgsForCall)
+}
+
+func (fake *FakeK8sClientInterface) GetClusterInfoCalls(stub func(context.Context) (k8.ClusterInfo, error)) {
+	fake.getClusterInfoMutex.Lock()
+	defer fake.getClusterInfoMutex.Unlock()
+	fake.GetClusterInfoStub = stub
+}
+
+func (fake *FakeK8sClientInterface) GetClusterInfoArgsForCall(i int) context.Context {
+	fake.getClusterInfoMutex.RLock()
+	defer fake.getClusterInfoMutex.RUnlock()
+	argsForCall := fake.getClusterInfoArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeK8sClientInterface) GetClusterInfoReturns(result1 k8.ClusterInfo, result2 error) {
+	fake.getClusterInfoMutex.Lock()
+	defer fake.getClusterInfoMutex.Unlock()
+	fake.GetClusterInfoStub = nil
+	fake.getClusterInfoReturns = struct {
+		result1 k8.ClusterInfo
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeK8sClientInterface) GetClusterInfoReturnsOnCall(i int, result1 k8.ClusterInfo, result2 error) {
+	fake.getClusterInfoMutex.Lock()
+	defer fake.getClusterInfoMutex.Unlock()
+	fake.GetClusterInfoStub = nil
+	if fake.getClusterInfoReturnsOnCall == nil {
+		fake.getClusterInfoReturnsOnCall = make(map[int]struct {
+			result1 k8.ClusterInfo
+			result2 error
+		})
+	}
+	fake.getClusterInfoReturnsOnCall[i] = struct {
+		result1 k8.ClusterInfo
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeK8sClientInterface) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -186,6 +263,8 @@ func (fake *FakeK8sClientInterface) Invocations() map[string][][]interface{} {
 	defer fake.getAllComponentsMutex.RUnlock()
 	fake.getAllImagesMutex.RLock()
 	defer fake.getAllImagesMutex.RUnlock()
+	fake.getClusterInfoMutex.RLock()
+	defer fake.getClusterInfoMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
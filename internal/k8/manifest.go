@@ -0,0 +1,236 @@
+package k8
+
+import (
+	"bytes"
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/registry"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/fs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of the files read from a manifest directory. Anything else, like the logs in a cluster-info dump, is skipped.
+var manifestExtensions = map[string]struct{}{
+	".yaml": {},
+	".yml":  {},
+	".json": {},
+}
+
+// ManifestClient is an offline implementation of the K8sClientInterface that reads the objects from manifest files
+// instead of a live cluster. It supports multi-document YAML and JSON, `kubectl get -o json` List output and the
+// directory written by `kubectl cluster-info dump --output-directory`.
+type ManifestClient struct {
+	Path string
+	// DigestResolver looks up the digests missing from the Pod statuses in the registry. Nil disables the lookups.
+	DigestResolver *registry.Resolver
+	objects        []unstructured.Unstructured
+	owners         *ownerGraph            // The owner references of the objects, set by GetAllComponents
+	ruleImages     []customResourceImages // The images the image rules found, set by GetAllComponents
+	// HelmDriver is the storage the Helm releases are read from, one of the HelmDrivers. Empty means HelmDriverSecret.
+	HelmDriver string
+}
+
+// NewManifestClient reads all the objects from the given file, directory or "-" for stdin
+func NewManifestClient(path string) (*ManifestClient, error) {
+	client := &ManifestClient{Path: path}
+	seen := make(map[string]struct{})
+
+	add := func(source string, reader io.Reader) error {
+		objects, err := decodeManifests(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", source, err)
+		}
+		for _, obj := range objects {
+			// The same object can appear in more than one file, e.g. a Pod in both a List and a dump
+			key := fmt.Sprintf("%s/%s/%s/%s", obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())
+			if _, exists := seen[key]; exists {
+				log.Debug().Msgf("Skipping duplicate object %s from %s", key, source)
+				continue
+			}
+			seen[key] = struct{}{}
+			client.objects = append(client.objects, obj)
+		}
+		return nil
+	}
+
+	if path == "-" {
+		return client, add("stdin", os.Stdin)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return client, add(path, file)
+	}
+
+	// WalkDir visits the files in lexical order so the objects are always read in the same order
+	err = filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if _, isManifest := manifestExtensions[strings.ToLower(filepath.Ext(filePath))]; !isManifest {
+			log.Debug().Msgf("Skipping non-manifest file: %s", filePath)
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return add(filePath, file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// decodeManifests decodes a stream of YAML documents or JSON objects, expanding any List into its items
+func decodeManifests(reader io.Reader) ([]unstructured.Unstructured, error) {
+	var objects []unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			continue // Empty YAML document
+		}
+
+		// The unstructured scheme sets the kind and apiVersion of the items of typed lists like PodList
+		obj, err := runtime.Decode(unstructured.UnstructuredJSONScheme, raw)
+		if err != nil {
+			log.Warn().Msgf("Skipping document that is not a Kubernetes object: %v", err)
+			continue
+		}
+		switch typed := obj.(type) {
+		case *unstructured.UnstructuredList:
+			objects = append(objects, typed.Items...)
+		case *unstructured.Unstructured:
+			objects = append(objects, *typed)
+		}
+	}
+}
+
+func (m *ManifestClient) GetAllComponents(ctx context.Context) ([]model.Component, []string, error) {
+	var k8sResourceList []model.Component
+	var namespaces []string
+	seenNamespaces := make(map[string]struct{})
+	addNamespace := func(namespace string) {
+		if _, exists := seenNamespaces[namespace]; namespace != "" && !exists {
+			seenNamespaces[namespace] = struct{}{}
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	graph := newOwnerGraph()
+	m.ruleImages = nil
+	nsLabels := namespaceLabelsOf(m.objects)
+	for _, item := range m.objects {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		// Exports often leave out the Namespace objects, so also use the namespaces of the objects themselves
+		if item.GetKind() == "Namespace" {
+			addNamespace(item.GetName())
+		}
+		addNamespace(item.GetNamespace())
+
+		included := shouldIncludeItem(item, guessResourceType(item.GroupVersionKind()), nsLabels)
+		graph.add(item, included)
+		if !included {
+			continue
+		}
+		addToComponentList(item, &k8sResourceList)
+		if found := findRuleImages(item); found != nil {
+			m.ruleImages = append(m.ruleImages, *found)
+		}
+	}
+	addHelmReleases(helmReleasesFromObjects(m.objects, m.HelmDriver), graph, &k8sResourceList, nsLabels)
+	graph.addOwners(k8sResourceList)
+	graph.addGitOpsProvenance(k8sResourceList)
+	m.owners = graph
+	return k8sResourceList, namespaces, nil
+}
+
+func (m *ManifestClient) GetAllImages(ctx context.Context, namespaceList []string) ([]model.Component, error) {
+	images := newImageCollector(m.getOwnerReferences, m.owners)
+	var pods []*corev1.Pod
+	var templates []workloadTemplate
+
+	nsLabels := namespaceLabelsOf(m.objects)
+	namespaceList = imageNamespaces(namespaceList, nsLabels)
+	for _, namespace := range namespaceList {
+		for _, item := range m.objects {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if item.GetNamespace() != namespace {
+				continue
+			}
+			if item.GetKind() != "Pod" || item.GroupVersionKind().Group != "" {
+				if template, isWorkload := newWorkloadTemplate(item); isWorkload {
+					templates = append(templates, template)
+				}
+				continue
+			}
+
+			var pod corev1.Pod
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pod); err != nil {
+				log.Err(err).Msgf("Could not read pod %s/%s", item.GetNamespace(), item.GetName())
+				continue
+			}
+			pods = append(pods, &pod)
+		}
+	}
+
+	pods, templates = selectImageSources(pods, templates, nsLabels)
+	ruleImages := inNamespaces(m.ruleImages, namespaceList)
+	images.resolveDigests(ctx, m.DigestResolver, append(specImages(append(podSpecs(pods), templateSpecs(templates)...)), ruleImageReferences(ruleImages)...))
+	for _, pod := range pods {
+		images.addPod(ctx, pod)
+	}
+	// The pod templates are used as well, e.g. when only Deployments were exported or for CronJobs between runs
+	images.addTemplates(ctx, templates)
+	images.addRuleImages(ruleImages)
+	return images.components(), nil
+}
+
+// getOwnerReferences returns the owner references of the named object from the manifests
+func (m *ManifestClient) getOwnerReferences(ctx context.Context, groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error) {
+	for _, item := range m.objects {
+		if item.GroupVersionKind().GroupKind() == groupKind && item.GetNamespace() == namespace && item.GetName() == name {
+			return item.GetOwnerReferences(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s %s/%s not found in manifests", groupKind, namespace, name)
+}
@@ -0,0 +1,667 @@
+package k8_test
+
+import (
+	"cluster-codex/cmd"
+	"cluster-codex/internal/k8"
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/registry"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const multiDocumentManifest = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+  labels:
+    helm.sh/chart: web-1.2.3
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.27
+---
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: web-5d8f
+  namespace: shop
+  ownerReferences:
+    - apiVersion: apps/v1
+      kind: Deployment
+      name: web
+      uid: "1"
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.27
+---
+# An empty document
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+  namespace: shop
+spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: cleanup
+              image: busybox:1.36
+`
+
+// The items of a typed list in a cluster-info dump have no kind or apiVersion
+const clusterInfoDumpPods = `{
+  "kind": "PodList",
+  "apiVersion": "v1",
+  "items": [
+    {
+      "metadata": {
+        "name": "web-5d8f-abcde",
+        "namespace": "shop",
+        "ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-5d8f", "uid": "2"}]
+      },
+      "spec": {"containers": [{"name": "web", "image": "nginx:1.27"}]},
+      "status": {
+        "containerStatuses": [{
+          "name": "web",
+          "image": "nginx:1.27",
+          "imageID": "docker.io/library/nginx@sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a",
+          "ready": true,
+          "restartCount": 0,
+          "state": {"running": {}}
+        }]
+      }
+    }
+  ]
+}`
+
+const kubectlListOutput = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings", "namespace": "shop"}},
+    {"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv-1"}}
+  ]
+}`
+
+var _ = Describe("ManifestClient - Unit", Label("unit"), func() {
+	var dir string
+
+	writeFile := func(name string, content string) {
+		path := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		writeFile("workloads.yaml", multiDocumentManifest)
+		writeFile("list.json", kubectlListOutput)
+		writeFile("shop/pods.json", clusterInfoDumpPods)
+		writeFile("shop/web-5d8f-abcde/logs.txt", "this is not a manifest")
+
+		k8.K8Filter = model.Filter{}
+		cmd.InitializeFilterStruct(&k8.K8Filter)
+	})
+
+	It("should read every object from a directory of manifests and lists", func() {
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, namespaces, err := client.GetAllComponents(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(namespaces).To(Equal([]string{"shop"}))
+		componentMap := make(map[string]model.Component)
+		for _, comp := range components {
+			componentMap[comp.GetKind()+"/"+comp.Name] = comp
+		}
+		Expect(componentMap).To(HaveLen(7))
+		Expect(componentMap).To(HaveKey("Namespace/shop"))
+		Expect(componentMap).To(HaveKey("ConfigMap/settings"))
+		Expect(componentMap).To(HaveKey("PersistentVolume/pv-1"))
+		Expect(componentMap).To(HaveKey("Pod/web-5d8f-abcde"))
+		Expect(componentMap["Pod/web-5d8f-abcde"].PackageURL).To(Equal("pkg:k8s/Pod/web-5d8f-abcde?apiVersion=v1&namespace=shop"))
+		Expect(componentMap["Deployment/web"].Properties).To(ContainElement(
+			model.Property{Name: model.ComponentVersion, Values: []string{"web-1.2.3"}},
+		))
+	})
+
+	It("should apply the filter to the objects in the manifests", func() {
+		k8.K8Filter = model.Filter{
+			NamespacedInclusions:    []model.NamespacedInclusion{{Namespaces: []string{"shop"}, Resources: []string{"Deployment"}}},
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Namespace"}},
+		}
+		cmd.InitializeFilterStruct(&k8.K8Filter)
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, _, err := client.GetAllComponents(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(components).To(HaveLen(2))
+	})
+
+	It("should apply the label and field selectors of the filter to the objects and images in the manifests", func() {
+		k8.K8Filter = model.Filter{
+			NamespacedInclusions:    []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "helm.sh/chart"}}},
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Namespace"}, Selectors: model.Selectors{FieldSelector: "metadata.name=shop"}},
+		}
+		cmd.InitializeFilterStruct(&k8.K8Filter)
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, _, err := client.GetAllComponents(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		kinds := make([]string, 0, len(components))
+		for _, component := range components {
+			kinds = append(kinds, component.GetKind()+"/"+component.Name)
+		}
+		Expect(kinds).To(ConsistOf("Namespace/shop", "Deployment/web"))
+
+		// Only the template of the labelled Deployment is left, the same as a cluster would list
+		images, err := client.GetAllImages(context.Background(), []string{"shop"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].Evidence.Occurrences).To(Equal([]model.Occurrence{
+			{Location: "shop/Deployment/web", Symbol: "web", AdditionalContext: "role=main, replicas=1"},
+		}))
+	})
+
+	It("should take images from Pods and from the pod templates of workloads", func() {
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		images, err := client.GetAllImages(context.Background(), []string{"shop"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(HaveLen(2))
+		imageMap := make(map[string]model.Component)
+		for _, image := range images {
+			imageMap[image.Name] = image
+		}
+
+		// The running Pod is attributed to the Deployment through the ReplicaSet in the manifests, and the templates of
+		// both declare the image the Pod runs
+		nginx := imageMap["index.docker.io/library/nginx"]
+		Expect(nginx.Version).To(Equal("1.27"))
+		Expect(nginx.PackageURL).To(Equal("pkg:oci/library/nginx@sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a?repository_url=index.docker.io%2Flibrary%2Fnginx"))
+		Expect(nginx.Evidence.Occurrences).To(Equal([]model.Occurrence{
+			{Location: "shop/Deployment/web", Symbol: "web", AdditionalContext: "role=main, replicas=1, pods=web-5d8f-abcde"},
+		}))
+		Expect(nginx.Properties).To(ContainElement(model.Property{Name: model.ImageState, Values: []string{k8.ImageStateRunning}}))
+
+		// The CronJob has no Pods so its image comes from the job template
+		busybox := imageMap["index.docker.io/library/busybox"]
+		Expect(busybox.Version).To(Equal("1.36"))
+		ownerRef, found := busybox.GetProperty(model.ComponentOwnerRef)
+		Expect(found).To(BeTrue())
+		Expect(ownerRef).To(Equal("shop/CronJob/cleanup"))
+		Expect(busybox.Evidence.Occurrences).To(Equal([]model.Occurrence{
+			{Location: "shop/CronJob/cleanup", Symbol: "cleanup", AdditionalContext: "role=main, replicas=0"},
+		}))
+		Expect(busybox.Properties).To(ContainElement(model.Property{Name: model.ImageState, Values: []string{k8.ImageStateDeclared}}))
+	})
+
+	It("should link the objects in the manifests to their owners", func() {
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, _, err := client.GetAllComponents(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		images, err := client.GetAllImages(context.Background(), []string{"shop"})
+		Expect(err).ToNot(HaveOccurred())
+
+		bom := &model.BOM{Components: append(components, images...)}
+		bom.AddOwnerDependencies()
+
+		deployment := k8.GetAppPkgId("Deployment", "web", "shop", "apps/v1")
+		replicaSet := k8.GetAppPkgId("ReplicaSet", "web-5d8f", "shop", "apps/v1")
+		pod := k8.GetAppPkgId("Pod", "web-5d8f-abcde", "shop", "v1")
+		cronJob := k8.GetAppPkgId("CronJob", "cleanup", "shop", "batch/v1")
+		imageRefs := make(map[string]string)
+		for _, image := range images {
+			imageRefs[image.Name] = image.PackageURL
+		}
+		// The workloads also depend on the images declared by their pod templates
+		Expect(bom.Dependencies).To(ContainElements(
+			model.Dependency{Ref: deployment, DependsOn: []string{replicaSet, imageRefs["index.docker.io/library/nginx"]}},
+			model.Dependency{Ref: replicaSet, DependsOn: []string{pod, imageRefs["index.docker.io/library/nginx"]}},
+			model.Dependency{Ref: pod, DependsOn: []string{imageRefs["index.docker.io/library/nginx"]}},
+			// The image from the template of the CronJob is owned by the CronJob itself
+			model.Dependency{Ref: cronJob, DependsOn: []string{imageRefs["index.docker.io/library/busybox"]}},
+		))
+	})
+
+	It("should read a single multi-document file", func() {
+		client, err := k8.NewManifestClient(filepath.Join(dir, "workloads.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		components, namespaces, err := client.GetAllComponents(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(components).To(HaveLen(4))
+		Expect(namespaces).To(Equal([]string{"shop"}))
+
+		// Without the Pods, the images come from the Deployment and CronJob templates
+		images, err := client.GetAllImages(context.Background(), namespaces)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(HaveLen(2))
+	})
+
+	It("should resolve the digests of pod template images from the registry", func() {
+		registryServer := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+		DeferCleanup(registryServer.Close)
+		registryHost := strings.TrimPrefix(registryServer.URL, "http://")
+		img, err := random.Image(256, 1)
+		Expect(err).ToNot(HaveOccurred())
+		ref, err := name.ParseReference(registryHost + "/shop/api:2.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remote.Write(ref, img)).To(Succeed())
+		digest, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		manifestPath := filepath.Join(dir, "api.yaml")
+		Expect(os.WriteFile(manifestPath, []byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: shop
+spec:
+  template:
+    spec:
+      containers:
+        - name: api
+          image: `+registryHost+`/shop/api:2.0
+`), 0o644)).To(Succeed())
+		client, err := k8.NewManifestClient(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+		client.DigestResolver, err = registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+
+		images, err := client.GetAllImages(context.Background(), []string{"shop"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].PackageURL).To(HavePrefix("pkg:oci/shop/api@" + digest.String() + "?"))
+		Expect(images[0].Hashes).To(Equal([]model.Hash{{Algorithm: "SHA-256", Value: digest.Hex}}))
+	})
+
+	It("should take images from custom resources matching the image rules", func() {
+		Expect(k8.SetImageRules(model.ImageRules{Rules: []model.ImageRule{{
+			Group:   "tekton.dev",
+			Version: "v1beta1",
+			Kind:    "ClusterTask",
+			Paths:   []string{"{.spec.steps[*].image}"},
+		}}})).To(Succeed())
+		DeferCleanup(func() { Expect(k8.SetImageRules(model.ImageRules{})).To(Succeed()) })
+		manifestPath := filepath.Join(dir, "tasks.yaml")
+		Expect(os.WriteFile(manifestPath, []byte(`
+apiVersion: tekton.dev/v1beta1
+kind: ClusterTask
+metadata:
+  name: git-clone
+spec:
+  steps:
+    - name: clone
+      image: gcr.io/tekton-releases/git-init:v0.40.2
+`), 0o644)).To(Succeed())
+		client, err := k8.NewManifestClient(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = client.GetAllComponents(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		images, err := client.GetAllImages(context.Background(), nil)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].Name).To(Equal("gcr.io/tekton-releases/git-init"))
+		Expect(images[0].GetNamespace()).To(BeEmpty())
+		Expect(images[0].Properties).To(ContainElement(model.Property{Name: model.ComponentOwnerRef, Values: []string{"ClusterTask/git-clone"}}))
+		Expect(images[0].Evidence.Occurrences).To(Equal([]model.Occurrence{
+			{Location: "ClusterTask/git-clone", Symbol: "{.spec.steps[*].image}", AdditionalContext: "role=rule, replicas=0"},
+		}))
+	})
+
+	It("should apply the image rules to several manifests at once, as for contexts generated in parallel", func() {
+		// Evaluating a range changes the state of the parsed expression
+		Expect(k8.SetImageRules(model.ImageRules{Rules: []model.ImageRule{{
+			Group: "tekton.dev",
+			Kind:  "ClusterTask",
+			Paths: []string{"{range .spec.steps[*]}{.image}{end}"},
+		}}})).To(Succeed())
+		DeferCleanup(func() { Expect(k8.SetImageRules(model.ImageRules{})).To(Succeed()) })
+		var clients []*k8.ManifestClient
+		for idx := 0; idx < 4; idx++ {
+			manifestPath := filepath.Join(dir, fmt.Sprintf("tasks-%d.yaml", idx))
+			Expect(os.WriteFile(manifestPath, []byte(fmt.Sprintf(`
+apiVersion: tekton.dev/v1beta1
+kind: ClusterTask
+metadata:
+  name: git-clone
+spec:
+  steps:
+    - name: clone
+      image: gcr.io/tekton-releases/git-init:v0.40.%d
+`, idx)), 0o644)).To(Succeed())
+			client, err := k8.NewManifestClient(manifestPath)
+			Expect(err).ToNot(HaveOccurred())
+			clients = append(clients, client)
+		}
+
+		versions := make([]string, len(clients))
+		var wg sync.WaitGroup
+		for idx, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, _, err := client.GetAllComponents(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				images, err := client.GetAllImages(context.Background(), nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(images).To(HaveLen(1))
+				versions[idx] = images[0].Version
+			}()
+		}
+		wg.Wait()
+
+		Expect(versions).To(Equal([]string{"v0.40.0", "v0.40.1", "v0.40.2", "v0.40.3"}))
+	})
+
+	It("should apply the version rules to several manifests at once, as for contexts generated in parallel", func() {
+		Expect(k8.SetVersionRules(model.VersionRules{Rules: []model.VersionRule{{
+			Group: "tekton.dev",
+			Kind:  "ClusterTask",
+			Path:  "{range .spec.params[?(@.name==\"version\")]}{.default}{end}",
+		}}})).To(Succeed())
+		DeferCleanup(func() { Expect(k8.SetVersionRules(model.VersionRules{})).To(Succeed()) })
+		var clients []*k8.ManifestClient
+		for idx := 0; idx < 4; idx++ {
+			manifestPath := filepath.Join(dir, fmt.Sprintf("tasks-%d.yaml", idx))
+			Expect(os.WriteFile(manifestPath, []byte(fmt.Sprintf(`
+apiVersion: tekton.dev/v1beta1
+kind: ClusterTask
+metadata:
+  name: git-clone
+spec:
+  params:
+    - name: version
+      default: 0.9.%d
+`, idx)), 0o644)).To(Succeed())
+			client, err := k8.NewManifestClient(manifestPath)
+			Expect(err).ToNot(HaveOccurred())
+			clients = append(clients, client)
+		}
+
+		versions := make([]string, len(clients))
+		var wg sync.WaitGroup
+		for idx, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				components, _, err := client.GetAllComponents(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				for _, component := range components {
+					if component.Name == "git-clone" {
+						versions[idx], _ = component.GetProperty(model.ComponentVersion)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		Expect(versions).To(Equal([]string{"0.9.0", "0.9.1", "0.9.2", "0.9.3"}))
+	})
+
+	It("should decode the Helm releases from the Secrets in the manifests and link them to the objects they rendered", func() {
+		manifestPath := filepath.Join(dir, "release.yaml")
+		Expect(os.WriteFile(manifestPath, []byte(`
+apiVersion: v1
+kind: Secret
+type: helm.sh/release.v1
+metadata:
+  name: sh.helm.release.v1.web.v4
+  namespace: shop
+  labels:
+    owner: helm
+    name: web
+    status: deployed
+    version: "4"
+data:
+  release: `+base64.StdEncoding.EncodeToString([]byte(helmReleaseData("web", "shop", 4, "deployed")))+`
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+  annotations:
+    meta.helm.sh/release-name: web
+    meta.helm.sh/release-namespace: shop
+`), 0o644)).To(Succeed())
+		client, err := k8.NewManifestClient(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		bom, err := cmd.GenerateBOM(context.Background(), client)
+
+		Expect(err).ToNot(HaveOccurred())
+		releases := bom.FindApplicationsByKind(k8.HelmReleaseKind, "shop")
+		Expect(releases).To(HaveLen(1))
+		Expect(releases[0].Properties).To(ContainElements(
+			model.Property{Name: model.HelmChartVersion, Values: []string{"1.4.0"}},
+			model.Property{Name: model.HelmRevision, Values: []string{"4"}},
+		))
+		Expect(bom.Dependencies).To(ContainElement(model.Dependency{
+			Ref:       releases[0].BOMRef,
+			DependsOn: []string{k8.GetAppPkgId("Deployment", "web", "shop", "apps/v1")},
+		}))
+	})
+
+	It("should record the GitOps provenance of the Flux and Argo CD objects and of the objects they applied", func() {
+		manifestPath := filepath.Join(dir, "gitops.yaml")
+		Expect(os.WriteFile(manifestPath, []byte(`
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: podinfo
+  namespace: flux-system
+spec:
+  url: https://github.com/stefanprodan/podinfo
+status:
+  artifact:
+    revision: master@sha1:450796ddb2ab6724ee1cc32a4be56da032d1cca0
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: apps
+  namespace: flux-system
+spec:
+  sourceRef:
+    kind: GitRepository
+    name: podinfo
+status:
+  lastAppliedRevision: master@sha1:450796ddb2ab6724ee1cc32a4be56da032d1cca0
+  conditions:
+    - type: Ready
+      status: "True"
+      reason: ReconciliationSucceeded
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: shop
+  labels:
+    kustomize.toolkit.fluxcd.io/name: apps
+    kustomize.toolkit.fluxcd.io/namespace: flux-system
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: guestbook
+  namespace: argocd
+spec:
+  source:
+    repoURL: https://github.com/argoproj/argocd-example-apps.git
+    path: guestbook
+status:
+  sync:
+    status: Synced
+    revision: 53e28ff20cc530b9ada2173fbbd64d48338583ba
+  health:
+    status: Healthy
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: guestbook-ui
+  namespace: shop
+  annotations:
+    argocd.argoproj.io/tracking-id: guestbook:/Service:shop/guestbook-ui
+`), 0o644)).To(Succeed())
+		client, err := k8.NewManifestClient(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, _, err := client.GetAllComponents(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		byName := make(map[string]model.Component)
+		for _, component := range components {
+			byName[component.GetKind()+"/"+component.Name] = component
+		}
+		fluxRevision := "master@sha1:450796ddb2ab6724ee1cc32a4be56da032d1cca0"
+		Expect(byName["Kustomization/apps"].Properties).To(ContainElements(
+			model.Property{Name: model.GitOpsTool, Values: []string{k8.GitOpsFlux}},
+			model.Property{Name: model.GitOpsRevision, Values: []string{fluxRevision}},
+			model.Property{Name: model.GitOpsSyncStatus, Values: []string{"ReconciliationSucceeded"}},
+			model.Property{Name: model.GitOpsHealthStatus, Values: []string{"Healthy"}},
+			model.Property{Name: model.GitOpsSourceURL, Values: []string{"https://github.com/stefanprodan/podinfo"}},
+		))
+		Expect(byName["Deployment/podinfo"].Properties).To(ContainElements(
+			model.Property{Name: model.GitOpsManagedBy, Values: []string{"Kustomization/flux-system/apps"}},
+			model.Property{Name: model.GitOpsRevision, Values: []string{fluxRevision}},
+		))
+		Expect(byName["Deployment/podinfo"].ExternalReferences).To(Equal([]model.ExternalReference{
+			{URL: "https://github.com/stefanprodan/podinfo", Type: "vcs", Comment: "revision " + fluxRevision},
+		}))
+		Expect(byName["Application/guestbook"].Properties).To(ContainElements(
+			model.Property{Name: model.GitOpsTool, Values: []string{k8.GitOpsArgoCD}},
+			model.Property{Name: model.GitOpsSyncStatus, Values: []string{"Synced"}},
+			model.Property{Name: model.GitOpsHealthStatus, Values: []string{"Healthy"}},
+		))
+		Expect(byName["Service/guestbook-ui"].Properties).To(ContainElements(
+			model.Property{Name: model.GitOpsManagedBy, Values: []string{"Application/guestbook"}},
+			model.Property{Name: model.GitOpsRevision, Values: []string{"53e28ff20cc530b9ada2173fbbd64d48338583ba"}},
+			model.Property{Name: model.GitOpsSourceURL, Values: []string{"https://github.com/argoproj/argocd-example-apps.git"}},
+		))
+	})
+
+	It("should trace the objects Argo CD tracks by label to their Application", func() {
+		manifestPath := filepath.Join(dir, "argocd.yaml")
+		Expect(os.WriteFile(manifestPath, []byte(`
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: guestbook
+  namespace: argocd
+spec:
+  source:
+    repoURL: https://github.com/argoproj/argocd-example-apps.git
+    path: guestbook
+status:
+  sync:
+    status: Synced
+    revision: 53e28ff20cc530b9ada2173fbbd64d48338583ba
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: guestbook-ui
+  namespace: shop
+  labels:
+    app.kubernetes.io/instance: guestbook
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: redis
+  namespace: shop
+  labels:
+    app.kubernetes.io/instance: redis
+`), 0o644)).To(Succeed())
+		client, err := k8.NewManifestClient(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		components, _, err := client.GetAllComponents(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		byName := make(map[string]model.Component)
+		for _, component := range components {
+			byName[component.GetKind()+"/"+component.Name] = component
+		}
+		Expect(byName["Service/guestbook-ui"].Properties).To(ContainElements(
+			model.Property{Name: model.GitOpsManagedBy, Values: []string{"Application/guestbook"}},
+			model.Property{Name: model.GitOpsRevision, Values: []string{"53e28ff20cc530b9ada2173fbbd64d48338583ba"}},
+			model.Property{Name: model.GitOpsSourceURL, Values: []string{"https://github.com/argoproj/argocd-example-apps.git"}},
+		))
+		// A Helm release sets the same label, and no Application of that name exists
+		Expect(byName["Service/redis"].Properties).ToNot(ContainElement(HaveField("Name", model.GitOpsManagedBy)))
+	})
+
+	It("should read the cluster UID and provider from the Namespaces and Nodes in the manifests", func() {
+		writeFile("cluster/nodes.json", `{
+  "kind": "List",
+  "apiVersion": "v1",
+  "items": [
+    {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "kube-system", "uid": "6f1d3c2a-9b8e-4c7d-a5f4-3e2d1c0b9a87"}},
+    {"apiVersion": "v1", "kind": "Node", "metadata": {"name": "kind-control-plane"}, "spec": {"providerID": "kind://docker/kind/kind-control-plane"}}
+  ]
+}`)
+		client, err := k8.NewManifestClient(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := client.GetClusterInfo(context.Background())
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info).To(Equal(k8.ClusterInfo{UID: "6f1d3c2a-9b8e-4c7d-a5f4-3e2d1c0b9a87", Provider: k8.ProviderKind}))
+	})
+
+	It("should return an error when the path does not exist", func() {
+		_, err := k8.NewManifestClient(filepath.Join(dir, "missing"))
+		Expect(err).To(HaveOccurred())
+	})
+})
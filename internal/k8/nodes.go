@@ -0,0 +1,125 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/version"
+	"strings"
+)
+
+// The kubelet may be up to maxKubeletSkew minor versions older than the API server, but never newer.
+// See https://kubernetes.io/releases/version-skew-policy/#kubelet
+const maxKubeletSkew = 3
+
+// Values of the version skew property of a node
+const (
+	VersionSkewSupported   = "supported"
+	VersionSkewUnsupported = "unsupported"
+)
+
+// Well-known labels with the instance type of a node, the deprecated beta label is used by older clusters
+var instanceTypeLabels = []string{"node.kubernetes.io/instance-type", "beta.kubernetes.io/instance-type"}
+
+// addNodeInfo turns the node into a platform component with the versions of its kubelet, kube-proxy, container runtime
+// and operating system from its status
+func addNodeInfo(item unstructured.Unstructured, component *model.Component) {
+	component.Type = "platform"
+	nodeInfo, found, err := unstructured.NestedStringMap(item.Object, "status", "nodeInfo")
+	if err != nil || !found {
+		log.Debug().Msgf("Node %s has no node info", item.GetName())
+		return
+	}
+
+	if kubeletVersion := nodeInfo["kubeletVersion"]; kubeletVersion != "" {
+		component.Version = kubeletVersion
+	}
+	for property, key := range map[string]string{
+		model.NodeKubeletVersion:   "kubeletVersion",
+		model.NodeKubeProxyVersion: "kubeProxyVersion",
+		model.NodeOperatingSystem:  "operatingSystem",
+		model.NodeOSImage:          "osImage",
+		model.NodeKernelVersion:    "kernelVersion",
+		model.NodeArchitecture:     "architecture",
+	} {
+		if value := nodeInfo[key]; value != "" {
+			component.AddProperty(property, value)
+		}
+	}
+	// The runtime version is reported as <runtime>://<version>, e.g. containerd://1.7.22
+	if runtimeVersion := nodeInfo["containerRuntimeVersion"]; runtimeVersion != "" {
+		runtime, runtimeVersion, found := strings.Cut(runtimeVersion, "://")
+		component.AddProperty(model.NodeContainerRuntime, runtime)
+		if found {
+			component.AddProperty(model.NodeContainerRuntimeVersion, runtimeVersion)
+		}
+	}
+
+	labels := item.GetLabels()
+	for _, label := range instanceTypeLabels {
+		if instanceType := labels[label]; instanceType != "" {
+			component.AddProperty(model.NodeInstanceType, instanceType)
+			break
+		}
+	}
+}
+
+// addVersionSkew records on each node whether its kubelet version is supported by the version skew policy for the API
+// server version
+func addVersionSkew(components []model.Component, serverVersion string) {
+	if serverVersion == "" {
+		return
+	}
+	apiServer, err := version.ParseGeneric(serverVersion)
+	if err != nil {
+		log.Warn().Msgf("Cannot check the kubelet version skew for API server version %s: %v", serverVersion, err)
+		return
+	}
+	for i := range components {
+		kubeletVersion, found := components[i].GetProperty(model.NodeKubeletVersion)
+		if components[i].GetKind() != "Node" || !found {
+			continue
+		}
+		kubelet, err := version.ParseGeneric(kubeletVersion)
+		if err != nil {
+			log.Warn().Msgf("Cannot check the version skew of node %s with kubelet version %s: %v", components[i].Name, kubeletVersion, err)
+			continue
+		}
+		if problem := kubeletSkewProblem(kubelet, apiServer); problem != "" {
+			log.Warn().Msgf("Node %s: %s", components[i].Name, problem)
+			components[i].AddProperty(model.NodeVersionSkew, VersionSkewUnsupported)
+		} else {
+			components[i].AddProperty(model.NodeVersionSkew, VersionSkewSupported)
+		}
+	}
+}
+
+// kubeletSkewProblem describes why the kubelet version is not supported with the API server version, or returns ""
+func kubeletSkewProblem(kubelet *version.Version, apiServer *version.Version) string {
+	if kubelet.Major() != apiServer.Major() {
+		return fmt.Sprintf("kubelet %s and API server %s have different major versions", kubelet, apiServer)
+	}
+	if kubelet.Minor() > apiServer.Minor() {
+		return fmt.Sprintf("kubelet %s is newer than API server %s", kubelet, apiServer)
+	}
+	if skew := apiServer.Minor() - kubelet.Minor(); skew > maxKubeletSkew {
+		return fmt.Sprintf("kubelet %s is %d minor versions older than API server %s, at most %d are supported", kubelet, skew, apiServer, maxKubeletSkew)
+	}
+	return ""
+}
+
+// serverVersion returns the git version of the API server, e.g. v1.31.2, or "" when it cannot be read. The version read
+// by GetClusterInfo is reused, so that a BOM asks the API server for it once.
+func (c *K8sClient) serverVersion() string {
+	if c.gitVersion != "" {
+		return c.gitVersion
+	}
+	info, err := c.Discovery.ServerVersion()
+	if err != nil {
+		log.Warn().Msgf("Could not get the API server version: %v", err)
+		return ""
+	}
+	c.gitVersion = info.GitVersion
+	return c.gitVersion
+}
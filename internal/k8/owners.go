@@ -0,0 +1,160 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"slices"
+	"sync"
+)
+
+// ownerGraph indexes the owner references of every listed object, including the objects excluded by the filter, so
+// the ownership chain of a component can be followed through them to its nearest owners in the BOM. It also answers
+// the owner lookups of the image collector without a request per Pod, and links objects to the GitOps objects that
+// applied them.
+type ownerGraph struct {
+	lock         sync.Mutex
+	objects      map[string]*ownedObject  // Keyed by ownerKey
+	applications map[string]*gitOpsSource // The Argo CD Applications by name
+}
+
+// ownedObject is a single object in the ownerGraph
+type ownedObject struct {
+	ref      string // The package URL of the object, which is its bom-ref when it is included
+	owners   []metav1.OwnerReference
+	release  string        // The ownerKey of the Helm release that rendered the object, if any
+	gitOps   *gitOpsSource // The provenance of a GitOps object
+	manager  *objectRef    // The GitOps object that applied the object, if any
+	included bool
+}
+
+func newOwnerGraph() *ownerGraph {
+	return &ownerGraph{objects: make(map[string]*ownedObject), applications: make(map[string]*gitOpsSource)}
+}
+
+// add records the object and whether it is included in the BOM. It is safe to call from several workers.
+func (g *ownerGraph) add(item unstructured.Unstructured, included bool) {
+	obj := &ownedObject{
+		ref:      GetAppPkgId(item.GetKind(), item.GetName(), item.GetNamespace(), item.GetAPIVersion()),
+		owners:   item.GetOwnerReferences(),
+		release:  helmReleaseKey(item),
+		gitOps:   gitOpsInfo(item),
+		included: included,
+	}
+	if manager, managed := gitOpsManager(item); managed {
+		obj.manager = &manager
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.objects[ownerKey(item.GetNamespace(), item.GroupVersionKind().GroupKind(), item.GetName())] = obj
+	if obj.gitOps != nil && obj.gitOps.tool == GitOpsArgoCD {
+		g.applications[item.GetName()] = obj.gitOps
+	}
+}
+
+// owner returns the object an owner reference points to. The owner is in the namespace of the object it owns, or is
+// cluster-scoped.
+func (g *ownerGraph) owner(namespace string, ownerRef metav1.OwnerReference) (*ownedObject, string, bool) {
+	for _, ownerNamespace := range []string{namespace, ""} {
+		if obj, found := g.objects[ownerKey(ownerNamespace, ownerGroupKind(ownerRef), ownerRef.Name)]; found {
+			return obj, ownerNamespace, true
+		}
+	}
+	return nil, "", false
+}
+
+// includedOwners returns the bom-refs of the nearest owners that are included in the BOM, climbing through the owners
+// that are not. The Helm release that rendered an object counts as one of its owners. Owners that were not listed,
+// e.g. because of RBAC, end the chain.
+func (g *ownerGraph) includedOwners(namespace string, ownerRefs []metav1.OwnerReference, release string) []string {
+	var refs []string
+	visited := make(map[*ownedObject]struct{}) // Owner references can form a cycle
+	var climb func(namespace string, ownerRefs []metav1.OwnerReference, release string)
+	visit := func(obj *ownedObject, namespace string) {
+		if _, seen := visited[obj]; seen {
+			return
+		}
+		visited[obj] = struct{}{}
+		if obj.included {
+			refs = append(refs, obj.ref)
+			return
+		}
+		climb(namespace, obj.owners, obj.release)
+	}
+	climb = func(namespace string, ownerRefs []metav1.OwnerReference, release string) {
+		for _, ownerRef := range ownerRefs {
+			if obj, ownerNamespace, found := g.owner(namespace, ownerRef); found {
+				visit(obj, ownerNamespace)
+			}
+		}
+		if obj, found := g.objects[release]; found {
+			visit(obj, namespace)
+		}
+	}
+	climb(namespace, ownerRefs, release)
+	return refs
+}
+
+// selfOrOwners returns the bom-ref of the object when it is included in the BOM, otherwise the bom-refs of its nearest
+// included owners. It returns nil for a nil graph.
+func (g *ownerGraph) selfOrOwners(namespace string, groupKind schema.GroupKind, name string, ownerRefs []metav1.OwnerReference) []string {
+	if g == nil {
+		return nil
+	}
+	release := ""
+	if obj, found := g.objects[ownerKey(namespace, groupKind, name)]; found {
+		if obj.included {
+			return []string{obj.ref}
+		}
+		ownerRefs, release = obj.owners, obj.release
+	}
+	return g.includedOwners(namespace, ownerRefs, release)
+}
+
+// addOwners sets the owners of each component to the bom-refs of its nearest included owners
+func (g *ownerGraph) addOwners(components []model.Component) {
+	for i := range components {
+		obj, found := g.objects[componentKey(&components[i])]
+		if !found {
+			continue
+		}
+		components[i].Owners = g.includedOwners(components[i].GetNamespace(), obj.owners, obj.release)
+	}
+}
+
+// ownerReferences returns the owner references of the named object from the graph
+func (g *ownerGraph) ownerReferences(groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error) {
+	obj, found := g.objects[ownerKey(namespace, groupKind, name)]
+	if !found {
+		return nil, fmt.Errorf("%s %s/%s not found", groupKind, namespace, name)
+	}
+	return obj.owners, nil
+}
+
+// ownerKey is the key of an object in the ownerGraph. The group is part of it since custom resources of different
+// groups can have the same kind, e.g. the HelmReleases of Flux and the Applications of Argo CD and other tools.
+func ownerKey(namespace string, groupKind schema.GroupKind, name string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, groupKind, name)
+}
+
+// ownerGroupKind returns the group and kind of the object an owner reference points to
+func ownerGroupKind(ownerRef metav1.OwnerReference) schema.GroupKind {
+	return schema.FromAPIVersionAndKind(ownerRef.APIVersion, ownerRef.Kind).GroupKind()
+}
+
+// componentKey returns the ownerKey of the object of a component, whose version is the apiVersion of the object
+func componentKey(component *model.Component) string {
+	return ownerKey(component.GetNamespace(), schema.FromAPIVersionAndKind(component.Version, component.GetKind()).GroupKind(), component.Name)
+}
+
+// addImageOwners adds the owners to the image, keeping each one once since the image can be shared by several Pods
+func addImageOwners(image *model.Component, owners []string) {
+	for _, owner := range owners {
+		if !slices.Contains(image.Owners, owner) {
+			image.Owners = append(image.Owners, owner)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package k8
+
+import (
+	"context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"slices"
+)
+
+// QueryPlan is K8Filter compiled into the list requests GetAllComponents sends: the resource types it lists, in which
+// namespaces and with which selectors, and the ones it skips because the filter could include none of their objects
+type QueryPlan struct {
+	// Queries has a query for every resource type, in discovery order
+	Queries []ResourceQuery
+	// ImageNamespaces are the namespaces the Pods and workloads the images are taken from are listed in, nil for all
+	ImageNamespaces []string
+	// HelmReleases is the query of the Secrets or ConfigMaps Helm stores the releases in, in the namespaces the filter
+	// could include releases in
+	HelmReleases ResourceQuery
+}
+
+// ResourceQuery is how a single resource type is listed
+type ResourceQuery struct {
+	GVR        schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+	// Namespaces are the namespaces the resource type is listed in, with a request each. Nil lists it across all
+	// namespaces with a single request.
+	Namespaces []string
+	// The selectors sent to the API server
+	LabelSelector string
+	FieldSelector string
+	// Purpose is why a resource type the filter includes none of is listed anyway, e.g. to follow the owners of the Pods
+	Purpose string
+	// Skip is why the resource type is not listed at all, empty when it is listed
+	Skip string
+
+	resource resourceType
+}
+
+// Requests returns the number of list requests of the query, not counting pagination
+func (q ResourceQuery) Requests() int {
+	switch {
+	case q.Skip != "":
+		return 0
+	case q.Namespaces == nil:
+		return 1
+	}
+	return len(q.Namespaces)
+}
+
+// Requests returns the number of list requests of the plan, not counting pagination
+func (p QueryPlan) Requests() int {
+	requests := 0
+	for _, query := range p.Queries {
+		requests += query.Requests()
+	}
+	return requests + p.HelmReleases.Requests()
+}
+
+// Why a resource type the filter includes none of is listed anyway. The objects of the Namespaces are returned by
+// GetAllComponents to take the images from, the ReplicaSets and Jobs link the Pods to the workloads that own them, and
+// the GitOps objects add the provenance of the objects they applied.
+const (
+	namespacesPurpose = "namespaces of the images"
+	podOwnersPurpose  = "owners of the Pods"
+	gitOpsPurpose     = "GitOps provenance"
+	helmPurpose       = "Helm releases"
+)
+
+// podOwnerKinds are the kinds between the Pods and the workloads that own them
+var (
+	replicaSetKind = schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}
+	jobKind        = schema.GroupKind{Group: "batch", Kind: "Job"}
+	podOwnerKinds  = []schema.GroupKind{replicaSetKind, jobKind}
+)
+
+// PlanQueries returns the QueryPlan GetAllComponents would follow for K8Filter, without listing anything but the
+// namespaces
+func (c *K8sClient) PlanQueries(ctx context.Context) (QueryPlan, error) {
+	nsLabels, err := listNamespaceLabels(ctx, c.Client)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+	resourceTypes := c.discoverResourceTypes()
+	var namespaces []string
+	if idx := slices.IndexFunc(resourceTypes, resourceType.isNamespace); idx >= 0 {
+		namespaces = c.listResources(ctx, namespaceQuery(resourceTypes[idx]), newOwnerGraph()).namespaces
+	}
+	if ctx.Err() != nil {
+		return QueryPlan{}, ctx.Err()
+	}
+	return planQueries(resourceTypes, namespaces, nsLabels, c.HelmDriver), nil
+}
+
+// planQueries compiles K8Filter into the queries of the resource types and of the Helm storage of the driver. The
+// namespace patterns and selectors of the filter are matched against the namespaces found in the cluster and their
+// labels.
+func planQueries(resourceTypes []resourceType, namespaces []string, nsLabels namespaceLabels, helmDriver string) QueryPlan {
+	plan := QueryPlan{Queries: make([]ResourceQuery, len(resourceTypes)), HelmReleases: helmReleasesQuery(helmDriver, namespaces, nsLabels)}
+	for idx, resource := range resourceTypes {
+		plan.Queries[idx] = planQuery(resource, namespaces, nsLabels)
+	}
+
+	// The objects of the skipped resource types that the included objects are linked through are listed after all. The
+	// Pods the images are taken from are listed whether the filter includes them or not.
+	var imageNamespaceList []string
+	if filterNamespaces := K8Filter.GetNamespaceList(namespaces); len(filterNamespaces) > 0 {
+		imageNamespaceList = imageNamespaces(filterNamespaces, nsLabels)
+	}
+	plan.ImageNamespaces = imageNamespaceList
+	for idx, query := range plan.Queries {
+		if query.Skip == "" {
+			continue
+		}
+		groupKind := schema.GroupKind{Group: query.GVR.Group, Kind: query.Kind}
+		switch {
+		case slices.Contains(podOwnerKinds, groupKind) && (imageNamespaceList == nil || len(imageNamespaceList) > 0):
+			query.Namespaces, query.Purpose = imageNamespaceList, podOwnersPurpose
+		case isGitOpsKind(groupKind):
+			query.Namespaces, query.Purpose = nil, gitOpsPurpose
+		default:
+			continue
+		}
+		// All of them are needed to follow the links, and the filter is applied to each one the same as to the others
+		query.Skip, query.LabelSelector, query.FieldSelector = "", "", ""
+		plan.Queries[idx] = query
+	}
+	return plan
+}
+
+// namespaces returns the namespaces to list the resource type in, a single one when it is listed across all of them
+func (q ResourceQuery) namespaces() []string {
+	if q.Namespaces == nil {
+		return []string{metav1.NamespaceAll}
+	}
+	return q.Namespaces
+}
+
+// listOptions sets the selectors of the query on the options of a list request
+func (q ResourceQuery) listOptions(options *metav1.ListOptions) {
+	options.LabelSelector, options.FieldSelector = q.LabelSelector, q.FieldSelector
+}
+
+// planQuery returns the query of a single resource type
+func planQuery(resource resourceType, namespaces []string, nsLabels namespaceLabels) ResourceQuery {
+	if resource.isNamespace() {
+		return namespaceQuery(resource)
+	}
+	query := resource.query()
+	target := resource.filterTarget()
+	if !resource.namespaced {
+		if !K8Filter.QueriesNonNamespaced(target) {
+			query.Skip = "the filter includes none of it"
+		}
+		return query
+	}
+	queryNamespaces, all := K8Filter.QueryNamespaces(target, namespaces, map[string]labels.Set(nsLabels))
+	switch {
+	case all:
+	case len(queryNamespaces) == 0:
+		query.Skip = "the filter includes none of it in any namespace"
+	default:
+		query.Namespaces = queryNamespaces
+	}
+	return query
+}
+
+// helmReleasesQuery returns the query of the Secrets or ConfigMaps the driver stores the Helm releases in. They are
+// listed in the namespaces K8Filter could include releases in, with the labels of the current revisions.
+func helmReleasesQuery(driver string, namespaces []string, nsLabels namespaceLabels) ResourceQuery {
+	release := guessResourceType(schema.FromAPIVersionAndKind(HelmReleaseAPIVersion, HelmReleaseKind))
+	query := ResourceQuery{
+		GVR:           helmStorageResource(driver),
+		Kind:          HelmReleaseKind,
+		Namespaced:    true,
+		LabelSelector: helmReleaseLabels.AsSelector().String(),
+		Purpose:       helmPurpose,
+		resource:      release,
+	}
+	if driver == HelmDriverNone {
+		query.Skip = "the Helm driver is none"
+		return query
+	}
+	queryNamespaces, all := K8Filter.QueryNamespaces(release.filterTarget(), namespaces, map[string]labels.Set(nsLabels))
+	switch {
+	case all:
+	case len(queryNamespaces) == 0:
+		query.Skip = "the filter includes no Helm release in any namespace"
+	default:
+		query.Namespaces = queryNamespaces
+	}
+	return query
+}
+
+// namespaceQuery returns the query of the Namespaces, which are always listed in full since the images are taken from
+// the namespaces they return, and the namespace patterns of K8Filter are matched against them
+func namespaceQuery(resource resourceType) ResourceQuery {
+	query := resource.query()
+	query.LabelSelector, query.FieldSelector = "", ""
+	if !K8Filter.QueriesNonNamespaced(resource.filterTarget()) {
+		query.Purpose = namespacesPurpose
+	}
+	return query
+}
+
+// query returns the query listing the resource type across all namespaces, with the selectors of K8Filter
+func (r resourceType) query() ResourceQuery {
+	labelSelector, fieldSelector := K8Filter.ListSelectors(r.namespaced, r.filterTarget())
+	return ResourceQuery{
+		GVR:           r.gvr,
+		Kind:          r.kind,
+		Namespaced:    r.namespaced,
+		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
+		resource:      r,
+	}
+}
+
+func (r resourceType) isNamespace() bool {
+	return r.gvr.Group == "" && r.kind == "Namespace"
+}
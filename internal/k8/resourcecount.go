@@ -0,0 +1,71 @@
+package k8
+
+import (
+	"context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"slices"
+)
+
+// ResourceCount is the number of objects of a resource type by namespace
+type ResourceCount struct {
+	GVR        schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+	Objects    NamespaceCounts
+	// Err is why the objects could not be counted
+	Err error
+}
+
+// CountResources counts the objects of every resource type the cluster serves that can be listed, by namespace. Only
+// the metadata of the objects is listed, and K8Filter is not applied.
+func (c *K8sClient) CountResources(ctx context.Context) ([]ResourceCount, error) {
+	resourceTypes := slices.DeleteFunc(c.discoverResourceTypes(), func(resource resourceType) bool { return !resource.listable() })
+	counts := make([]ResourceCount, len(resourceTypes))
+	c.parallel(ctx, len(resourceTypes), func(idx int) {
+		counts[idx] = c.countResource(ctx, resourceTypes[idx])
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return counts, nil
+}
+
+// countResource counts the objects of the resource type by namespace, listing them metadata-only when possible
+func (c *K8sClient) countResource(ctx context.Context, resource resourceType) ResourceCount {
+	count := ResourceCount{GVR: resource.gvr, Kind: resource.kind, Namespaced: resource.namespaced}
+	var continueToken string
+	for {
+		listOptions := metav1.ListOptions{Continue: continueToken}
+		if c.MetadataClient == nil {
+			list, err := c.DynamicClient.Resource(resource.gvr).List(ctx, listOptions)
+			if err != nil {
+				count.Err = err
+				return count
+			}
+			for _, item := range list.Items {
+				count.Objects.add(item.GetNamespace())
+			}
+			continueToken = list.GetContinue()
+		} else {
+			list, err := c.MetadataClient.Resource(resource.gvr).List(ctx, listOptions)
+			if err != nil {
+				count.Err = err
+				return count
+			}
+			for _, item := range list.Items {
+				count.Objects.add(item.Namespace)
+			}
+			continueToken = list.Continue
+		}
+		if continueToken == "" {
+			return count
+		}
+	}
+}
+
+// listable checks whether the resource type supports list. Resource types without any verbs in discovery are assumed
+// to support it.
+func (r resourceType) listable() bool {
+	return len(r.verbs) == 0 || slices.Contains(r.verbs, "list")
+}
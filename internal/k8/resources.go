@@ -0,0 +1,135 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/utils"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// builtinShortNames are the short names the API server lists for the built-in kinds whose objects are also read without
+// discovery, i.e. from manifests, and for the Pods and workloads the images come from
+var builtinShortNames = map[schema.GroupKind][]string{
+	{Group: "", Kind: "Pod"}:                                          {"po"},
+	{Group: "", Kind: "Service"}:                                      {"svc"},
+	{Group: "", Kind: "ConfigMap"}:                                    {"cm"},
+	{Group: "", Kind: "Namespace"}:                                    {"ns"},
+	{Group: "", Kind: "Node"}:                                         {"no"},
+	{Group: "", Kind: "ServiceAccount"}:                               {"sa"},
+	{Group: "", Kind: "PersistentVolume"}:                             {"pv"},
+	{Group: "", Kind: "PersistentVolumeClaim"}:                        {"pvc"},
+	{Group: "", Kind: "ReplicationController"}:                        {"rc"},
+	{Group: "apps", Kind: "Deployment"}:                               {"deploy"},
+	{Group: "apps", Kind: "StatefulSet"}:                              {"sts"},
+	{Group: "apps", Kind: "DaemonSet"}:                                {"ds"},
+	{Group: "apps", Kind: "ReplicaSet"}:                               {"rs"},
+	{Group: "batch", Kind: "CronJob"}:                                 {"cj"},
+	{Group: "networking.k8s.io", Kind: "Ingress"}:                     {"ing"},
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}: {"crd", "crds"},
+}
+
+// podResource is the resource type of the Pods the images are taken from
+var podResource = guessResourceType(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+
+// guessResourceType returns the resource type of a kind that was not found through discovery, with its plural and
+// singular names guessed from the kind the same way the API machinery does
+func guessResourceType(gvk schema.GroupVersionKind) resourceType {
+	plural, singular := meta.UnsafeGuessKindToResource(gvk)
+	return resourceType{
+		gvr:        plural,
+		kind:       gvk.Kind,
+		singular:   singular.Resource,
+		shortNames: builtinShortNames[gvk.GroupKind()],
+	}
+}
+
+// filterTarget returns the kind, group and names K8Filter matches the resources of a filter entry against
+func (r resourceType) filterTarget() model.FilterTarget {
+	return model.FilterTarget{
+		Kind:  r.kind,
+		Group: r.gvr.Group,
+		Resource: model.ResourceNames{
+			Plural:     r.gvr.Resource,
+			Singular:   r.singular,
+			ShortNames: r.shortNames,
+		},
+	}
+}
+
+// String returns the kind qualified by its group, e.g. HelmRelease.helm.toolkit.fluxcd.io, as a filter can refer to it
+func (r resourceType) String() string {
+	if r.gvr.Group == "" {
+		return r.kind
+	}
+	return r.kind + "." + r.gvr.Group
+}
+
+// warnedResources holds the resources of K8Filter that were already reported as unknown, so a server or watcher
+// rebuilding the BOM doesn't repeat the warning each time
+var warnedResources sync.Map
+
+// maxCloseMatches is the most resource types a warning about an unknown resource suggests
+const maxCloseMatches = 5
+
+// warnUnknownResources logs a warning for each plain resource of K8Filter that names none of the resource types found
+// through discovery, along with the resource types whose names are close to it
+func warnUnknownResources(resourceTypes []resourceType) {
+	kinds := make([]model.FilterTarget, len(resourceTypes))
+	for i, resource := range resourceTypes {
+		kinds[i] = resource.filterTarget()
+	}
+	for _, unknown := range K8Filter.UnknownResources(kinds) {
+		if _, warned := warnedResources.LoadOrStore(unknown, struct{}{}); warned {
+			continue
+		}
+		if matches := closeMatches(unknown, resourceTypes); len(matches) > 0 {
+			log.Warn().Msgf("Unknown resource in filter: %s - it matches nothing, did you mean one of: %s", unknown, strings.Join(matches, ", "))
+		} else {
+			log.Warn().Msgf("Unknown resource in filter: %s - it matches nothing", unknown)
+		}
+	}
+}
+
+// closeMatches returns the resource types with a name within a couple of edits of the resource, or that starts with
+// it, closest first
+func closeMatches(resource string, resourceTypes []resourceType) []string {
+	resource = strings.ToLower(resource)
+	distances := make(map[string]int)
+	for _, r := range resourceTypes {
+		names := append([]string{r.kind, r.gvr.Resource, r.singular}, r.shortNames...)
+		if r.gvr.Group != "" {
+			names = append(names, r.String(), r.gvr.Resource+"."+r.gvr.Group)
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if name == "" {
+				continue
+			}
+			distance := utils.EditDistance(resource, name)
+			if distance > 2 && !(len(resource) >= 3 && strings.HasPrefix(name, resource)) {
+				continue
+			}
+			if current, found := distances[r.String()]; !found || distance < current {
+				distances[r.String()] = distance
+			}
+		}
+	}
+	matches := make([]string, 0, len(distances))
+	for name := range distances {
+		matches = append(matches, name)
+	}
+	slices.SortFunc(matches, func(a, b string) int {
+		if distances[a] != distances[b] {
+			return distances[a] - distances[b]
+		}
+		return strings.Compare(a, b)
+	})
+	if len(matches) > maxCloseMatches {
+		matches = matches[:maxCloseMatches]
+	}
+	return matches
+}
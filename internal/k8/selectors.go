@@ -0,0 +1,135 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"slices"
+	"strings"
+)
+
+// namespaceLabels holds the labels of each namespace by name, for the namespace selectors of K8Filter
+type namespaceLabels map[string]labels.Set
+
+// namespaceLabelsOf returns the labels of the Namespace objects among the items
+func namespaceLabelsOf(items []unstructured.Unstructured) namespaceLabels {
+	result := make(namespaceLabels)
+	for _, item := range items {
+		if item.GetKind() == "Namespace" && item.GroupVersionKind().Group == "" {
+			result[item.GetName()] = item.GetLabels()
+		}
+	}
+	return result
+}
+
+// listNamespaceLabels lists the labels of the namespaces from the cluster. They are only listed when K8Filter selects
+// namespaces by their labels, since the objects are matched while their Namespace may not have been listed yet.
+func listNamespaceLabels(ctx context.Context, client kubernetes.Interface) (namespaceLabels, error) {
+	if !K8Filter.UsesNamespaceSelectors() {
+		return nil, nil
+	}
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	result := make(namespaceLabels)
+	for _, namespace := range namespaces.Items {
+		result[namespace.Name] = namespace.Labels
+	}
+	return result, nil
+}
+
+// target returns what K8Filter matches for the object of the resource type
+func (n namespaceLabels) target(namespace string, resource resourceType, name string, objectLabels map[string]string, objectFields objectFieldGetter) model.FilterTarget {
+	target := resource.filterTarget()
+	target.Namespace = namespace
+	target.Name = name
+	target.Labels = labels.Set(objectLabels)
+	target.Fields = objectFields
+	if nsLabels, exists := n[namespace]; exists {
+		target.NamespaceLabels = nsLabels
+	}
+	return target
+}
+
+// itemTarget returns what K8Filter matches for the item of the resource type
+func (n namespaceLabels) itemTarget(item unstructured.Unstructured, resource resourceType) model.FilterTarget {
+	return n.target(item.GetNamespace(), resource, item.GetName(), item.GetLabels(), objectFieldGetter{object: item.Object})
+}
+
+// objectFieldGetter reads the fields a field selector refers to, e.g. status.phase, from an unstructured or a typed
+// object. A typed object is only converted when a field selector reads its fields.
+type objectFieldGetter struct {
+	object map[string]interface{}
+	typed  runtime.Object
+}
+
+func (o objectFieldGetter) Has(field string) bool {
+	_, found := o.lookup(field)
+	return found
+}
+
+func (o objectFieldGetter) Get(field string) string {
+	value, _ := o.lookup(field)
+	return value
+}
+
+func (o objectFieldGetter) lookup(field string) (string, bool) {
+	object := o.object
+	if object == nil && o.typed != nil {
+		converted, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o.typed)
+		if err != nil {
+			return "", false
+		}
+		object = converted
+	}
+	value, found, err := unstructured.NestedFieldNoCopy(object, strings.Split(field, ".")...)
+	if err != nil || !found {
+		return "", false
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// imageNamespaces drops the namespaces that K8Filter excludes entirely or whose labels its namespace selectors leave
+// out, so their Pods and workloads are never listed
+func imageNamespaces(namespaceList []string, nsLabels namespaceLabels) []string {
+	return slices.DeleteFunc(slices.Clone(namespaceList), func(namespace string) bool {
+		return K8Filter.ExcludesNamespace(namespace, nsLabels[namespace]) || !K8Filter.SelectsNamespace(namespace, nsLabels[namespace])
+	})
+}
+
+// selectImageSources drops the Pods and workloads that K8Filter excludes or that its selectors leave out, so the images
+// only they run or declare are left out of the BOM
+func selectImageSources(pods []*corev1.Pod, templates []workloadTemplate, nsLabels namespaceLabels) ([]*corev1.Pod, []workloadTemplate) {
+	pods = slices.DeleteFunc(pods, func(pod *corev1.Pod) bool { return !nsLabels.selectsPod(pod) })
+	templates = slices.DeleteFunc(templates, func(workload workloadTemplate) bool { return !nsLabels.selectsWorkload(workload) })
+	return pods, templates
+}
+
+func (n namespaceLabels) selectsPod(pod *corev1.Pod) bool {
+	return selectsImagesOf(n.target(pod.Namespace, podResource, pod.Name, pod.Labels, objectFieldGetter{typed: pod}))
+}
+
+func (n namespaceLabels) selectsWorkload(workload workloadTemplate) bool {
+	return selectsImagesOf(n.target(workload.namespace, guessResourceType(workload.gvk()), workload.name, workload.labels, workload.fields))
+}
+
+// selectsImagesOf checks whether the images of the Pod or workload belong in the BOM. Besides the exclusions, only the
+// selectors the API server applies when listing its kind are checked, the same as for the Pods K8sClient lists.
+func selectsImagesOf(target model.FilterTarget) bool {
+	if K8Filter.ShouldExcludeObject(target) || !K8Filter.SelectsNamespace(target.Namespace, target.NamespaceLabels) {
+		return false
+	}
+	labelSelector, fieldSelector := K8Filter.ListSelectors(true, target)
+	return K8Filter.MatchesSelectors(model.Selectors{LabelSelector: labelSelector, FieldSelector: fieldSelector}, target)
+}
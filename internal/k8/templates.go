@@ -0,0 +1,260 @@
+package k8
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"slices"
+)
+
+// podTemplatePaths maps the built-in workload kinds to the location of their pod template
+var podTemplatePaths = map[schema.GroupKind][]string{
+	{Group: "apps", Kind: "Deployment"}:        {"spec", "template"},
+	{Group: "apps", Kind: "StatefulSet"}:       {"spec", "template"},
+	{Group: "apps", Kind: "DaemonSet"}:         {"spec", "template"},
+	{Group: "apps", Kind: "ReplicaSet"}:        {"spec", "template"},
+	{Group: "", Kind: "ReplicationController"}: {"spec", "template"},
+	{Group: "batch", Kind: "Job"}:              {"spec", "template"},
+	{Group: "batch", Kind: "CronJob"}:          {"spec", "jobTemplate", "spec", "template"},
+	{Group: "", Kind: "PodTemplate"}:           {"template"},
+}
+
+// replicatedKinds are the built-in workload kinds with a spec.replicas, which defaults to 1
+var replicatedKinds = []schema.GroupKind{
+	{Group: "apps", Kind: "Deployment"},
+	{Group: "apps", Kind: "StatefulSet"},
+	{Group: "apps", Kind: "ReplicaSet"},
+	{Group: "", Kind: "ReplicationController"},
+}
+
+// getReplicas returns the spec.replicas of a workload, or 0 for the kinds without it
+func getReplicas(item unstructured.Unstructured) int32 {
+	if !slices.Contains(replicatedKinds, item.GroupVersionKind().GroupKind()) {
+		return 0
+	}
+	replicas, found, err := unstructured.NestedInt64(item.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 1
+	}
+	return int32(replicas)
+}
+
+// getPodTemplate returns the pod template of a workload. The second return value is false when the item is not a
+// workload or has no pod template.
+func getPodTemplate(item unstructured.Unstructured) (*corev1.PodTemplateSpec, bool) {
+	path, isWorkload := podTemplatePaths[item.GroupVersionKind().GroupKind()]
+	if !isWorkload {
+		return nil, false
+	}
+	templateMap, found, err := unstructured.NestedMap(item.Object, path...)
+	if err != nil || !found {
+		return nil, false
+	}
+	var template corev1.PodTemplateSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(templateMap, &template); err != nil {
+		return nil, false
+	}
+	return &template, true
+}
+
+// workloadTemplate is the pod template of a workload, from which the images it declares are collected
+type workloadTemplate struct {
+	group           string
+	kind            string
+	namespace       string
+	name            string
+	ownerReferences []metav1.OwnerReference
+	template        *corev1.PodTemplateSpec
+	replicas        int32             // The spec.replicas of the workload, 0 for the kinds without it
+	labels          map[string]string // The labels and fields of the workload, matched by the selectors of K8Filter
+	fields          objectFieldGetter
+}
+
+// newWorkloadTemplate returns the pod template of a workload from the manifests. The second return value is false when
+// the item is not a workload or has no pod template.
+func newWorkloadTemplate(item unstructured.Unstructured) (workloadTemplate, bool) {
+	template, isWorkload := getPodTemplate(item)
+	if !isWorkload {
+		return workloadTemplate{}, false
+	}
+	return workloadTemplate{
+		group:           item.GroupVersionKind().Group,
+		kind:            item.GetKind(),
+		namespace:       item.GetNamespace(),
+		name:            item.GetName(),
+		ownerReferences: item.GetOwnerReferences(),
+		template:        template,
+		replicas:        getReplicas(item),
+		labels:          item.GetLabels(),
+		fields:          objectFieldGetter{object: item.Object},
+	}, true
+}
+
+// typedWorkloadTemplate returns the pod template of a typed built-in workload from the API or an informer cache. The
+// second return value is false for any other object, and for the scaled down ReplicaSets of a controller.
+func typedWorkloadTemplate(obj interface{}) (workloadTemplate, bool) {
+	var group, kind string
+	var objectMeta *metav1.ObjectMeta
+	var template *corev1.PodTemplateSpec
+	var replicas int32
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		group, kind, objectMeta, template = "apps", "Deployment", &workload.ObjectMeta, &workload.Spec.Template
+		replicas = ptr.Deref(workload.Spec.Replicas, 1)
+	case *appsv1.StatefulSet:
+		group, kind, objectMeta, template = "apps", "StatefulSet", &workload.ObjectMeta, &workload.Spec.Template
+		replicas = ptr.Deref(workload.Spec.Replicas, 1)
+	case *appsv1.DaemonSet:
+		group, kind, objectMeta, template = "apps", "DaemonSet", &workload.ObjectMeta, &workload.Spec.Template
+	case *appsv1.ReplicaSet:
+		group, kind, objectMeta, template = "apps", "ReplicaSet", &workload.ObjectMeta, &workload.Spec.Template
+		replicas = ptr.Deref(workload.Spec.Replicas, 1)
+		// The old revisions a Deployment scaled down declare images it no longer runs
+		if replicas == 0 && metav1.GetControllerOf(workload) != nil {
+			return workloadTemplate{}, false
+		}
+	case *batchv1.Job:
+		group, kind, objectMeta, template = "batch", "Job", &workload.ObjectMeta, &workload.Spec.Template
+	case *batchv1.CronJob:
+		group, kind, objectMeta, template = "batch", "CronJob", &workload.ObjectMeta, &workload.Spec.JobTemplate.Spec.Template
+	default:
+		return workloadTemplate{}, false
+	}
+	return workloadTemplate{
+		group:           group,
+		kind:            kind,
+		namespace:       objectMeta.Namespace,
+		name:            objectMeta.Name,
+		ownerReferences: objectMeta.OwnerReferences,
+		template:        template,
+		replicas:        replicas,
+		labels:          objectMeta.Labels,
+		fields:          objectFieldGetter{typed: obj.(runtime.Object)},
+	}, true
+}
+
+// gvk returns the kind of the workload. Only its group and kind are known.
+func (w workloadTemplate) gvk() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: w.group, Kind: w.kind}
+}
+
+// templatePageSize is the number of workloads listed per request
+const templatePageSize = 500
+
+// workloadList is the list request of a built-in workload kind the pod templates are taken from
+type workloadList struct {
+	kind string
+	list func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error)
+}
+
+var workloadLists = []workloadList{
+	{"Deployment", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().Deployments(namespace).List(ctx, options)
+	}},
+	{"StatefulSet", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().StatefulSets(namespace).List(ctx, options)
+	}},
+	{"DaemonSet", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().DaemonSets(namespace).List(ctx, options)
+	}},
+	{"ReplicaSet", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().ReplicaSets(namespace).List(ctx, options)
+	}},
+	{"Job", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.BatchV1().Jobs(namespace).List(ctx, options)
+	}},
+	{"CronJob", func(ctx context.Context, client kubernetes.Interface, namespace string, options metav1.ListOptions) (runtime.Object, error) {
+		return client.BatchV1().CronJobs(namespace).List(ctx, options)
+	}},
+}
+
+// listWorkloadTemplates lists the pod templates of the built-in workloads in the namespaces, with the kinds listed in
+// parallel on the workers. Each kind is listed across all namespaces with a single paged request, unless only one
+// namespace is wanted or listing across all of them is forbidden, in which case each namespace is listed on its own.
+// The kinds that cannot be listed, e.g. because of RBAC, are skipped with a warning since the running Pods still have
+// their images.
+func (c *K8sClient) listWorkloadTemplates(ctx context.Context, namespaces []string) []workloadTemplate {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	results := make([][]workloadTemplate, len(workloadLists))
+	c.parallel(ctx, len(workloadLists), func(idx int) {
+		results[idx] = listKindTemplates(ctx, c.Client, workloadLists[idx], namespaces)
+	})
+	return slices.Concat(results...)
+}
+
+// listKindTemplates lists the pod templates of a single workload kind in the namespaces
+func listKindTemplates(ctx context.Context, client kubernetes.Interface, workloads workloadList, namespaces []string) []workloadTemplate {
+	if len(namespaces) > 1 {
+		templates, err := listTemplatePages(ctx, client, workloads, metav1.NamespaceAll)
+		if err == nil {
+			wanted := sets.New(namespaces...)
+			return slices.DeleteFunc(templates, func(template workloadTemplate) bool { return !wanted.Has(template.namespace) })
+		}
+		if !apierrors.IsForbidden(err) {
+			log.Warn().Msgf("Could not list the %s pod templates in all namespaces: %v", workloads.kind, err)
+			return nil
+		}
+		log.Debug().Msgf("Listing the %s pod templates in each namespace, not allowed to list them in all namespaces: %v", workloads.kind, err)
+	}
+	var templates []workloadTemplate
+	for _, namespace := range namespaces {
+		found, err := listTemplatePages(ctx, client, workloads, namespace)
+		if err != nil {
+			log.Warn().Msgf("Could not list the %s pod templates in namespace %s: %v", workloads.kind, namespace, err)
+			continue
+		}
+		templates = append(templates, found...)
+	}
+	return templates
+}
+
+// listTemplatePages lists the pod templates of a workload kind in the namespace, or in all of them for
+// metav1.NamespaceAll, following pagination
+func listTemplatePages(ctx context.Context, client kubernetes.Interface, workloads workloadList, namespace string) ([]workloadTemplate, error) {
+	var templates []workloadTemplate
+	options := metav1.ListOptions{Limit: templatePageSize}
+	for {
+		list, err := workloads.list(ctx, client, namespace, options)
+		if err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if template, isWorkload := typedWorkloadTemplate(item); isWorkload {
+				templates = append(templates, template)
+			}
+		}
+		listMeta, err := meta.ListAccessor(list)
+		if err != nil {
+			return nil, err
+		}
+		if options.Continue = listMeta.GetContinue(); options.Continue == "" {
+			return templates, nil
+		}
+	}
+}
+
+// templateSpecs returns the pod specs of the templates
+func templateSpecs(templates []workloadTemplate) []*corev1.PodSpec {
+	specs := make([]*corev1.PodSpec, 0, len(templates))
+	for _, template := range templates {
+		specs = append(specs, &template.template.Spec)
+	}
+	return specs
+}
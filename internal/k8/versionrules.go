@@ -0,0 +1,146 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"slices"
+)
+
+// anyValue matches every group or kind in a version rule
+const anyValue = "*"
+
+// DefaultVersionRules are the built-in version rules, used after the rules set by SetVersionRules. Rules for a single
+// kind come before the labels every kind can have.
+var DefaultVersionRules = model.VersionRules{Rules: []model.VersionRule{
+	{Group: "helm.sh", Kind: HelmReleaseKind, Path: "{.chart.metadata.version}"},
+	{Group: "helm.cattle.io", Kind: "HelmChart", Path: "{.spec.version}"},
+	{Group: "helm.toolkit.fluxcd.io", Kind: "HelmRelease", Path: "{.spec.chart.spec.version}"},
+	{Group: "config.openshift.io", Kind: "ClusterVersion", Path: "{.status.desired.version}"},
+	{Group: "config.openshift.io", Kind: "ClusterVersion", Path: "{.status.currentVersion}"},
+	{Group: "elasticsearch.k8s.elastic.co", Kind: "Elasticsearch", Path: "{.status.version}"},
+	{Group: "kibana.k8s.elastic.co", Kind: "Kibana", Path: "{.status.version}"},
+	{Group: anyValue, Kind: anyValue, Label: "helm.sh/chart"},
+	{Group: anyValue, Kind: anyValue, Label: "app.kubernetes.io/version"},
+}}
+
+// versionRules are the compiled user and built-in version rules, in the order they are tried
+var versionRules = mustParseVersionRules(DefaultVersionRules)
+
+// versionRule is a VersionRule with its JSONPath expression checked
+type versionRule struct {
+	gvk        schema.GroupVersionKind // The version is empty when the rule applies to every version
+	path       string
+	label      string
+	annotation string
+}
+
+// SetVersionRules parses the rules and tries them before the DefaultVersionRules. The version of an object is taken
+// from the first rule that applies to it and finds a value.
+func SetVersionRules(rules model.VersionRules) error {
+	parsed, err := parseVersionRules(model.VersionRules{Rules: append(slices.Clone(rules.Rules), DefaultVersionRules.Rules...)})
+	if err != nil {
+		return err
+	}
+	versionRules = parsed
+	return nil
+}
+
+func mustParseVersionRules(rules model.VersionRules) []versionRule {
+	parsed, err := parseVersionRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func parseVersionRules(rules model.VersionRules) ([]versionRule, error) {
+	parsed := make([]versionRule, 0, len(rules.Rules))
+	for idx, rule := range rules.Rules {
+		if rule.Kind == "" {
+			return nil, fmt.Errorf("version rule %d has no kind", idx+1)
+		}
+		lookups := 0
+		for _, lookup := range []string{rule.Path, rule.Label, rule.Annotation} {
+			if lookup != "" {
+				lookups++
+			}
+		}
+		if lookups != 1 {
+			return nil, fmt.Errorf("version rule for %s must have exactly one of path, label and annotation", rule.Kind)
+		}
+		compiled := versionRule{
+			gvk:        schema.GroupVersionKind{Group: rule.Group, Version: rule.Version, Kind: rule.Kind},
+			label:      rule.Label,
+			annotation: rule.Annotation,
+		}
+		if rule.Path != "" {
+			// Reading a path needs the full objects, which would defeat the metadata-only listing of every other kind
+			if rule.Group == anyValue || rule.Kind == anyValue {
+				return nil, fmt.Errorf("version rule for %s must name a single group and kind to use a path", rule.Kind)
+			}
+			if _, err := parsePath(rule.Kind, rule.Path); err != nil {
+				return nil, fmt.Errorf("version rule for %s has an invalid path %s: %w", rule.Kind, rule.Path, err)
+			}
+			compiled.path = rule.Path
+		}
+		parsed = append(parsed, compiled)
+	}
+	return parsed, nil
+}
+
+// matches checks whether the rule applies to the objects of the group, version and kind
+func (r versionRule) matches(gvk schema.GroupVersionKind) bool {
+	return (r.gvk.Group == anyValue || r.gvk.Group == gvk.Group) &&
+		(r.gvk.Kind == anyValue || r.gvk.Kind == gvk.Kind) &&
+		(r.gvk.Version == "" || r.gvk.Version == gvk.Version)
+}
+
+// hasVersionPath checks whether a version rule reads the objects of the group, version and kind with a JSONPath
+// expression, in which case the full objects are listed
+func hasVersionPath(gvk schema.GroupVersionKind) bool {
+	return slices.ContainsFunc(versionRules, func(rule versionRule) bool { return rule.path != "" && rule.matches(gvk) })
+}
+
+// value returns the version the rule finds in the object, or "" when it finds none
+func (r versionRule) value(item unstructured.Unstructured) string {
+	switch {
+	case r.label != "":
+		return item.GetLabels()[r.label]
+	case r.annotation != "":
+		return item.GetAnnotations()[r.annotation]
+	}
+	results, err := findPath(r.gvk.Kind, r.path, item.Object)
+	if err != nil {
+		log.Debug().Msgf("Version rule path %s does not match %s %s/%s: %v", r.path, item.GetKind(), item.GetNamespace(), item.GetName(), err)
+		return ""
+	}
+	for _, values := range results {
+		for _, value := range values {
+			switch version := value.Interface().(type) {
+			case string:
+				return version
+			case int64, float64, bool:
+				return fmt.Sprint(version)
+			}
+		}
+	}
+	return ""
+}
+
+// addVersionForComponent sets the property to the version found by the first version rule that applies to the item
+func addVersionForComponent(item unstructured.Unstructured, component *model.Component, key string) {
+	gvk := item.GroupVersionKind()
+	for _, rule := range versionRules {
+		if !rule.matches(gvk) {
+			continue
+		}
+		if version := rule.value(item); version != "" {
+			component.AddProperty(key, version)
+			return
+		}
+	}
+	log.Debug().Msgf("No version found for component: %s, kind: %s", component.Name, item.GetKind())
+}
@@ -0,0 +1,507 @@
+package k8
+
+import (
+	"cluster-codex/internal/model"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultDebounce is how long the Watcher waits for further changes before reporting them
+const DefaultDebounce = 2 * time.Second
+
+// Watcher keeps an in-memory copy of the cluster current using shared informers on the discovered resource types and
+// Pods, instead of listing everything on every run. It implements the K8sClientInterface on top of the informer
+// caches so the BOM can be rebuilt with GenerateBOM whenever something changes. The informers only watch what the
+// QueryPlan of K8Filter lists, in the namespaces and with the selectors it lists them with.
+type Watcher struct {
+	client *K8sClient
+	// Debounce is how long to wait after a change for further changes before calling onChange
+	Debounce time.Duration
+
+	plan        QueryPlan // The plan the informers were started from
+	resources   []watchedResource
+	images      map[string]*imageCache // The caches the images are taken from by namespace, or metav1.NamespaceAll
+	helmStorage []cache.Store          // The caches of the Secrets or ConfigMaps storing Helm releases, one per namespace
+	changed     chan struct{}
+	owners      *ownerGraph            // The owner references of the cached objects, set by GetAllComponents
+	ruleImages  []customResourceImages // The images the image rules found in the cached objects, set by GetAllComponents
+	// The labels of the cached namespaces, set by GetAllComponents
+	namespaceLabels namespaceLabels
+}
+
+// watchedResource is the informers for a single resource type, one for each namespace it is watched in
+type watchedResource struct {
+	resource  resourceType
+	informers []cache.SharedIndexInformer
+}
+
+// imageCache is the cache of the Pods and the built-in workloads of a namespace, or of all of them
+type imageCache struct {
+	pods        corelisters.PodLister
+	replicaSets appslisters.ReplicaSetLister
+	jobs        batchlisters.JobLister
+	workloads   []cache.Indexer // The caches of the built-in workloads, for the images of their pod templates
+}
+
+// informerFactory is what the metadata, dynamic and typed informer factories have in common
+type informerFactory interface {
+	Start(stopCh <-chan struct{})
+	Shutdown()
+}
+
+// NewWatcher creates a Watcher for the cluster of the client
+func NewWatcher(client *K8sClient) *Watcher {
+	return &Watcher{
+		client:   client,
+		Debounce: DefaultDebounce,
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// Run starts the informers and waits for their caches to sync. It then calls onChange once, and again each time
+// an object or Pod is added, updated or deleted, until the context is cancelled. When a namespace is added, removed
+// or relabeled so that K8Filter would list other namespaces, the informers are started again from a new QueryPlan.
+func (w *Watcher) Run(ctx context.Context, onChange func()) error {
+	for {
+		replan, err := w.run(ctx, onChange)
+		if err != nil || !replan {
+			return err
+		}
+		log.Info().Msg("The namespaces matching the filter changed, restarting the informers")
+	}
+}
+
+// run watches the cluster following a single QueryPlan. It returns true when the plan is out of date.
+func (w *Watcher) run(ctx context.Context, onChange func()) (bool, error) {
+	plan, err := w.client.PlanQueries(ctx)
+	if err != nil {
+		return false, err
+	}
+	w.plan = plan
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { w.notify() },
+		UpdateFunc: func(interface{}, interface{}) { w.notify() },
+		DeleteFunc: func(interface{}) { w.notify() },
+	}
+
+	// The informers are stopped before their factories wait for them to shut down
+	runCtx, cancel := context.WithCancel(ctx)
+	var factories []informerFactory
+	defer func() {
+		for _, factory := range factories {
+			factory.Shutdown()
+		}
+	}()
+	defer cancel()
+
+	w.resources = nil
+	for _, query := range plan.Queries {
+		resource := query.resource
+		if query.Skip != "" {
+			log.Debug().Msgf("Not watching resource: %s - %s", resource.gvr.String(), query.Skip)
+			continue
+		}
+		if !resource.watchable() {
+			log.Debug().Msgf("Skipping resource that cannot be watched: %s", resource.gvr.String())
+			continue
+		}
+		watched := watchedResource{resource: resource}
+		for _, namespace := range query.namespaces() {
+			// An informer on a resource we are not allowed to list would never sync, so check first
+			options := metav1.ListOptions{Limit: 1}
+			query.listOptions(&options)
+			if _, err := w.client.listPage(ctx, resource, namespace, options); err != nil {
+				if namespace != metav1.NamespaceAll {
+					err = fmt.Errorf("namespace %s: %w", namespace, err)
+				}
+				log.Warn().Msgf("Not watching resource: %v - error: %v", resource.gvr.Resource, err)
+				continue
+			}
+
+			var informer cache.SharedIndexInformer
+			if w.client.needsFullObject(resource) {
+				factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client.DynamicClient, 0, namespace, query.listOptions)
+				informer = factory.ForResource(resource.gvr).Informer()
+				factories = append(factories, factory)
+			} else {
+				factory := metadatainformer.NewFilteredSharedInformerFactory(w.client.MetadataClient, 0, namespace, query.listOptions)
+				informer = factory.ForResource(resource.gvr).Informer()
+				factories = append(factories, factory)
+			}
+			if _, err := informer.AddEventHandler(handler); err != nil {
+				return false, err
+			}
+			watched.informers = append(watched.informers, informer)
+		}
+		if len(watched.informers) > 0 {
+			w.resources = append(w.resources, watched)
+		}
+	}
+
+	// The Pods are cached with the selectors of K8Filter, in the namespaces the images are taken from
+	podQuery := podResource.query()
+	imageNamespaceList := plan.ImageNamespaces
+	if imageNamespaceList == nil {
+		imageNamespaceList = []string{metav1.NamespaceAll}
+	}
+	var typedFactories []informers.SharedInformerFactory
+	w.images = make(map[string]*imageCache)
+	for _, namespace := range imageNamespaceList {
+		podFactory := informers.NewSharedInformerFactoryWithOptions(w.client.Client, 0, informers.WithNamespace(namespace), informers.WithTweakListOptions(podQuery.listOptions))
+		workloadFactory := informers.NewSharedInformerFactoryWithOptions(w.client.Client, 0, informers.WithNamespace(namespace))
+		podInformer := podFactory.Core().V1().Pods()
+		if _, err := podInformer.Informer().AddEventHandler(handler); err != nil {
+			return false, err
+		}
+		// The typed workload caches are only read, to find the owners of Pods and the images of pod templates. They
+		// don't trigger a rebuild since the informers of the discovered resource types already report changes to the
+		// workloads.
+		w.images[namespace] = &imageCache{
+			pods:        podInformer.Lister(),
+			replicaSets: workloadFactory.Apps().V1().ReplicaSets().Lister(),
+			jobs:        workloadFactory.Batch().V1().Jobs().Lister(),
+			workloads: []cache.Indexer{
+				workloadFactory.Apps().V1().Deployments().Informer().GetIndexer(),
+				workloadFactory.Apps().V1().StatefulSets().Informer().GetIndexer(),
+				workloadFactory.Apps().V1().DaemonSets().Informer().GetIndexer(),
+				workloadFactory.Apps().V1().ReplicaSets().Informer().GetIndexer(),
+				workloadFactory.Batch().V1().Jobs().Informer().GetIndexer(),
+				workloadFactory.Batch().V1().CronJobs().Informer().GetIndexer(),
+			},
+		}
+		typedFactories = append(typedFactories, podFactory, workloadFactory)
+	}
+
+	// Only the Secrets or ConfigMaps storing Helm releases are cached, in the namespaces the plan lists them in. A
+	// helm upgrade or uninstall changes them, so they trigger a rebuild like the other informers.
+	w.helmStorage = nil
+	if helmQuery := plan.HelmReleases; helmQuery.Skip == "" {
+		for _, namespace := range helmQuery.namespaces() {
+			// A single item is enough to check that the storage can be listed, without decoding any release
+			options := metav1.ListOptions{Limit: 1}
+			helmQuery.listOptions(&options)
+			if err := w.probeHelmStorage(ctx, namespace, options); err != nil {
+				log.Warn().Msgf("Not watching the Helm releases %s - error: %v", namespaceDescription(namespace), err)
+				continue
+			}
+			helmFactory := informers.NewSharedInformerFactoryWithOptions(w.client.Client, 0, informers.WithNamespace(namespace), informers.WithTweakListOptions(helmQuery.listOptions))
+			informer := helmFactory.Core().V1().Secrets().Informer()
+			if helmQuery.GVR.Resource == "configmaps" {
+				informer = helmFactory.Core().V1().ConfigMaps().Informer()
+			}
+			if _, err := informer.AddEventHandler(handler); err != nil {
+				return false, err
+			}
+			w.helmStorage = append(w.helmStorage, informer.GetStore())
+			typedFactories = append(typedFactories, helmFactory)
+		}
+	}
+
+	for _, factory := range typedFactories {
+		factories = append(factories, factory)
+	}
+	for _, factory := range factories {
+		factory.Start(runCtx.Done())
+	}
+
+	log.Info().Msgf("Waiting for the caches of %d resource types to sync", len(w.resources))
+	for _, watched := range w.resources {
+		for _, informer := range watched.informers {
+			if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+				return false, fmt.Errorf("failed to sync cache for %s: %w", watched.resource.gvr.String(), ctx.Err())
+			}
+		}
+	}
+	for _, factory := range typedFactories {
+		for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return false, fmt.Errorf("failed to sync cache for %s: %w", gvr.String(), ctx.Err())
+			}
+		}
+	}
+
+	// Drop the notifications from the initial sync since the first call to onChange covers them
+	select {
+	case <-w.changed:
+	default:
+	}
+	onChange()
+
+	timer := time.NewTimer(w.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-w.changed:
+			timer.Reset(w.Debounce)
+		case <-timer.C:
+			if w.planChanged() {
+				return true, nil
+			}
+			onChange()
+		}
+	}
+}
+
+// planChanged checks whether K8Filter would list other namespaces than the informers watch, given the namespaces in
+// the informer cache and their labels
+func (w *Watcher) planChanged() bool {
+	namespaces, nsLabels, err := w.cachedNamespaces()
+	if err != nil || namespaces == nil {
+		return false
+	}
+	if !K8Filter.UsesNamespaceSelectors() {
+		nsLabels = nil // The same as PlanQueries, which only lists them for the selectors
+	}
+	resourceTypes := make([]resourceType, len(w.plan.Queries))
+	for idx, query := range w.plan.Queries {
+		resourceTypes[idx] = query.resource
+	}
+	plan := planQueries(resourceTypes, namespaces, nsLabels, w.client.HelmDriver)
+	sameNamespaces := func(a, b []string) bool { return (a == nil) == (b == nil) && slices.Equal(a, b) }
+	return !sameNamespaces(plan.ImageNamespaces, w.plan.ImageNamespaces) ||
+		plan.HelmReleases.Skip != w.plan.HelmReleases.Skip || !sameNamespaces(plan.HelmReleases.Namespaces, w.plan.HelmReleases.Namespaces) ||
+		!slices.EqualFunc(plan.Queries, w.plan.Queries, func(a, b ResourceQuery) bool {
+			return a.Skip == b.Skip && sameNamespaces(a.Namespaces, b.Namespaces)
+		})
+}
+
+// notify records that something changed without blocking the informer
+func (w *Watcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// GetAllComponents returns the components from the informer caches in the same order a K8sClient would list them
+func (w *Watcher) GetAllComponents(ctx context.Context) ([]model.Component, []string, error) {
+	var k8sResourceList []model.Component
+	var namespaces []string
+	graph := newOwnerGraph()
+	var ruleImages []customResourceImages
+	_, nsLabels, err := w.cachedNamespaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, watched := range w.resources {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		items, err := w.cachedItems(watched)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			if item.GetKind() == "Namespace" {
+				namespaces = append(namespaces, item.GetName())
+			}
+			included := shouldIncludeItem(item, watched.resource, nsLabels)
+			graph.add(item, included)
+			if !included {
+				continue
+			}
+			addToComponentList(item, &k8sResourceList)
+			if found := findRuleImages(item); found != nil {
+				ruleImages = append(ruleImages, *found)
+			}
+		}
+	}
+	addHelmReleases(w.cachedHelmReleases(), graph, &k8sResourceList, nsLabels)
+	addVersionSkew(k8sResourceList, w.client.serverVersion())
+	graph.addOwners(k8sResourceList)
+	graph.addGitOpsProvenance(k8sResourceList)
+	w.owners = graph
+	w.ruleImages = ruleImages
+	w.namespaceLabels = nsLabels
+	return k8sResourceList, namespaces, nil
+}
+
+// cachedNamespaces returns the namespaces in the informer cache and their labels, which are needed before any of the
+// other objects is matched against K8Filter. Both are nil when the namespaces are not watched.
+func (w *Watcher) cachedNamespaces() ([]string, namespaceLabels, error) {
+	for _, watched := range w.resources {
+		if watched.resource.isNamespace() {
+			items, err := w.cachedItems(watched)
+			if err != nil {
+				return nil, nil, err
+			}
+			namespaces := make([]string, 0, len(items))
+			for _, item := range items {
+				namespaces = append(namespaces, item.GetName())
+			}
+			return namespaces, namespaceLabelsOf(items), nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// GetAllImages returns the images of the Pods, the pod templates of the workloads and the custom resources in the
+// informer caches
+func (w *Watcher) GetAllImages(ctx context.Context, namespaceList []string) ([]model.Component, error) {
+	images := newImageCollector(w.getOwnerReferences, w.owners)
+	var pods []*corev1.Pod
+	var templates []workloadTemplate
+	namespaceList = imageNamespaces(namespaceList, w.namespaceLabels)
+	for _, namespace := range namespaceList {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		cached := w.imageCache(namespace)
+		if cached == nil {
+			continue // A namespace created since the informers were started, which they are about to be again for
+		}
+		namespacePods, err := cached.pods.Pods(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		// The cache has no order, so sort the Pods to keep the BOM stable
+		slices.SortFunc(namespacePods, func(a, b *corev1.Pod) int { return strings.Compare(a.Name, b.Name) })
+		pods = append(pods, namespacePods...)
+		namespaceTemplates, err := cached.templates(namespace)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, namespaceTemplates...)
+	}
+	pods, templates = selectImageSources(pods, templates, w.namespaceLabels)
+	ruleImages := inNamespaces(w.ruleImages, namespaceList)
+	images.resolveDigests(ctx, w.client.DigestResolver, append(specImages(append(podSpecs(pods), templateSpecs(templates)...)), ruleImageReferences(ruleImages)...))
+	for _, pod := range pods {
+		images.addPod(ctx, pod)
+	}
+	images.addTemplates(ctx, templates)
+	images.addRuleImages(ruleImages)
+	return images.components(), nil
+}
+
+// imageCache returns the cache of the Pods and workloads of the namespace, nil when it is not watched
+func (w *Watcher) imageCache(namespace string) *imageCache {
+	if cached, found := w.images[namespace]; found {
+		return cached
+	}
+	return w.images[metav1.NamespaceAll]
+}
+
+// templates returns the pod templates of the workloads in the namespace from the informer caches, sorted by name
+// within each kind
+func (ic *imageCache) templates(namespace string) ([]workloadTemplate, error) {
+	var templates []workloadTemplate
+	for _, indexer := range ic.workloads {
+		objects, err := indexer.ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list workloads: %w", err)
+		}
+		var kindTemplates []workloadTemplate
+		for _, obj := range objects {
+			if template, isWorkload := typedWorkloadTemplate(obj); isWorkload {
+				kindTemplates = append(kindTemplates, template)
+			}
+		}
+		slices.SortFunc(kindTemplates, func(a, b workloadTemplate) int { return strings.Compare(a.name, b.name) })
+		templates = append(templates, kindTemplates...)
+	}
+	return templates, nil
+}
+
+// probeHelmStorage checks that the Secrets or ConfigMaps storing Helm releases can be listed in the namespace
+func (w *Watcher) probeHelmStorage(ctx context.Context, namespace string, options metav1.ListOptions) error {
+	if w.plan.HelmReleases.GVR.Resource == "configmaps" {
+		_, err := w.client.Client.CoreV1().ConfigMaps(namespace).List(ctx, options)
+		return err
+	}
+	_, err := w.client.Client.CoreV1().Secrets(namespace).List(ctx, options)
+	return err
+}
+
+// cachedHelmReleases decodes the current Helm releases from the informer caches
+func (w *Watcher) cachedHelmReleases() []unstructured.Unstructured {
+	var secrets []*corev1.Secret
+	var configMaps []*corev1.ConfigMap
+	for _, store := range w.helmStorage {
+		for _, obj := range store.List() {
+			switch typed := obj.(type) {
+			case *corev1.Secret:
+				secrets = append(secrets, typed)
+			case *corev1.ConfigMap:
+				configMaps = append(configMaps, typed)
+			}
+		}
+	}
+	return append(helmReleasesFromSecrets(secrets), helmReleasesFromConfigMaps(configMaps)...)
+}
+
+// cachedItems returns the objects in the informer cache sorted by namespace and name
+func (w *Watcher) cachedItems(watched watchedResource) ([]unstructured.Unstructured, error) {
+	var items []unstructured.Unstructured
+	var objects []interface{}
+	for _, informer := range watched.informers {
+		objects = append(objects, informer.GetStore().List()...)
+	}
+	for _, obj := range objects {
+		switch typed := obj.(type) {
+		case *unstructured.Unstructured:
+			items = append(items, *typed)
+		case *metav1.PartialObjectMetadata:
+			item, err := metadataToUnstructured(typed, watched.resource)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	slices.SortFunc(items, func(a, b unstructured.Unstructured) int {
+		return strings.Compare(a.GetNamespace()+"/"+a.GetName(), b.GetNamespace()+"/"+b.GetName())
+	})
+	return items, nil
+}
+
+// getOwnerReferences returns the owner references of the ReplicaSet or Job from the informer cache
+func (w *Watcher) getOwnerReferences(ctx context.Context, groupKind schema.GroupKind, namespace string, name string) ([]metav1.OwnerReference, error) {
+	cached := w.imageCache(namespace)
+	if cached == nil {
+		return nil, fmt.Errorf("%s %s/%s not found: the namespace is not watched", groupKind, namespace, name)
+	}
+	switch groupKind {
+	case replicaSetKind:
+		replicaSet, err := cached.replicaSets.ReplicaSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return replicaSet.OwnerReferences, nil
+	case jobKind:
+		job, err := cached.jobs.Jobs(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return job.OwnerReferences, nil
+	}
+	return nil, nil
+}
+
+// watchable checks whether the resource type supports list and watch. Resource types without any verbs in
+// discovery are assumed to support both.
+func (r resourceType) watchable() bool {
+	if len(r.verbs) == 0 {
+		return true
+	}
+	return slices.Contains(r.verbs, "list") && slices.Contains(r.verbs, "watch")
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"reflect"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -19,6 +22,41 @@ const ComponentNamespace = "clx:k8s:componentNamespace"
 const ComponentVersion = "clx:k8s:componentVersion"
 const ComponentOwnerRef = "clx:k8s:ownerRef"
 const ComponentSourceRef = "clx:k8s:source"
+const ClusterContext = "clx:k8s:context"
+const ClusterPlatform = "clx:k8s:platform"
+const ClusterBuildDate = "clx:k8s:buildDate"
+const ClusterUID = "clx:k8s:clusterUID"
+const ClusterProvider = "clx:k8s:provider"
+const BOMTimestamp = "clx:bom:timestamp"
+const ImageRevision = "clx:oci:revision"
+const ImageVersion = "clx:oci:version"
+const ImageCreated = "clx:oci:created"
+const ImageBaseName = "clx:oci:baseName"
+const ImageBaseDigest = "clx:oci:baseDigest"
+const ComponentNode = "clx:k8s:node"
+const ImageState = "clx:k8s:imageState"
+const NodeKubeletVersion = "clx:k8s:node:kubeletVersion"
+const NodeKubeProxyVersion = "clx:k8s:node:kubeProxyVersion"
+const NodeContainerRuntime = "clx:k8s:node:containerRuntime"
+const NodeContainerRuntimeVersion = "clx:k8s:node:containerRuntimeVersion"
+const NodeOperatingSystem = "clx:k8s:node:operatingSystem"
+const NodeOSImage = "clx:k8s:node:osImage"
+const NodeKernelVersion = "clx:k8s:node:kernelVersion"
+const NodeArchitecture = "clx:k8s:node:architecture"
+const NodeInstanceType = "clx:k8s:node:instanceType"
+const NodeVersionSkew = "clx:k8s:node:versionSkew"
+const HelmChart = "clx:helm:chart"
+const HelmChartVersion = "clx:helm:chartVersion"
+const HelmAppVersion = "clx:helm:appVersion"
+const HelmRevision = "clx:helm:revision"
+const HelmStatus = "clx:helm:status"
+const HelmLastDeployed = "clx:helm:lastDeployed"
+const GitOpsTool = "clx:gitops:tool"
+const GitOpsSourceURL = "clx:gitops:sourceUrl"
+const GitOpsRevision = "clx:gitops:revision"
+const GitOpsSyncStatus = "clx:gitops:syncStatus"
+const GitOpsHealthStatus = "clx:gitops:healthStatus"
+const GitOpsManagedBy = "clx:gitops:managedBy"
 
 // MarshalJSON formats time correctly
 func (ct *CustomTime) MarshalJSON() ([]byte, error) {
@@ -55,13 +93,52 @@ func NewBOM() *BOM {
 			},
 			Component: &Component{
 				Name:    "kubernetes",
-				Version: "", // This should be populated after the server connection
+				Version: "", // The version of the API server, set from the cluster info
 				Type:    "platform",
 			},
 		},
 	}
 }
 
+// NewAggregateBOM creates a single BOM for several clusters. Each cluster becomes a nested platform component that
+// carries the metadata of its own BOM and its components.
+func NewAggregateBOM(clusters []*BOM) *BOM {
+	bom := NewBOM()
+	bom.Metadata.Component.Name = "kubernetes-clusters"
+	for _, cluster := range clusters {
+		clusterComponent := *cluster.Metadata.Component
+		clusterComponent.Properties = append([]Property(nil), cluster.Metadata.Component.Properties...)
+		if cluster.Metadata.Timestamp != nil {
+			timestamp, _ := cluster.Metadata.Timestamp.MarshalJSON()
+			clusterComponent.AddProperty(BOMTimestamp, strings.Trim(string(timestamp), `"`))
+		}
+		// Name each cluster after its context so they can be told apart
+		if context, found := clusterComponent.GetProperty(ClusterContext); found {
+			clusterComponent.Name = context
+		}
+		clusterComponent.Components = cluster.Components
+		if len(cluster.Dependencies) > 0 {
+			// The same objects exist in several clusters, so prefix the bom-refs with the cluster to keep them unique
+			clusterComponent.Components = make([]Component, len(cluster.Components))
+			for i, component := range cluster.Components {
+				if component.BOMRef != "" {
+					component.BOMRef = clusterComponent.Name + "/" + component.BOMRef
+				}
+				clusterComponent.Components[i] = component
+			}
+			for _, dependency := range cluster.Dependencies {
+				var dependsOn []string
+				for _, ref := range dependency.DependsOn {
+					dependsOn = append(dependsOn, clusterComponent.Name+"/"+ref)
+				}
+				bom.AddDependency(clusterComponent.Name+"/"+dependency.Ref, dependsOn...)
+			}
+		}
+		bom.Components = append(bom.Components, clusterComponent)
+	}
+	return bom
+}
+
 // BOM represents the CycloneDX Bill of Materials
 type BOM struct {
 	BomFormat    string      `json:"bomFormat"`
@@ -70,6 +147,16 @@ type BOM struct {
 	Version      int         `json:"version"`
 	Metadata     *Metadata   `json:"metadata,omitempty"`
 	Components   []Component `json:"components,omitempty"`
+	// Dependencies link components by their bom-ref, e.g. a node to the images it runs
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+
+	dependencyIndex map[string]int // The index of each ref in Dependencies, maintained by AddDependency
+}
+
+// Dependency lists the components a component depends on by their bom-ref
+type Dependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
 }
 
 // Metadata provides information about the SBOM creation
@@ -88,13 +175,25 @@ type Tool struct {
 
 // Component defines a software package or library
 type Component struct {
-	Type       string     `json:"type"`
+	Type string `json:"type"`
+	// BOMRef identifies the component in the dependencies of the BOM
+	BOMRef     string     `json:"bom-ref,omitempty"`
 	Name       string     `json:"name"`
 	Version    string     `json:"version"`
 	PackageURL string     `json:"purl,omitempty"`
 	Properties []Property `json:"properties,omitempty"`
 	Licenses   []License  `json:"licenses,omitempty"`
 	Hashes     []Hash     `json:"hashes,omitempty"`
+	// Supplier is the organization that supplied the component, e.g. the vendor of an image
+	Supplier           *OrganizationalEntity `json:"supplier,omitempty"`
+	ExternalReferences []ExternalReference   `json:"externalReferences,omitempty"`
+	// Components are nested components, e.g. the components of each cluster in an aggregate BOM
+	Components []Component `json:"components,omitempty"`
+	// Evidence lists where the component was found, e.g. the workloads that run an image
+	Evidence *Evidence `json:"evidence,omitempty"`
+	// Owners are the package URLs of the components that own this one, e.g. the Deployment of a ReplicaSet. They are
+	// written to the dependencies of the BOM by AddOwnerDependencies.
+	Owners []string `json:"-"`
 }
 
 func (component *Component) AddProperty(key string, value string) {
@@ -157,13 +256,19 @@ func (component *Component) GetNamespace() string {
 	return ""
 }
 
+// InNamespace returns whether the component is in the namespace. An image is in every namespace it runs in.
+func (component *Component) InNamespace(namespace string) bool {
+	property, found := component.GetPropertyObject(ComponentNamespace)
+	return found && slices.Contains(property.Values, namespace)
+}
+
 // ByComponentSorting Sorting implementation for Components
 type ByComponentSorting []Component
 
 func (c ByComponentSorting) Len() int { return len(c) }
 func (c ByComponentSorting) Less(i, j int) bool {
-	// 1️⃣ Sort by Type: "application" < "container"
-	typeOrder := map[string]int{"application": 0, "container": 1}
+	// 1️⃣ Sort by Type: "application" < "container" < "platform"
+	typeOrder := map[string]int{"application": 0, "container": 1, "platform": 2}
 	ti, tj := typeOrder[c[i].Type], typeOrder[c[j].Type]
 	if ti != tj {
 		return ti < tj
@@ -186,12 +291,17 @@ func (c ByComponentSorting) Less(i, j int) bool {
 func (c ByComponentSorting) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
 
 func (bom *BOM) Sort() {
+	sortComponents(bom.Components)
+}
+
+func sortComponents(components []Component) {
 	// Sort the components
-	sort.Sort(ByComponentSorting(bom.Components))
+	sort.Sort(ByComponentSorting(components))
 
-	// Sort the properties in each component by name
-	for i := range bom.Components {
-		sort.Sort(ByPropertyName(bom.Components[i].Properties))
+	// Sort the properties in each component by name, and any nested components
+	for i := range components {
+		sort.Sort(ByPropertyName(components[i].Properties))
+		sortComponents(components[i].Components)
 	}
 }
 
@@ -203,6 +313,10 @@ func (bom *BOM) FindContainers(name string, kind string, namespace string) []Com
 	return bom.findComponents("container", name, kind, namespace)
 }
 
+func (bom *BOM) FindPlatforms(name string, kind string, namespace string) []Component {
+	return bom.findComponents("platform", name, kind, namespace)
+}
+
 func (bom *BOM) findComponents(componentType string, name string, kind string, namespace string) []Component {
 	var returnComponents []Component = make([]Component, 0)
 	for _, component := range bom.Components {
@@ -211,8 +325,7 @@ func (bom *BOM) findComponents(componentType string, name string, kind string, n
 				props, found := component.GetProperty(ComponentKind)
 				if found && props == kind {
 					if namespace != "" {
-						props, found := component.GetProperty(ComponentNamespace)
-						if found && props == namespace {
+						if component.InNamespace(namespace) {
 							returnComponents = append(returnComponents, component)
 						}
 						continue
@@ -240,8 +353,7 @@ func (bom *BOM) findComponentsByKind(componentType string, kind string, namespac
 			props, found := component.GetProperty(ComponentKind)
 			if found && props == kind {
 				if namespace != "" {
-					props, found := component.GetProperty(ComponentNamespace)
-					if found && props == namespace {
+					if component.InNamespace(namespace) {
 						returnComponents = append(returnComponents, component)
 					}
 					continue
@@ -253,6 +365,101 @@ func (bom *BOM) findComponentsByKind(componentType string, kind string, namespac
 	return returnComponents
 }
 
+// AddDependency records that the component with the bom-ref depends on the others, merging with an existing entry
+func (bom *BOM) AddDependency(ref string, dependsOn ...string) {
+	if bom.dependencyIndex == nil || len(bom.dependencyIndex) != len(bom.Dependencies) {
+		// The dependencies were set directly, e.g. when the BOM was read from a file
+		bom.dependencyIndex = make(map[string]int, len(bom.Dependencies))
+		for i, dependency := range bom.Dependencies {
+			bom.dependencyIndex[dependency.Ref] = i
+		}
+	}
+	idx, found := bom.dependencyIndex[ref]
+	if !found {
+		bom.Dependencies = append(bom.Dependencies, Dependency{Ref: ref})
+		idx = len(bom.Dependencies) - 1
+		bom.dependencyIndex[ref] = idx
+	}
+	for _, dependency := range dependsOn {
+		if !slices.Contains(bom.Dependencies[idx].DependsOn, dependency) {
+			bom.Dependencies[idx].DependsOn = append(bom.Dependencies[idx].DependsOn, dependency)
+		}
+	}
+}
+
+// AddNodeDependencies links each node to the images running on it, using the package URLs as bom-refs
+func (bom *BOM) AddNodeDependencies() {
+	nodes := make(map[string]*Component)
+	for i := range bom.Components {
+		if bom.Components[i].GetKind() == "Node" {
+			nodes[bom.Components[i].Name] = &bom.Components[i]
+		}
+	}
+	for i := range bom.Components {
+		image := &bom.Components[i]
+		property, found := image.GetPropertyObject(ComponentNode)
+		if !found {
+			continue
+		}
+		for _, nodeName := range property.Values {
+			node, exists := nodes[nodeName]
+			if !exists {
+				continue // The node is filtered out
+			}
+			node.BOMRef = node.PackageURL
+			image.BOMRef = image.PackageURL
+			bom.AddDependency(node.BOMRef, image.BOMRef)
+		}
+	}
+}
+
+// AddOwnerDependencies links each owner to the components it owns, using the package URLs as bom-refs
+func (bom *BOM) AddOwnerDependencies() {
+	components := make(map[string]*Component, len(bom.Components))
+	for i := range bom.Components {
+		if bom.Components[i].PackageURL != "" {
+			components[bom.Components[i].PackageURL] = &bom.Components[i]
+		}
+	}
+	for i := range bom.Components {
+		owned := &bom.Components[i]
+		for _, ownerRef := range owned.Owners {
+			owner, exists := components[ownerRef]
+			if !exists {
+				continue
+			}
+			owner.BOMRef = owner.PackageURL
+			owned.BOMRef = owned.PackageURL
+			bom.AddDependency(owner.BOMRef, owned.BOMRef)
+		}
+	}
+}
+
+// DiffComponents compares the components of two BOMs by package URL. Changed are the components of the current BOM
+// whose package URL is in both but whose version or properties differ.
+func DiffComponents(previous []Component, current []Component) (added []Component, removed []Component, changed []Component) {
+	previousMap := make(map[string]Component, len(previous))
+	for _, component := range previous {
+		previousMap[component.PackageURL] = component
+	}
+	currentMap := make(map[string]struct{}, len(current))
+	for _, component := range current {
+		currentMap[component.PackageURL] = struct{}{}
+		previousComponent, found := previousMap[component.PackageURL]
+		if !found {
+			added = append(added, component)
+		} else if !reflect.DeepEqual(previousComponent, component) {
+			changed = append(changed, component)
+		}
+	}
+	for _, component := range previous {
+		if _, found := currentMap[component.PackageURL]; !found {
+			removed = append(removed, component)
+		}
+	}
+	return added, removed, changed
+}
+
 func (p Property) MarshalJSON() ([]byte, error) {
 	if len(p.Values) == 1 {
 		return json.Marshal(struct {
@@ -313,7 +520,7 @@ func (p *Property) InsertValue(values ...string) {
 
 		// If the value is already present, do nothing (optional)
 		if index < len(p.Values) && p.Values[index] == value {
-			return
+			continue
 		}
 
 		// Insert the value at the correct position
@@ -323,12 +530,45 @@ func (p *Property) InsertValue(values ...string) {
 	}
 }
 
-// License represents licensing information
+// Evidence records where a component was found
+type Evidence struct {
+	Occurrences []Occurrence `json:"occurrences,omitempty"`
+}
+
+// Occurrence is a single place a component was found. For an image it is a container of a workload: the location is
+// <namespace>/<kind>/<name> of the workload, the symbol is the name of the container and the additional context has
+// the role of the container, the number of replicas running it and the names of their Pods.
+type Occurrence struct {
+	Location          string `json:"location"`
+	Symbol            string `json:"symbol,omitempty"`
+	AdditionalContext string `json:"additionalContext,omitempty"`
+}
+
+// License represents licensing information, either a single license or an SPDX license expression
 type License struct {
+	License    *LicenseInfo `json:"license,omitempty"`
+	Expression string       `json:"expression,omitempty"`
+}
+
+// LicenseInfo identifies a license by its SPDX ID or by name
+type LicenseInfo struct {
 	ID   string `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
 }
 
+// OrganizationalEntity represents an organization, e.g. the supplier of a component
+type OrganizationalEntity struct {
+	Name string   `json:"name,omitempty"`
+	URL  []string `json:"url,omitempty"`
+}
+
+// ExternalReference points to a resource about the component, e.g. its source repository
+type ExternalReference struct {
+	URL     string `json:"url"`
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+}
+
 // Hash represents cryptographic hashes for verification
 type Hash struct {
 	Algorithm string `json:"alg"`
@@ -54,6 +54,56 @@ var _ = Describe("StaticCycloneEntityToJson - Unit", Label("unit"), func() {
 		// Print JSON output (optional)
 		fmt.Println(string(jsonOutput))
 	})
+
+	It("should marshal the licenses, supplier and external references in the CycloneDX shape", func() {
+		component := Component{
+			Type: "container", Name: "ghcr.io/acme/api", Version: "2.1.0",
+			Licenses: []License{
+				{License: &LicenseInfo{ID: "Apache-2.0"}},
+				{Expression: "MIT OR BSD-3-Clause"},
+			},
+			Supplier:           &OrganizationalEntity{Name: "Acme Corp"},
+			ExternalReferences: []ExternalReference{{URL: "https://github.com/acme/api", Type: "vcs"}},
+		}
+
+		jsonOutput, err := json.Marshal(component)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(jsonOutput)).To(ContainSubstring(`"licenses":[{"license":{"id":"Apache-2.0"}},{"expression":"MIT OR BSD-3-Clause"}]`))
+		Expect(string(jsonOutput)).To(ContainSubstring(`"supplier":{"name":"Acme Corp"}`))
+		Expect(string(jsonOutput)).To(ContainSubstring(`"externalReferences":[{"url":"https://github.com/acme/api","type":"vcs"}]`))
+	})
+
+	It("should marshal the evidence occurrences in the CycloneDX shape", func() {
+		component := Component{
+			Type: "container", Name: "index.docker.io/library/nginx", Version: "1.27",
+			Evidence: &Evidence{Occurrences: []Occurrence{
+				{Location: "shop/Deployment/web", Symbol: "web", AdditionalContext: "role=main, replicas=2, pods=web-1 web-2"},
+			}},
+		}
+
+		jsonOutput, err := json.Marshal(component)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(jsonOutput)).To(ContainSubstring(`"evidence":{"occurrences":[{"location":"shop/Deployment/web","symbol":"web","additionalContext":"role=main, replicas=2, pods=web-1 web-2"}]}`))
+	})
+})
+
+var _ = Describe("FindContainers - Unit", Label("unit"), func() {
+	It("should find an image in each of the namespaces it runs in", func() {
+		image := Component{Type: "container", Name: "index.docker.io/library/nginx"}
+		image.AddProperty(ComponentKind, "Image")
+		image.AddProperty(ComponentNamespace, "shop")
+		image.AddProperty(ComponentNamespace, "default")
+		image.AddPropertyMultipleValue(ComponentNamespace, "kube-system", "default")
+		bom := &BOM{Components: []Component{image}}
+
+		Expect(bom.Components[0].Properties).To(ContainElement(Property{Name: ComponentNamespace, Values: []string{"default", "kube-system", "shop"}}))
+		Expect(bom.FindContainers("index.docker.io/library/nginx", "Image", "shop")).To(HaveLen(1))
+		Expect(bom.FindContainers("index.docker.io/library/nginx", "Image", "kube-system")).To(HaveLen(1))
+		Expect(bom.FindContainersByKind("Image", "default")).To(HaveLen(1))
+		Expect(bom.FindContainers("index.docker.io/library/nginx", "Image", "monitoring")).To(BeEmpty())
+	})
 })
 
 var _ = Describe("StaticCycloneEntitySorting - Unit", Label("unit"), func() {
@@ -208,3 +258,138 @@ var _ = Describe("StaticCycloneEntitySorting - Unit", Label("unit"), func() {
 		Expect(testBOM.Components[6].Name).To(Equal("My Container 2"))
 	})
 })
+
+var _ = Describe("NewAggregateBOM - Unit", Label("unit"), func() {
+	It("should nest the components of each cluster under a component with its metadata", func() {
+		dev := NewBOM()
+		dev.Metadata.Component.Version = "v1.30.2"
+		dev.Metadata.Component.AddProperty(ClusterContext, "dev")
+		dev.Components = []Component{{Type: "application", Name: "web"}}
+		prod := NewBOM()
+		prod.Metadata.Component.AddProperty(ClusterContext, "prod")
+		prod.Components = []Component{{Type: "application", Name: "api"}, {Type: "container", Name: "nginx"}}
+
+		bom := NewAggregateBOM([]*BOM{dev, prod})
+
+		Expect(bom.Components).To(HaveLen(2))
+		Expect(bom.Components[0].Name).To(Equal("dev"))
+		Expect(bom.Components[0].Type).To(Equal("platform"))
+		Expect(bom.Components[0].Version).To(Equal("v1.30.2"))
+		Expect(bom.Components[0].Components).To(HaveLen(1))
+		_, found := bom.Components[0].GetProperty(BOMTimestamp)
+		Expect(found).To(BeTrue())
+		Expect(bom.Components[1].Name).To(Equal("prod"))
+		Expect(bom.Components[1].Components).To(HaveLen(2))
+
+		// The metadata of the cluster BOMs is not changed
+		Expect(dev.Metadata.Component.Properties).To(HaveLen(1))
+
+		jsonOutput, err := json.Marshal(bom)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(jsonOutput)).To(ContainSubstring(`"components":[{"type":"application","name":"api"`))
+	})
+})
+
+var _ = Describe("AddNodeDependencies - Unit", Label("unit"), func() {
+	newNode := func(name string) Component {
+		node := Component{Type: "platform", Name: name, PackageURL: "pkg:k8s/Node/" + name + "?apiVersion=v1"}
+		node.AddProperty(ComponentKind, "Node")
+		return node
+	}
+	newImage := func(name string, nodes ...string) Component {
+		image := Component{Type: "container", Name: name, PackageURL: "pkg:oci/" + name}
+		image.AddProperty(ComponentKind, "Image")
+		if len(nodes) > 0 {
+			image.AddPropertyMultipleValue(ComponentNode, nodes...)
+		}
+		return image
+	}
+
+	It("should link each node to the images running on it", func() {
+		bom := NewBOM()
+		bom.Components = []Component{newNode("node-1"), newNode("node-2"), newImage("nginx", "node-1", "node-2"), newImage("busybox", "node-1"), newImage("redis")}
+
+		bom.AddNodeDependencies()
+
+		Expect(bom.Dependencies).To(Equal([]Dependency{
+			{Ref: "pkg:k8s/Node/node-1?apiVersion=v1", DependsOn: []string{"pkg:oci/nginx", "pkg:oci/busybox"}},
+			{Ref: "pkg:k8s/Node/node-2?apiVersion=v1", DependsOn: []string{"pkg:oci/nginx"}},
+		}))
+		Expect(bom.Components[0].BOMRef).To(Equal(bom.Components[0].PackageURL))
+		Expect(bom.Components[2].BOMRef).To(Equal(bom.Components[2].PackageURL))
+		// Images that are not running on a node are not referenced
+		Expect(bom.Components[4].BOMRef).To(BeEmpty())
+	})
+
+	It("should prefix the bom-refs with the cluster in an aggregate BOM", func() {
+		dev := NewBOM()
+		dev.Metadata.Component.AddProperty(ClusterContext, "dev")
+		dev.Components = []Component{newNode("node-1"), newImage("nginx", "node-1")}
+		dev.AddNodeDependencies()
+
+		bom := NewAggregateBOM([]*BOM{dev})
+
+		Expect(bom.Components[0].Components[0].BOMRef).To(Equal("dev/pkg:k8s/Node/node-1?apiVersion=v1"))
+		Expect(bom.Dependencies).To(Equal([]Dependency{
+			{Ref: "dev/pkg:k8s/Node/node-1?apiVersion=v1", DependsOn: []string{"dev/pkg:oci/nginx"}},
+		}))
+		// The cluster BOM is not changed
+		Expect(dev.Components[0].BOMRef).To(Equal("pkg:k8s/Node/node-1?apiVersion=v1"))
+	})
+})
+
+var _ = Describe("AddOwnerDependencies - Unit", Label("unit"), func() {
+	It("should link each owner to the components it owns", func() {
+		bom := NewBOM()
+		bom.Components = []Component{
+			{Type: "application", Name: "web", PackageURL: "pkg:k8s/Deployment/web"},
+			{Type: "application", Name: "web-7d9c", PackageURL: "pkg:k8s/ReplicaSet/web-7d9c", Owners: []string{"pkg:k8s/Deployment/web"}},
+			{Type: "container", Name: "nginx", PackageURL: "pkg:oci/nginx", Owners: []string{"pkg:k8s/ReplicaSet/web-7d9c", "pkg:k8s/Job/missing"}},
+			{Type: "container", Name: "redis", PackageURL: "pkg:oci/redis"},
+		}
+
+		bom.AddOwnerDependencies()
+
+		Expect(bom.Dependencies).To(Equal([]Dependency{
+			{Ref: "pkg:k8s/Deployment/web", DependsOn: []string{"pkg:k8s/ReplicaSet/web-7d9c"}},
+			{Ref: "pkg:k8s/ReplicaSet/web-7d9c", DependsOn: []string{"pkg:oci/nginx"}},
+		}))
+		Expect(bom.Components[0].BOMRef).To(Equal("pkg:k8s/Deployment/web"))
+		Expect(bom.Components[2].BOMRef).To(Equal("pkg:oci/nginx"))
+		// Components without owners in the BOM are not referenced
+		Expect(bom.Components[3].BOMRef).To(BeEmpty())
+	})
+
+	It("should leave the owners out of the JSON", func() {
+		data, err := json.Marshal(Component{Name: "nginx", Owners: []string{"pkg:k8s/Deployment/web"}})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).ToNot(ContainSubstring("Deployment"))
+	})
+})
+
+var _ = Describe("DiffComponents - Unit", Label("unit"), func() {
+	It("should report the added, removed and changed components by package URL", func() {
+		web := Component{Type: "application", Name: "web", PackageURL: "pkg:k8s/Deployment/web?apiVersion=apps%2Fv1&namespace=shop"}
+		nginx := Component{Type: "container", Name: "nginx", Version: "1.27", PackageURL: "pkg:oci/nginx@sha256:aaaa"}
+		busybox := Component{Type: "container", Name: "busybox", Version: "1.36", PackageURL: "pkg:oci/busybox@sha256:bbbb"}
+		relabeledWeb := web
+		relabeledWeb.Properties = []Property{{Name: ComponentVersion, Values: []string{"web-1.2.4"}}}
+
+		added, removed, changed := DiffComponents([]Component{web, nginx}, []Component{relabeledWeb, busybox})
+
+		Expect(added).To(Equal([]Component{busybox}))
+		Expect(removed).To(Equal([]Component{nginx}))
+		Expect(changed).To(Equal([]Component{relabeledWeb}))
+	})
+
+	It("should report nothing when the components are the same", func() {
+		web := Component{Type: "application", Name: "web", PackageURL: "pkg:k8s/Deployment/web"}
+
+		added, removed, changed := DiffComponents([]Component{web}, []Component{web})
+
+		Expect(added).To(BeEmpty())
+		Expect(removed).To(BeEmpty())
+		Expect(changed).To(BeEmpty())
+	})
+})
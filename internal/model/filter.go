@@ -1,47 +1,334 @@
 package model
 
-import "cluster-codex/internal/utils"
+import (
+	"cluster-codex/internal/utils"
+	"fmt"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
 
 type Filter struct {
-	NonNamespacedInclusions NonNamespacedInclusions `json:"non-namespaced-inclusions"`
-	NamespacedInclusions    []NamespacedInclusion   `json:"namespaced-inclusions"`
+	NonNamespacedInclusions NonNamespacedInclusions `json:"non-namespaced-inclusions" yaml:"non-namespaced-inclusions"`
+	NamespacedInclusions    []NamespacedInclusion   `json:"namespaced-inclusions" yaml:"namespaced-inclusions"`
+	// Exclusions take precedence over inclusions
+	NonNamespacedExclusions NonNamespacedExclusions `json:"non-namespaced-exclusions" yaml:"non-namespaced-exclusions"`
+	NamespacedExclusions    []NamespacedExclusion   `json:"namespaced-exclusions" yaml:"namespaced-exclusions"`
+
+	compiled compiledFilter // The patterns and selectors, compiled by Validate
 }
 
 // Inclusion - Struct to match JSON structure
 type NamespacedInclusion struct {
-	Namespaces []string `json:"namespaces"`
-	Resources  []string `json:"resources"`
+	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+	Resources  []string `json:"resources" yaml:"resources"`
+	// NamespaceSelector narrows the namespaces down to the ones whose labels match, e.g. team=payments
+	NamespaceSelector string `json:"namespaceSelector" yaml:"namespaceSelector"`
+	Selectors         `yaml:",inline"`
 }
 
 type NonNamespacedInclusions struct {
-	Resources []string `json:"resources"`
+	Resources []string `json:"resources" yaml:"resources"`
+	Selectors `yaml:",inline"`
 }
 
-func (filter *Filter) ShouldIncludeThisResource(namespace string, kind string) bool {
+// NamespacedExclusion drops the resources in the namespaces. Leaving out the namespaces drops the resources in every
+// namespace, and leaving out the resources drops everything in the namespaces.
+type NamespacedExclusion struct {
+	Namespaces        []string `json:"namespaces" yaml:"namespaces"`
+	Resources         []string `json:"resources" yaml:"resources"`
+	NamespaceSelector string   `json:"namespaceSelector" yaml:"namespaceSelector"`
+	Selectors         `yaml:",inline"`
+}
+
+// NonNamespacedExclusions drops the resources. Leaving out the resources drops every resource the selectors match.
+type NonNamespacedExclusions struct {
+	Resources []string `json:"resources" yaml:"resources"`
+	Selectors `yaml:",inline"`
+}
+
+// Selectors narrow a filter entry down to the objects whose names, labels and fields match. The selectors are written
+// the same as the --selector and --field-selector flags of kubectl.
+type Selectors struct {
+	Names         []string `json:"names" yaml:"names"`
+	LabelSelector string   `json:"labelSelector" yaml:"labelSelector"`
+	FieldSelector string   `json:"fieldSelector" yaml:"fieldSelector"`
+}
+
+// FilterTarget is an object the filter is matched against. Labels, Fields and NamespaceLabels may be nil when unknown,
+// in which case they match no selector.
+type FilterTarget struct {
+	Namespace string
+	Kind      string
+	// Group is the API group of the kind, empty for the core group
+	Group string
+	// Resource holds the other names of the kind, by which the filter can refer to it as well
+	Resource ResourceNames
+	Name     string
+	Labels   labels.Labels
+	Fields   fields.Fields
+	// NamespaceLabels are the labels of the namespace of the object, matched by the namespace selectors
+	NamespaceLabels labels.Labels
+}
+
+// ShouldInclude checks whether a resource of the kind in the namespace, or a non-namespaced one when the namespace is
+// empty, passes the filter. Exclusions take precedence over inclusions.
+func (filter *Filter) ShouldInclude(namespace string, kind string) bool {
+	return filter.ShouldIncludeObject(FilterTarget{Namespace: namespace, Kind: kind})
+}
+
+// ShouldIncludeObject checks whether the object passes the filter. Exclusions take precedence over inclusions.
+func (filter *Filter) ShouldIncludeObject(target FilterTarget) bool {
+	if filter.ShouldExcludeObject(target) {
+		return false
+	}
+	if target.Namespace != "" {
+		return filter.includesNamespaced(target)
+	}
+	f := filter.NonNamespacedInclusions
+	return (filter.IncludesAllKindsNonNamespaced() || filter.matchesKind(f.Resources, target)) && filter.MatchesSelectors(f.Selectors, target)
+}
+
+// ShouldExcludeThisResource checks whether an exclusion matches a resource of the kind in the namespace, or a
+// non-namespaced one when the namespace is empty
+func (filter *Filter) ShouldExcludeThisResource(namespace string, kind string) bool {
+	return filter.ShouldExcludeObject(FilterTarget{Namespace: namespace, Kind: kind})
+}
+
+// ShouldExcludeObject checks whether an exclusion matches the object
+func (filter *Filter) ShouldExcludeObject(target FilterTarget) bool {
+	if target.Namespace == "" {
+		f := filter.NonNamespacedExclusions
+		if len(f.Resources) == 0 {
+			return !f.Selectors.isEmpty() && filter.MatchesSelectors(f.Selectors, target)
+		}
+		return filter.matchesKind(f.Resources, target) && filter.MatchesSelectors(f.Selectors, target)
+	}
+	for _, f := range filter.NamespacedExclusions {
+		// An exclusion without namespaces, resources or selectors would drop everything, which is never what was meant
+		if len(f.Namespaces) == 0 && len(f.Resources) == 0 && f.NamespaceSelector == "" && f.Selectors.isEmpty() {
+			continue
+		}
+		if (len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, target.Namespace)) &&
+			(len(f.Resources) == 0 || filter.matchesKind(f.Resources, target)) &&
+			filter.matchesNamespaceSelector(f.NamespaceSelector, target.NamespaceLabels) && filter.MatchesSelectors(f.Selectors, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludesNamespace checks whether an exclusion drops every resource in the namespace with the labels
+func (filter *Filter) ExcludesNamespace(namespace string, namespaceLabels labels.Labels) bool {
+	for _, f := range filter.NamespacedExclusions {
+		if len(f.Namespaces) == 0 && f.NamespaceSelector == "" {
+			continue
+		}
+		if (len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, namespace)) &&
+			(len(f.Resources) == 0 || utils.Contains(f.Resources, "*")) &&
+			filter.matchesNamespaceSelector(f.NamespaceSelector, namespaceLabels) && f.Selectors.isEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectsNamespace checks whether the namespace with the labels passes the namespace selectors of the inclusions. It is
+// always true when no inclusion has a namespace selector.
+func (filter *Filter) SelectsNamespace(namespace string, namespaceLabels labels.Labels) bool {
+	if !filter.UsesNamespaceSelectors() {
+		return true
+	}
+	for _, f := range filter.NamespacedInclusions {
+		if (len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, namespace)) && filter.matchesNamespaceSelector(f.NamespaceSelector, namespaceLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesNamespaceSelectors checks whether any inclusion or exclusion selects namespaces by their labels
+func (filter *Filter) UsesNamespaceSelectors() bool {
+	for _, f := range filter.NamespacedInclusions {
+		if f.NamespaceSelector != "" {
+			return true
+		}
+	}
+	for _, f := range filter.NamespacedExclusions {
+		if f.NamespaceSelector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSelectors returns the label and field selectors the API server can apply when listing the kind of the resource, so
+// it only returns the objects an inclusion could match. A selector is only returned when every inclusion of the kind
+// has it, since the objects the server leaves out can't be matched by any other inclusion then. Only the kind, group and
+// resource names of the resource are used.
+func (filter *Filter) ListSelectors(namespaced bool, resource FilterTarget) (labelSelector string, fieldSelector string) {
+	var selectors []Selectors
+	if namespaced {
+		for _, f := range filter.NamespacedInclusions {
+			if len(f.Resources) == 0 || filter.matchesKind(f.Resources, resource) {
+				selectors = append(selectors, f.Selectors)
+			}
+		}
+	} else if filter.IncludesAllKindsNonNamespaced() || filter.matchesKind(filter.NonNamespacedInclusions.Resources, resource) {
+		selectors = append(selectors, filter.NonNamespacedInclusions.Selectors)
+	}
+	if len(selectors) == 0 {
+		return "", ""
+	}
+	labelSelector, fieldSelector = selectors[0].LabelSelector, selectors[0].FieldSelector
+	for _, s := range selectors[1:] {
+		if s.LabelSelector != labelSelector {
+			labelSelector = ""
+		}
+		if s.FieldSelector != fieldSelector {
+			fieldSelector = ""
+		}
+	}
+	return labelSelector, fieldSelector
+}
+
+// HasFieldSelector checks whether an inclusion or exclusion that applies to the kind of the resource has a field
+// selector, which may need more than the metadata of its objects
+func (filter *Filter) HasFieldSelector(resource FilterTarget) bool {
+	applies := func(resources []string) bool { return len(resources) == 0 || filter.matchesKind(resources, resource) }
+	for _, f := range filter.NamespacedInclusions {
+		if f.FieldSelector != "" && applies(f.Resources) {
+			return true
+		}
+	}
+	for _, f := range filter.NamespacedExclusions {
+		if f.FieldSelector != "" && applies(f.Resources) {
+			return true
+		}
+	}
+	return (filter.NonNamespacedInclusions.FieldSelector != "" && applies(filter.NonNamespacedInclusions.Resources)) ||
+		(filter.NonNamespacedExclusions.FieldSelector != "" && applies(filter.NonNamespacedExclusions.Resources))
+}
+
+// Validate checks that all the patterns and selectors of the filter compile, and keeps them compiled in the filter so
+// matching the objects doesn't have to. The filter must not be changed afterwards without validating it again.
+func (filter *Filter) Validate() error {
+	compiled := newCompiledFilter()
+	for i, f := range filter.NamespacedInclusions {
+		if err := compiled.compileEntry(f.Selectors, f.NamespaceSelector, f.Namespaces, f.Resources); err != nil {
+			return fmt.Errorf("namespaced inclusion %d: %w", i, err)
+		}
+	}
+	for i, f := range filter.NamespacedExclusions {
+		if err := compiled.compileEntry(f.Selectors, f.NamespaceSelector, f.Namespaces, f.Resources); err != nil {
+			return fmt.Errorf("namespaced exclusion %d: %w", i, err)
+		}
+	}
+	if err := compiled.compileEntry(filter.NonNamespacedInclusions.Selectors, "", filter.NonNamespacedInclusions.Resources); err != nil {
+		return fmt.Errorf("non-namespaced inclusions: %w", err)
+	}
+	if err := compiled.compileEntry(filter.NonNamespacedExclusions.Selectors, "", filter.NonNamespacedExclusions.Resources); err != nil {
+		return fmt.Errorf("non-namespaced exclusions: %w", err)
+	}
+	filter.compiled = compiled
+	return nil
+}
+
+// matchesAny checks whether any of the patterns matches the value
+func (filter *Filter) matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if filter.compiled.matchesPattern(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// includesNamespaced checks the namespaced object against the namespaced inclusions
+func (filter *Filter) includesNamespaced(target FilterTarget) bool {
 	if len(filter.NamespacedInclusions) == 0 {
 		return true
 	}
 	for _, f := range filter.NamespacedInclusions {
-		if f.Namespaces == nil || f.Namespaces[0] == "*" || utils.Contains(f.Namespaces, namespace) {
-			if len(f.Resources) == 0 || f.Resources[0] == "*" || utils.Contains(f.Resources, kind) {
-				return true
+		if len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, target.Namespace) {
+			if len(f.Resources) == 0 || filter.matchesKind(f.Resources, target) {
+				if filter.matchesNamespaceSelector(f.NamespaceSelector, target.NamespaceLabels) && filter.MatchesSelectors(f.Selectors, target) {
+					return true
+				}
 			}
 		}
 	}
 	return false
 }
 
-func (filter *Filter) GetNamespaceList() []string {
+func (s Selectors) isEmpty() bool {
+	return len(s.Names) == 0 && s.LabelSelector == "" && s.FieldSelector == ""
+}
 
-	var namespaces []string
-	for _, f := range filter.NamespacedInclusions {
-		namespaces = append(namespaces, f.Namespaces...)
+// MatchesSelectors checks the name, labels and fields of the object against the selectors
+func (filter *Filter) MatchesSelectors(s Selectors, target FilterTarget) bool {
+	if len(s.Names) > 0 && !filter.matchesAny(s.Names, target.Name) {
+		return false
+	}
+	if s.LabelSelector != "" && !filter.matchesLabelSelector(s.LabelSelector, target.Labels) {
+		return false
+	}
+	if s.FieldSelector != "" {
+		objectFields := target.Fields
+		if objectFields == nil {
+			objectFields = fields.Set{}
+		}
+		if !filter.compiled.fieldSelector(s.FieldSelector).Matches(objectFields) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *Filter) matchesNamespaceSelector(selector string, namespaceLabels labels.Labels) bool {
+	return selector == "" || filter.matchesLabelSelector(selector, namespaceLabels)
+}
+
+func (filter *Filter) matchesLabelSelector(selector string, objectLabels labels.Labels) bool {
+	if objectLabels == nil {
+		objectLabels = labels.Set{}
 	}
-	// If any of the namespaceInclusion filter contains a *, then return empty list so that we get images from all namespaces
-	if utils.Contains(namespaces, "*") {
-		return []string{}
+	return filter.compiled.labelSelector(selector).Matches(objectLabels)
+}
+
+func (filter *Filter) ShouldIncludeThisResource(namespace string, kind string) bool {
+	return filter.includesNamespaced(FilterTarget{Namespace: namespace, Kind: kind})
+}
+
+// GetNamespaceList returns the namespaces the inclusions name, with their patterns expanded against the namespaces found
+// in the cluster. It returns an empty list when an inclusion covers every namespace, so the images are taken from all
+// of them.
+func (filter *Filter) GetNamespaceList(namespaces []string) []string {
+	var namespaceList []string
+	seen := make(map[string]struct{})
+	add := func(namespace string) {
+		if _, exists := seen[namespace]; !exists {
+			seen[namespace] = struct{}{}
+			namespaceList = append(namespaceList, namespace)
+		}
+	}
+	for _, f := range filter.NamespacedInclusions {
+		// If any of the namespaceInclusion filter contains a *, then return empty list so that we get images from all namespaces
+		if len(f.Namespaces) == 0 || utils.Contains(f.Namespaces, "*") {
+			return []string{}
+		}
+		for _, namespace := range f.Namespaces {
+			if !IsPattern(namespace) {
+				add(namespace)
+				continue
+			}
+			for _, found := range namespaces {
+				if filter.compiled.matchesPattern(namespace, found) {
+					add(found)
+				}
+			}
+		}
 	}
-	return namespaces
+	return namespaceList
 }
 
 // IncludesAllKindsNonNamespaced checks if the filter includes all non-namespaced resources.
@@ -51,7 +338,7 @@ func (filter *Filter) GetNamespaceList() []string {
 
 func (filter *Filter) IncludesAllKindsNonNamespaced() bool {
 	f := filter.NonNamespacedInclusions
-	if len(f.Resources) == 0 || f.Resources[0] == "*" {
+	if len(f.Resources) == 0 || utils.Contains(f.Resources, "*") {
 		return true
 	}
 	return false
@@ -4,11 +4,15 @@ import (
 	"cluster-codex/internal/model"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type TestFilterFields struct {
 	NonNamespacedInclusions model.NonNamespacedInclusions
 	NamespacedInclusions    []model.NamespacedInclusion
+	NonNamespacedExclusions model.NonNamespacedExclusions
+	NamespacedExclusions    []model.NamespacedExclusion
 }
 
 var _ = Describe("Filter", Label("unit"), func() {
@@ -68,4 +72,452 @@ var _ = Describe("Filter", Label("unit"), func() {
 		Entry("should return true when resources list contains '*'", []string{"*"}, true),
 		Entry("should return false when resources list contains specific resources", []string{"pod", "service"}, false),
 	)
+
+	DescribeTable("ShouldExcludeThisResource", Label("unit"),
+		func(f TestFilterFields, resourceNamespace string, resourceKind string, want bool) {
+			filter := &model.Filter{
+				NonNamespacedExclusions: f.NonNamespacedExclusions,
+				NamespacedExclusions:    f.NamespacedExclusions,
+			}
+			Expect(filter.ShouldExcludeThisResource(resourceNamespace, resourceKind)).To(Equal(want))
+		},
+		Entry("should return false when there are no exclusions", TestFilterFields{}, "test-ns", "Pod", false),
+		Entry("should return true when the namespace is excluded", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"test-ns"}}},
+		}, "test-ns", "Pod", true),
+		Entry("should return false when another namespace is excluded", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}}},
+		}, "test-ns", "Pod", false),
+		Entry("should return true when the resource is excluded in every namespace", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"Event", "Lease"}}},
+		}, "test-ns", "Lease", true),
+		Entry("should return true when the resource is excluded in the namespace", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"event"}}},
+		}, "kube-system", "Event", true),
+		Entry("should return false when the resource is excluded in another namespace", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"Event"}}},
+		}, "test-ns", "Event", false),
+		Entry("should return true when the namespace and resource are *", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"*"}, Resources: []string{"*"}}},
+		}, "test-ns", "Pod", true),
+		Entry("should return false for an exclusion without namespaces or resources", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{}},
+		}, "test-ns", "Pod", false),
+		Entry("should return false when only a namespaced resource is excluded", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"ClusterRole"}}},
+		}, "", "ClusterRole", false),
+		Entry("should return true when the non-namespaced resource is excluded", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"ClusterRole"}},
+		}, "", "ClusterRole", true),
+		Entry("should return false when the non-namespaced exclusions are empty", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{},
+		}, "", "ClusterRole", false),
+		Entry("should return false for a namespaced resource when non-namespaced resources are excluded", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"*"}},
+		}, "test-ns", "Pod", false),
+	)
+
+	DescribeTable("ShouldInclude", Label("unit"),
+		func(f TestFilterFields, resourceNamespace string, resourceKind string, want bool) {
+			filter := &model.Filter{
+				NonNamespacedInclusions: f.NonNamespacedInclusions,
+				NamespacedInclusions:    f.NamespacedInclusions,
+				NonNamespacedExclusions: f.NonNamespacedExclusions,
+				NamespacedExclusions:    f.NamespacedExclusions,
+			}
+			Expect(filter.ShouldInclude(resourceNamespace, resourceKind)).To(Equal(want))
+		},
+		Entry("should return true when there is no filter", TestFilterFields{}, "test-ns", "Pod", true),
+		Entry("should return true for a non-namespaced resource when there is no filter", TestFilterFields{}, "", "Node", true),
+		Entry("should return false when an included resource is excluded", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"Event"}}},
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"Event"}}},
+		}, "kube-system", "Event", false),
+		Entry("should return false when a resource in an included namespace is excluded", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"*"}}},
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"Lease", "EndpointSlice"}}},
+		}, "kube-system", "EndpointSlice", false),
+		Entry("should return true when another resource in the namespace is excluded", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"Lease"}}},
+		}, "kube-system", "Deployment", true),
+		Entry("should return false when the namespace is not included even though nothing is excluded", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default"}}},
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"kube-system"}}},
+		}, "test-ns", "Pod", false),
+		Entry("should return false when an included non-namespaced resource is excluded", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Node", "ClusterRole"}},
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"ClusterRole"}},
+		}, "", "ClusterRole", false),
+		Entry("should return false when a non-namespaced resource is not included", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Node"}},
+		}, "", "ClusterRole", false),
+		Entry("should return true when a non-namespaced resource is included and another one excluded", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"ClusterRole"}},
+		}, "", "Node", true),
+	)
+
+	DescribeTable("ExcludesNamespace", Label("unit"),
+		func(exclusions []model.NamespacedExclusion, namespace string, want bool) {
+			filter := &model.Filter{NamespacedExclusions: exclusions}
+			Expect(filter.ExcludesNamespace(namespace, nil)).To(Equal(want))
+		},
+		Entry("should return false when there are no exclusions", nil, "kube-system", false),
+		Entry("should return true when the whole namespace is excluded",
+			[]model.NamespacedExclusion{{Namespaces: []string{"kube-system"}}}, "kube-system", true),
+		Entry("should return true when all the resources of the namespace are excluded",
+			[]model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"*"}}}, "kube-system", true),
+		Entry("should return false when only some resources of the namespace are excluded",
+			[]model.NamespacedExclusion{{Namespaces: []string{"kube-system"}, Resources: []string{"Event"}}}, "kube-system", false),
+		Entry("should return false when resources are excluded in every namespace",
+			[]model.NamespacedExclusion{{Resources: []string{"*"}}}, "kube-system", false),
+	)
+
+	DescribeTable("ShouldIncludeObject with selectors", Label("unit"),
+		func(f TestFilterFields, target model.FilterTarget, want bool) {
+			filter := &model.Filter{
+				NonNamespacedInclusions: f.NonNamespacedInclusions,
+				NamespacedInclusions:    f.NamespacedInclusions,
+				NonNamespacedExclusions: f.NonNamespacedExclusions,
+				NamespacedExclusions:    f.NamespacedExclusions,
+			}
+			Expect(filter.ShouldIncludeObject(target)).To(Equal(want))
+		},
+		Entry("should return true when the labels match the label selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "team=payments"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Deployment", Labels: labels.Set{"team": "payments"}}, true),
+		Entry("should return false when the labels do not match the label selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "team in (payments, billing)"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Deployment", Labels: labels.Set{"team": "search"}}, false),
+		Entry("should return false when the object has no labels", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "team=payments"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Deployment"}, false),
+		Entry("should return true when the fields match the field selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"Pod"}, Selectors: model.Selectors{FieldSelector: "status.phase=Running"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Fields: fields.Set{"status.phase": "Running"}}, true),
+		Entry("should return false when the fields do not match the field selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"Pod"}, Selectors: model.Selectors{FieldSelector: "status.phase!=Succeeded"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Fields: fields.Set{"status.phase": "Succeeded"}}, false),
+		Entry("should return true when the namespace labels match the namespace selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"*"}, NamespaceSelector: "team=payments"}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", NamespaceLabels: labels.Set{"team": "payments"}}, true),
+		Entry("should return false when the namespace labels do not match the namespace selector", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"*"}, NamespaceSelector: "team=payments"}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", NamespaceLabels: labels.Set{"team": "search"}}, false),
+		Entry("should return true when another inclusion without selectors matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{
+				{Selectors: model.Selectors{LabelSelector: "team=payments"}},
+				{Namespaces: []string{"shop"}, Resources: []string{"Service"}},
+			},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Service"}, true),
+		Entry("should return false when an exclusion label selector matches", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Selectors: model.Selectors{LabelSelector: "app.kubernetes.io/managed-by=noise"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Labels: labels.Set{"app.kubernetes.io/managed-by": "noise"}}, false),
+		Entry("should return true when an exclusion label selector does not match", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Selectors: model.Selectors{LabelSelector: "app.kubernetes.io/managed-by=noise"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Labels: labels.Set{"app.kubernetes.io/managed-by": "helm"}}, true),
+		Entry("should return false when an exclusion namespace selector matches", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{NamespaceSelector: "env=sandbox"}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", NamespaceLabels: labels.Set{"env": "sandbox"}}, false),
+		Entry("should return true for a non-namespaced resource matching the label selector", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Selectors: model.Selectors{LabelSelector: "team=payments"}},
+		}, model.FilterTarget{Kind: "ClusterRole", Labels: labels.Set{"team": "payments"}}, true),
+		Entry("should return false for a non-namespaced resource excluded by its labels", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Selectors: model.Selectors{LabelSelector: "kubernetes.io/bootstrapping=rbac-defaults"}},
+		}, model.FilterTarget{Kind: "ClusterRole", Labels: labels.Set{"kubernetes.io/bootstrapping": "rbac-defaults"}}, false),
+	)
+
+	DescribeTable("ListSelectors", Label("unit"),
+		func(f TestFilterFields, namespaced bool, kind string, wantLabelSelector string, wantFieldSelector string) {
+			filter := &model.Filter{
+				NonNamespacedInclusions: f.NonNamespacedInclusions,
+				NamespacedInclusions:    f.NamespacedInclusions,
+			}
+			labelSelector, fieldSelector := filter.ListSelectors(namespaced, model.FilterTarget{Kind: kind})
+			Expect(labelSelector).To(Equal(wantLabelSelector))
+			Expect(fieldSelector).To(Equal(wantFieldSelector))
+		},
+		Entry("should return no selectors when there is no filter", TestFilterFields{}, true, "Pod", "", ""),
+		Entry("should return the selectors of the only inclusion", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "team=payments", FieldSelector: "status.phase=Running"}}},
+		}, true, "Pod", "team=payments", "status.phase=Running"),
+		Entry("should return the selectors every inclusion of the kind has", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{
+				{Namespaces: []string{"shop"}, Selectors: model.Selectors{LabelSelector: "team=payments"}},
+				{Namespaces: []string{"billing"}, Selectors: model.Selectors{LabelSelector: "team=payments", FieldSelector: "status.phase=Running"}},
+			},
+		}, true, "Pod", "team=payments", ""),
+		Entry("should return no selectors when an inclusion of the kind has none", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{
+				{Selectors: model.Selectors{LabelSelector: "team=payments"}},
+				{Resources: []string{"Pod"}},
+			},
+		}, true, "Pod", "", ""),
+		Entry("should ignore the inclusions of other kinds", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{
+				{Resources: []string{"Deployment"}, Selectors: model.Selectors{LabelSelector: "team=payments"}},
+				{Resources: []string{"Service"}},
+			},
+		}, true, "Deployment", "team=payments", ""),
+		Entry("should return the selectors of the non-namespaced inclusions", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"ClusterRole"}, Selectors: model.Selectors{LabelSelector: "team=payments"}},
+		}, false, "ClusterRole", "team=payments", ""),
+		Entry("should return no selectors for a non-namespaced kind that is not included", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"ClusterRole"}, Selectors: model.Selectors{LabelSelector: "team=payments"}},
+		}, false, "Node", "", ""),
+	)
+
+	DescribeTable("SelectsNamespace", Label("unit"),
+		func(inclusions []model.NamespacedInclusion, namespaceLabels labels.Set, want bool) {
+			filter := &model.Filter{NamespacedInclusions: inclusions}
+			Expect(filter.SelectsNamespace("shop", namespaceLabels)).To(Equal(want))
+		},
+		Entry("should return true when no inclusion has a namespace selector",
+			[]model.NamespacedInclusion{{Namespaces: []string{"billing"}}}, nil, true),
+		Entry("should return true when the labels match a namespace selector",
+			[]model.NamespacedInclusion{{NamespaceSelector: "team=payments"}}, labels.Set{"team": "payments"}, true),
+		Entry("should return false when the labels match no namespace selector",
+			[]model.NamespacedInclusion{{NamespaceSelector: "team=payments"}}, labels.Set{"team": "search"}, false),
+		Entry("should return true when an inclusion names the namespace",
+			[]model.NamespacedInclusion{{NamespaceSelector: "team=payments"}, {Namespaces: []string{"shop"}}}, nil, true),
+	)
+
+	DescribeTable("Validate", Label("unit"),
+		func(filter model.Filter, wantErr string) {
+			err := filter.Validate()
+			if wantErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(wantErr)))
+			}
+		},
+		Entry("should accept valid selectors", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{NamespaceSelector: "team=payments", Selectors: model.Selectors{LabelSelector: "app in (web, api)", FieldSelector: "status.phase=Running"}}},
+		}, ""),
+		Entry("should reject an invalid label selector", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{LabelSelector: "team in payments"}}},
+		}, `namespaced inclusion 0: invalid label selector "team in payments"`),
+		Entry("should reject an invalid namespace selector", model.Filter{
+			NamespacedExclusions: []model.NamespacedExclusion{{NamespaceSelector: "=sandbox"}},
+		}, `namespaced exclusion 0: invalid label selector "=sandbox"`),
+		Entry("should reject an invalid field selector", model.Filter{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Selectors: model.Selectors{FieldSelector: "metadata.name"}},
+		}, `non-namespaced inclusions: invalid field selector "metadata.name"`),
+	)
+
+	DescribeTable("ShouldIncludeObject with patterns", Label("unit"),
+		func(f TestFilterFields, target model.FilterTarget, want bool) {
+			filter := &model.Filter{
+				NonNamespacedInclusions: f.NonNamespacedInclusions,
+				NamespacedInclusions:    f.NamespacedInclusions,
+				NonNamespacedExclusions: f.NonNamespacedExclusions,
+				NamespacedExclusions:    f.NamespacedExclusions,
+			}
+			Expect(filter.Validate()).To(Succeed())
+			Expect(filter.ShouldIncludeObject(target)).To(Equal(want))
+		},
+		Entry("should return true when a namespace glob matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*"}}},
+		}, model.FilterTarget{Namespace: "team-payments", Kind: "Pod"}, true),
+		Entry("should return false when no namespace glob matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*", "*-system"}}},
+		}, model.FilterTarget{Namespace: "default", Kind: "Pod"}, false),
+		Entry("should return true when a namespace glob matches regardless of case", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"*-SYSTEM"}}},
+		}, model.FilterTarget{Namespace: "kube-system", Kind: "Pod"}, true),
+		Entry("should return true when * is not the first namespace", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default", "*"}}},
+		}, model.FilterTarget{Namespace: "kube-system", Kind: "Pod"}, true),
+		Entry("should return true when a kind regex matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"/^(Deployment|StatefulSet)$/"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "StatefulSet"}, true),
+		Entry("should return false when a kind regex does not match", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"/^(Deployment|StatefulSet)$/"}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "DaemonSet"}, false),
+		Entry("should return true when a name glob matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{Names: []string{"web-?"}}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Name: "web-1"}, true),
+		Entry("should return false when no name matches", TestFilterFields{
+			NamespacedInclusions: []model.NamespacedInclusion{{Selectors: model.Selectors{Names: []string{"web", "api-*"}}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Pod", Name: "web-1"}, false),
+		Entry("should return false when an exclusion name regex matches", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"Secret"}, Selectors: model.Selectors{Names: []string{"/^sh\\.helm\\.release\\./"}}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Secret", Name: "sh.helm.release.v1.web.v3"}, false),
+		Entry("should return true when an exclusion name regex does not match", TestFilterFields{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"Secret"}, Selectors: model.Selectors{Names: []string{"/^sh\\.helm\\.release\\./"}}}},
+		}, model.FilterTarget{Namespace: "shop", Kind: "Secret", Name: "shxhelm-settings"}, true),
+		Entry("should return false when a non-namespaced exclusion glob matches", TestFilterFields{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"Cluster*"}},
+		}, model.FilterTarget{Kind: "ClusterRoleBinding"}, false),
+		Entry("should return true when a non-namespaced inclusion glob matches", TestFilterFields{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Persistent*"}},
+		}, model.FilterTarget{Kind: "PersistentVolume"}, true),
+	)
+
+	It("should match the patterns of a filter that was not validated, and nothing with an invalid one", Label("unit"), func() {
+		filter := &model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*", "/team-(a/"}}}}
+
+		Expect(filter.ShouldIncludeObject(model.FilterTarget{Namespace: "team-a", Kind: "Pod"})).To(BeTrue())
+		Expect(filter.ShouldIncludeObject(model.FilterTarget{Namespace: "default", Kind: "Pod"})).To(BeFalse())
+		Expect(filter.Validate()).ToNot(Succeed())
+	})
+
+	DescribeTable("GetNamespaceList", Label("unit"),
+		func(inclusions []model.NamespacedInclusion, want []string) {
+			filter := &model.Filter{NamespacedInclusions: inclusions}
+			Expect(filter.GetNamespaceList([]string{"default", "kube-system", "team-a", "team-b", "flux-system"})).To(Equal(want))
+		},
+		Entry("should return an empty list when an inclusion covers every namespace",
+			[]model.NamespacedInclusion{{Namespaces: []string{"default"}}, {Namespaces: []string{"*"}}}, []string{}),
+		Entry("should return the named namespaces, even the ones not found",
+			[]model.NamespacedInclusion{{Namespaces: []string{"default", "missing"}}}, []string{"default", "missing"}),
+		Entry("should expand the globs against the namespaces found",
+			[]model.NamespacedInclusion{{Namespaces: []string{"team-*"}}, {Namespaces: []string{"*-system", "default"}}},
+			[]string{"team-a", "team-b", "kube-system", "flux-system", "default"}),
+		Entry("should expand the regular expressions against the namespaces found",
+			[]model.NamespacedInclusion{{Namespaces: []string{"/^(kube|flux)-/"}}}, []string{"kube-system", "flux-system"}),
+		Entry("should list each namespace once",
+			[]model.NamespacedInclusion{{Namespaces: []string{"team-a", "team-*"}}}, []string{"team-a", "team-b"}),
+	)
+
+	DescribeTable("Validate patterns", Label("unit"),
+		func(filter model.Filter, wantErr string) {
+			Expect(filter.Validate()).To(MatchError(ContainSubstring(wantErr)))
+		},
+		Entry("should reject an invalid regular expression", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"/team-(a/"}}},
+		}, `namespaced inclusion 0: invalid pattern "/team-(a/"`),
+		Entry("should reject an invalid glob", model.Filter{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"[Pod"}}},
+		}, `namespaced exclusion 0: invalid pattern "[Pod"`),
+		Entry("should reject an invalid name pattern", model.Filter{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Selectors: model.Selectors{Names: []string{"/(/"}}},
+		}, `non-namespaced exclusions: invalid pattern "/(/"`),
+	)
+
+	DescribeTable("ShouldIncludeObject with resource names", Label("unit"),
+		func(resources []string, target model.FilterTarget, want bool) {
+			filter := &model.Filter{
+				NamespacedInclusions: []model.NamespacedInclusion{{Resources: resources}},
+			}
+			Expect(filter.Validate()).To(Succeed())
+			target.Namespace = "flux-system"
+			Expect(filter.ShouldIncludeObject(target)).To(Equal(want))
+		},
+		Entry("should match the plural", []string{"helmreleases"}, helmRelease, true),
+		Entry("should match the singular", []string{"helmrelease"}, helmRelease, true),
+		Entry("should match a short name", []string{"HR"}, helmRelease, true),
+		Entry("should match the kind qualified by its group", []string{"HelmRelease.helm.toolkit.fluxcd.io"}, helmRelease, true),
+		Entry("should match the plural qualified by its group", []string{"helmreleases.helm.toolkit.fluxcd.io"}, helmRelease, true),
+		Entry("should match a short name qualified by its group", []string{"hr.helm.toolkit.fluxcd.io"}, helmRelease, true),
+		Entry("should match the kind of another group when it is not qualified", []string{"HelmRelease"}, otherHelmRelease, true),
+		Entry("should not match the kind of another group when it is qualified", []string{"HelmRelease.helm.toolkit.fluxcd.io"}, otherHelmRelease, false),
+		Entry("should not match a name of another kind", []string{"helmrepositories"}, helmRelease, false),
+		Entry("should not match a group alone", []string{"helm.toolkit.fluxcd.io"}, helmRelease, false),
+		Entry("should not match a core kind qualified by a group", []string{"pods.apps"}, model.FilterTarget{Kind: "Pod", Resource: model.ResourceNames{Plural: "pods"}}, false),
+		Entry("should match a glob against the kind qualified by its group", []string{"*.toolkit.fluxcd.io"}, helmRelease, true),
+		Entry("should not match a glob against the plural", []string{"helmrel*s"}, helmRelease, false),
+	)
+
+	DescribeTable("ListSelectors with resource names", Label("unit"),
+		func(resources []string, wantLabelSelector string) {
+			filter := &model.Filter{
+				NamespacedInclusions: []model.NamespacedInclusion{{Resources: resources, Selectors: model.Selectors{LabelSelector: "app=web"}}},
+			}
+			labelSelector, _ := filter.ListSelectors(true, helmRelease)
+			Expect(labelSelector).To(Equal(wantLabelSelector))
+		},
+		Entry("should return the selector of an entry naming the plural", []string{"helmreleases"}, "app=web"),
+		Entry("should return the selector of an entry naming a short name", []string{"hr"}, "app=web"),
+		Entry("should return nothing for an entry naming another group", []string{"helmreleases.example.com"}, ""),
+	)
+
+	DescribeTable("UnknownResources", Label("unit"),
+		func(filter model.Filter, want []string) {
+			Expect(filter.UnknownResources([]model.FilterTarget{helmRelease, {Kind: "Pod", Resource: model.ResourceNames{Plural: "pods", Singular: "pod", ShortNames: []string{"po"}}}})).To(Equal(want))
+		},
+		Entry("should return nothing when every resource names a kind", model.Filter{
+			NamespacedInclusions:    []model.NamespacedInclusion{{Resources: []string{"po", "HelmRelease.helm.toolkit.fluxcd.io"}}},
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"pods"}},
+		}, nil),
+		Entry("should skip patterns", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"*", "/^Unknown$/", "Un*"}}},
+		}, nil),
+		Entry("should return each unknown resource once", model.Filter{
+			NamespacedInclusions:    []model.NamespacedInclusion{{Resources: []string{"helmrelease", "HelmReleas"}}, {Resources: []string{"HelmReleas"}}},
+			NamespacedExclusions:    []model.NamespacedExclusion{{Resources: []string{"hr.example.com"}}},
+			NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Node"}},
+		}, []string{"HelmReleas", "hr.example.com", "Node"}),
+	)
+
+	DescribeTable("QueryNamespaces", Label("unit"),
+		func(filter model.Filter, wantNamespaces []string, wantAll bool) {
+			namespaces := []string{"default", "kube-system", "team-a", "team-b"}
+			namespaceLabels := map[string]labels.Set{"team-a": {"team": "payments"}, "team-b": {"team": "search"}}
+			queryNamespaces, all := filter.QueryNamespaces(helmRelease, namespaces, namespaceLabels)
+			Expect(queryNamespaces).To(Equal(wantNamespaces))
+			Expect(all).To(Equal(wantAll))
+		},
+		Entry("should list every namespace without inclusions", model.Filter{}, nil, true),
+		Entry("should list every namespace for an inclusion without namespaces", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default"}}, {Resources: []string{"hr"}}},
+		}, nil, true),
+		Entry("should list every namespace for *", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default", "*"}}},
+		}, nil, true),
+		Entry("should list the namespaces of the inclusions of the kind", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{
+				{Namespaces: []string{"default", "not-in-cluster"}, Resources: []string{"HelmRelease"}},
+				{Namespaces: []string{"kube-system"}, Resources: []string{"Deployment"}},
+				{Namespaces: []string{"default"}},
+			},
+		}, []string{"default", "not-in-cluster"}, false),
+		Entry("should match the namespace patterns against the namespaces in the cluster", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*", "/^kube-/"}}},
+		}, []string{"kube-system", "team-a", "team-b"}, false),
+		Entry("should match the namespace selectors against the labels of the namespaces", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{NamespaceSelector: "team=payments"}},
+		}, []string{"team-a"}, false),
+		Entry("should list nothing when no inclusion applies to the kind", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Resources: []string{"Deployment"}}},
+		}, nil, false),
+		Entry("should leave out the namespaces an exclusion drops the kind in", model.Filter{
+			NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"team-*"}}},
+			NamespacedExclusions: []model.NamespacedExclusion{{Namespaces: []string{"team-b"}}, {Namespaces: []string{"team-a"}, Selectors: model.Selectors{Names: []string{"web"}}}},
+		}, []string{"team-a"}, false),
+		Entry("should list nothing when an exclusion drops the kind everywhere", model.Filter{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"helmreleases"}}},
+		}, nil, false),
+		Entry("should list every namespace when an exclusion only drops some objects of the kind", model.Filter{
+			NamespacedExclusions: []model.NamespacedExclusion{{Resources: []string{"helmreleases"}, Selectors: model.Selectors{LabelSelector: "app!=web"}}},
+		}, nil, true),
+	)
+
+	DescribeTable("QueriesNonNamespaced", Label("unit"),
+		func(filter model.Filter, want bool) {
+			Expect(filter.QueriesNonNamespaced(model.FilterTarget{Kind: "Node", Resource: model.ResourceNames{Plural: "nodes", ShortNames: []string{"no"}}})).To(Equal(want))
+		},
+		Entry("should list a kind without non-namespaced inclusions", model.Filter{}, true),
+		Entry("should list a kind the inclusions name", model.Filter{NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"no"}}}, true),
+		Entry("should not list a kind the inclusions don't name", model.Filter{NonNamespacedInclusions: model.NonNamespacedInclusions{Resources: []string{"Namespace"}}}, false),
+		Entry("should not list a kind an exclusion drops", model.Filter{NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"nodes"}}}, false),
+		Entry("should list a kind an exclusion only drops some objects of", model.Filter{
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Resources: []string{"Node"}, Selectors: model.Selectors{Names: []string{"control-*"}}},
+		}, true),
+	)
 })
+
+var helmRelease = model.FilterTarget{
+	Kind:  "HelmRelease",
+	Group: "helm.toolkit.fluxcd.io",
+	Resource: model.ResourceNames{
+		Plural:     "helmreleases",
+		Singular:   "helmrelease",
+		ShortNames: []string{"hr"},
+	},
+}
+
+var otherHelmRelease = model.FilterTarget{
+	Kind:     "HelmRelease",
+	Group:    "apps.example.com",
+	Resource: model.ResourceNames{Plural: "helmreleases", Singular: "helmrelease"},
+}
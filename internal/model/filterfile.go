@@ -0,0 +1,182 @@
+package model
+
+import (
+	"bytes"
+	"cluster-codex/internal/utils"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FilterSchema is the JSON Schema of the filter files, published for editors and other tools. The filter loader does
+// not read it: a filter is held to the fields and types of Filter, which the schema documents.
+//
+//go:embed filter.schema.json
+var FilterSchema []byte
+
+// filterFile is the document of a filter file, which may name its schema for editors
+type filterFile struct {
+	Schema string `yaml:"$schema"`
+	Filter `yaml:",inline"`
+}
+
+// FilterError is a mistake in a filter file, at the line and column it was found. The column is 0 when only the line is
+// known, and both are 0 for the mistakes found after decoding, e.g. an invalid pattern.
+type FilterError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e FilterError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// LoadFilter reads the YAML or JSON filter file
+func LoadFilter(path string) (Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Filter{}, fmt.Errorf("failed to read filter: %w", err)
+	}
+	return DecodeFilter(path, data)
+}
+
+// DecodeFilter decodes a YAML or JSON filter, named file in the errors. Unknown fields and values of the wrong type are
+// all returned as FilterErrors, and then the patterns and selectors are compiled by Filter.Validate.
+func DecodeFilter(file string, data []byte) (Filter, error) {
+	// YAML accepts what JSON doesn't, e.g. trailing commas, so a JSON file is held to JSON
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		if err := checkJSONSyntax(file, data); err != nil {
+			return Filter{}, err
+		}
+	}
+	var document filterFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// An empty file is an empty filter
+	if err := decoder.Decode(&document); err != nil && !errors.Is(err, io.EOF) {
+		return Filter{}, decodeErrors(file, data, err)
+	}
+	if err := document.Filter.Validate(); err != nil {
+		return Filter{}, FilterError{File: file, Message: err.Error()}
+	}
+	return document.Filter, nil
+}
+
+// The messages of yaml.v3 that decodeErrors gives a position and a hint
+var (
+	yamlErrorLine       = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownField    = regexp.MustCompile(`^field (\S+) not found in type (\S+)$`)
+	yamlUnmarshalString = regexp.MustCompile("^cannot unmarshal !!\\w+ `(.*)` into (\\S+)$")
+)
+
+// decodeErrors turns the errors of yaml.v3, which only name the line, into FilterErrors with the column of the field or
+// value on that line
+func decodeErrors(file string, data []byte, err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+	lines := strings.Split(string(data), "\n")
+	errs := make([]error, 0, len(messages))
+	for _, message := range messages {
+		match := yamlErrorLine.FindStringSubmatch(message)
+		if match == nil {
+			errs = append(errs, fmt.Errorf("%s: %s", file, message))
+			continue
+		}
+		filterErr := FilterError{File: file, Message: match[2]}
+		filterErr.Line, _ = strconv.Atoi(match[1])
+		token := ""
+		if field := yamlUnknownField.FindStringSubmatch(filterErr.Message); field != nil {
+			token = field[1]
+			filterErr.Message = fmt.Sprintf("unknown field %q", field[1])
+			if suggestion := suggestField(field[1], filterFields()[field[2]]); suggestion != "" {
+				filterErr.Message += ", " + suggestion
+			}
+		} else if value := yamlUnmarshalString.FindStringSubmatch(filterErr.Message); value != nil {
+			token = value[1]
+		}
+		if token != "" && filterErr.Line <= len(lines) {
+			if idx := strings.Index(lines[filterErr.Line-1], token); idx >= 0 {
+				filterErr.Column = idx + 1
+			}
+		}
+		errs = append(errs, filterErr)
+	}
+	return errors.Join(errs...)
+}
+
+// filterFields are the fields of the types in a filter file, by the type names yaml.v3 reports
+var filterFields = sync.OnceValue(func() map[string][]string {
+	fields := make(map[string][]string)
+	for _, value := range []interface{}{filterFile{}, NamespacedInclusion{}, NonNamespacedInclusions{}, NamespacedExclusion{}, NonNamespacedExclusions{}} {
+		fileType := reflect.TypeOf(value)
+		fields[fileType.String()] = yamlFields(fileType)
+	}
+	return fields
+})
+
+// yamlFields returns the YAML names of the fields of the struct, including the ones of the inlined structs
+func yamlFields(structType reflect.Type) []string {
+	var names []string
+	for i := 0; i < structType.NumField(); i++ {
+		name, options, _ := strings.Cut(structType.Field(i).Tag.Get("yaml"), ",")
+		if options == "inline" {
+			names = append(names, yamlFields(structType.Field(i).Type)...)
+		} else if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkJSONSyntax returns the syntax error in the JSON document, if any, with its position
+func checkJSONSyntax(file string, data []byte) error {
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(data, new(json.RawMessage)); !errors.As(err, &syntaxErr) {
+		return nil
+	}
+	// The offset is just past the character that was not expected
+	before := data[:max(syntaxErr.Offset-1, 0)]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return FilterError{File: file, Line: line, Column: column, Message: syntaxErr.Error()}
+}
+
+// suggestField returns the known field closest to the unknown one, or all of them when none is close
+func suggestField(field string, known []string) string {
+	if len(known) == 0 {
+		return ""
+	}
+	known = slices.Sorted(slices.Values(known))
+	closest, distance := "", 4
+	for _, name := range known {
+		if d := utils.EditDistance(strings.ToLower(field), strings.ToLower(name)); d < distance {
+			closest, distance = name, d
+		}
+	}
+	if closest != "" {
+		return fmt.Sprintf("did you mean %q?", closest)
+	}
+	return "expected one of: " + strings.Join(known, ", ")
+}
@@ -0,0 +1,157 @@
+package model_test
+
+import (
+	"cluster-codex/internal/model"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DecodeFilter", Label("unit"), func() {
+	// fields returns the filter as written in the file, without the patterns and selectors DecodeFilter compiled
+	fields := func(filter model.Filter) model.Filter {
+		return model.Filter{
+			NonNamespacedInclusions: filter.NonNamespacedInclusions,
+			NamespacedInclusions:    filter.NamespacedInclusions,
+			NonNamespacedExclusions: filter.NonNamespacedExclusions,
+			NamespacedExclusions:    filter.NamespacedExclusions,
+		}
+	}
+
+	It("should decode every field of a YAML filter", func() {
+		filter, err := model.DecodeFilter("filter.yaml", []byte(`
+$schema: ./filter.schema.json
+non-namespaced-inclusions:
+  resources: [Namespace]
+  labelSelector: tier=platform
+namespaced-inclusions:
+  - namespaces: [team-*]
+    resources: [deploy, HelmRelease.helm.toolkit.fluxcd.io]
+    namespaceSelector: team=payments
+    names: [web-*]
+    fieldSelector: metadata.name!=canary
+non-namespaced-exclusions:
+  names: [/^system:/]
+namespaced-exclusions:
+  - resources: [Event]
+`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields(filter)).To(Equal(model.Filter{
+			NonNamespacedInclusions: model.NonNamespacedInclusions{
+				Resources: []string{"Namespace"},
+				Selectors: model.Selectors{LabelSelector: "tier=platform"},
+			},
+			NamespacedInclusions: []model.NamespacedInclusion{{
+				Namespaces:        []string{"team-*"},
+				Resources:         []string{"deploy", "HelmRelease.helm.toolkit.fluxcd.io"},
+				NamespaceSelector: "team=payments",
+				Selectors:         model.Selectors{Names: []string{"web-*"}, FieldSelector: "metadata.name!=canary"},
+			}},
+			NonNamespacedExclusions: model.NonNamespacedExclusions{Selectors: model.Selectors{Names: []string{"/^system:/"}}},
+			NamespacedExclusions:    []model.NamespacedExclusion{{Resources: []string{"Event"}}},
+		}))
+	})
+
+	It("should decode a JSON filter the same as before", func() {
+		filter, err := model.DecodeFilter("filter.json", []byte(`{
+	"namespaced-inclusions": [{"namespaces": ["test-ns", "*"], "resources": ["HelmRelease"]}]
+}`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter.NamespacedInclusions).To(Equal([]model.NamespacedInclusion{{Namespaces: []string{"test-ns", "*"}, Resources: []string{"HelmRelease"}}}))
+	})
+
+	It("should decode an empty file as an empty filter", func() {
+		filter, err := model.DecodeFilter("filter.yaml", nil)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields(filter)).To(Equal(model.Filter{}))
+	})
+
+	It("should decode the null fields as empty, the same as before", func() {
+		filter, err := model.DecodeFilter("filter.yaml", []byte(`namespaced-inclusions:
+  - namespaces: [default]
+    resources:
+    labelSelector: null
+non-namespaced-inclusions: ~
+namespaced-exclusions: null
+`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields(filter)).To(Equal(model.Filter{NamespacedInclusions: []model.NamespacedInclusion{{Namespaces: []string{"default"}}}}))
+
+		filter, err = model.DecodeFilter("filter.json", []byte(`null`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields(filter)).To(Equal(model.Filter{}))
+	})
+
+	It("should report every mistake with its position", func() {
+		_, err := model.DecodeFilter("filter.yaml", []byte(`namespaced-inclusions:
+  - resources: [Pod]
+    nmes: [web]
+namespaced-exclusions:
+  - resources: Pod
+`))
+
+		Expect(err).To(MatchError(
+			"filter.yaml:3:5: unknown field \"nmes\", did you mean \"names\"?\n" +
+				"filter.yaml:5:16: cannot unmarshal !!str `Pod` into []string"))
+	})
+
+	It("should load the sample filter without unknown resources", func() {
+		filter, err := model.LoadFilter("../../sample-filter.json")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter.UnknownResources([]model.FilterTarget{{Kind: "HelmChart", Resource: model.ResourceNames{Plural: "helmcharts", Singular: "helmchart"}}})).To(BeEmpty())
+	})
+
+	It("should accept every field the schema documents", func() {
+		var schema struct {
+			Properties map[string]interface{} `json:"properties"`
+			Defs       map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"$defs"`
+		}
+		Expect(json.Unmarshal(model.FilterSchema, &schema)).To(Succeed())
+
+		for field := range schema.Properties {
+			_, err := model.DecodeFilter("filter.yaml", []byte(field+": null\n"))
+			Expect(err).ToNot(HaveOccurred(), field)
+		}
+		for field := range schema.Defs["namespacedEntry"].Properties {
+			_, err := model.DecodeFilter("filter.yaml", []byte("namespaced-inclusions:\n  - "+field+": null\n"))
+			Expect(err).ToNot(HaveOccurred(), field)
+		}
+		for field := range schema.Defs["nonNamespacedEntry"].Properties {
+			_, err := model.DecodeFilter("filter.yaml", []byte("non-namespaced-exclusions:\n  "+field+": null\n"))
+			Expect(err).ToNot(HaveOccurred(), field)
+		}
+	})
+
+	DescribeTable("should reject", func(file string, data string, wantErr string) {
+		_, err := model.DecodeFilter(file, []byte(data))
+
+		Expect(err).To(MatchError(wantErr))
+	},
+		Entry("a misspelled top-level field", "filter.yaml", "namespace-inclusions:\n  - namespaces: [default]\n",
+			`filter.yaml:1:1: unknown field "namespace-inclusions", did you mean "namespaced-inclusions"?`),
+		Entry("a field that is nothing like a known one", "filter.yaml", "non-namespaced-inclusions:\n  kinds: [Node]\n",
+			`filter.yaml:2:3: unknown field "kinds", expected one of: fieldSelector, labelSelector, names, resources`),
+		Entry("a duplicate field", "filter.yaml", "non-namespaced-inclusions:\n  resources: [Node]\n  resources: [Namespace]\n",
+			`filter.yaml:3: mapping key "resources" already defined at line 2`),
+		Entry("an inclusion that is not a list", "filter.yaml", "namespaced-inclusions:\n  namespaces: [default]\n",
+			`filter.yaml:2: cannot unmarshal !!map into []model.NamespacedInclusion`),
+		Entry("an invalid pattern", "filter.yaml", "namespaced-inclusions:\n  - resources: [\"[Pod\"]\n",
+			`filter.yaml: namespaced inclusion 0: invalid pattern "[Pod": syntax error in pattern`),
+		Entry("an invalid label selector", "filter.yaml", "namespaced-exclusions:\n  - namespaceSelector: 'team in (a'\n",
+			`filter.yaml: namespaced exclusion 0: invalid label selector "team in (a": unable to parse requirement: found '', expected: ',' or ')'`),
+		Entry("an invalid field selector", "filter.yaml", "non-namespaced-exclusions:\n  fieldSelector: metadata.name\n",
+			`filter.yaml: non-namespaced exclusions: invalid field selector "metadata.name": invalid selector: 'metadata.name'; can't understand 'metadata.name'`),
+		Entry("a trailing comma in a JSON file", "filter.json", "{\n  \"namespaced-inclusions\": [],\n}",
+			`filter.json:3:1: invalid character '}' looking for beginning of object key string`),
+		Entry("a YAML syntax error", "filter.yaml", "namespaced-inclusions: [\n",
+			`filter.yaml:1: did not find expected node content`),
+	)
+})
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern matches the namespaces, kinds or names of objects. In a filter it is written as a regular expression between
+// slashes, e.g. /^team-(a|b)$/, a glob like team-* or *-system, or a plain name. All of them ignore case.
+type pattern func(value string) bool
+
+// IsRegexPattern checks whether the value is a regular expression between slashes
+func IsRegexPattern(value string) bool {
+	return len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/")
+}
+
+// IsPattern checks whether the value is a regular expression or a glob rather than a plain name
+func IsPattern(value string) bool {
+	return IsRegexPattern(value) || strings.ContainsAny(value, "*?[")
+}
+
+func compilePattern(value string) (pattern, error) {
+	switch {
+	case IsRegexPattern(value):
+		expression, err := regexp.Compile("(?i)" + value[1:len(value)-1])
+		if err != nil {
+			return nil, err
+		}
+		return expression.MatchString, nil
+	case IsPattern(value):
+		glob := strings.ToLower(value)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+		return func(s string) bool {
+			matched, _ := path.Match(glob, strings.ToLower(s))
+			return matched
+		}, nil
+	}
+	return func(s string) bool { return strings.EqualFold(value, s) }, nil
+}
+
+// compiledFilter holds the patterns and selectors of a Filter by their text, compiled by Validate since the same few of
+// them are matched against every object. It is only read once Validate returns, so the workers can share it. The
+// patterns and selectors of a Filter that was not validated are compiled each time they are matched, and the ones that
+// do not compile match nothing.
+type compiledFilter struct {
+	patterns       map[string]pattern
+	labelSelectors map[string]labels.Selector
+	fieldSelectors map[string]fields.Selector
+}
+
+func newCompiledFilter() compiledFilter {
+	return compiledFilter{
+		patterns:       make(map[string]pattern),
+		labelSelectors: make(map[string]labels.Selector),
+		fieldSelectors: make(map[string]fields.Selector),
+	}
+}
+
+func (c compiledFilter) matchesPattern(value string, s string) bool {
+	if compiled, found := c.patterns[value]; found {
+		return compiled(s)
+	}
+	compiled, err := compilePattern(value)
+	return err == nil && compiled(s)
+}
+
+func (c compiledFilter) labelSelector(selector string) labels.Selector {
+	if parsed, found := c.labelSelectors[selector]; found {
+		return parsed
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return labels.Nothing()
+	}
+	return parsed
+}
+
+func (c compiledFilter) fieldSelector(selector string) fields.Selector {
+	if parsed, found := c.fieldSelectors[selector]; found {
+		return parsed
+	}
+	parsed, err := fields.ParseSelector(selector)
+	if err != nil {
+		return fields.Nothing()
+	}
+	return parsed
+}
+
+// compileEntry compiles the patterns and selectors of a single inclusion or exclusion
+func (c compiledFilter) compileEntry(selectors Selectors, namespaceSelector string, patternLists ...[]string) error {
+	for _, values := range append(patternLists, selectors.Names) {
+		for _, value := range values {
+			compiled, err := compilePattern(value)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", value, err)
+			}
+			c.patterns[value] = compiled
+		}
+	}
+	for _, selector := range []string{selectors.LabelSelector, namespaceSelector} {
+		if selector == "" {
+			continue
+		}
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		c.labelSelectors[selector] = parsed
+	}
+	if selectors.FieldSelector != "" {
+		parsed, err := fields.ParseSelector(selectors.FieldSelector)
+		if err != nil {
+			return fmt.Errorf("invalid field selector %q: %w", selectors.FieldSelector, err)
+		}
+		c.fieldSelectors[selectors.FieldSelector] = parsed
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"cluster-codex/internal/utils"
+	"k8s.io/apimachinery/pkg/labels"
+	"slices"
+)
+
+// QueryNamespaces returns the namespaces an inclusion could include objects of the namespaced kind of the resource in,
+// so only those need to be listed. The patterns and namespace selectors of the inclusions are matched against the
+// namespaces found in the cluster and their labels. all is true when an inclusion covers every namespace, and the
+// namespaces are nil when no inclusion applies to the kind or an exclusion drops all of it. Only the kind, group and
+// resource names of the resource are used.
+func (filter *Filter) QueryNamespaces(resource FilterTarget, namespaces []string, namespaceLabels map[string]labels.Set) (queryNamespaces []string, all bool) {
+	if filter.excludesKind(resource, func(f NamespacedExclusion) bool { return len(f.Namespaces) == 0 && f.NamespaceSelector == "" }) {
+		return nil, false
+	}
+	if len(filter.NamespacedInclusions) == 0 {
+		return nil, true
+	}
+	add := func(namespace string) {
+		if !slices.Contains(queryNamespaces, namespace) {
+			queryNamespaces = append(queryNamespaces, namespace)
+		}
+	}
+	for _, f := range filter.NamespacedInclusions {
+		if len(f.Resources) > 0 && !filter.matchesKind(f.Resources, resource) {
+			continue
+		}
+		if f.NamespaceSelector == "" && (len(f.Namespaces) == 0 || utils.Contains(f.Namespaces, "*")) {
+			return nil, true
+		}
+		for _, namespace := range f.Namespaces {
+			if !IsPattern(namespace) && f.NamespaceSelector == "" {
+				add(namespace)
+			}
+		}
+		for _, namespace := range namespaces {
+			if (len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, namespace)) && filter.matchesNamespaceSelector(f.NamespaceSelector, namespaceLabels[namespace]) {
+				add(namespace)
+			}
+		}
+	}
+	// Namespace labels are nil when unknown, so a selector matching the absence of a label doesn't drop a namespace here
+	return slices.DeleteFunc(queryNamespaces, func(namespace string) bool {
+		return filter.excludesKind(resource, func(f NamespacedExclusion) bool {
+			return (len(f.Namespaces) == 0 || filter.matchesAny(f.Namespaces, namespace)) &&
+				(f.NamespaceSelector == "" || (namespaceLabels[namespace] != nil && filter.matchesNamespaceSelector(f.NamespaceSelector, namespaceLabels[namespace])))
+		})
+	}), false
+}
+
+// QueriesNonNamespaced checks whether the non-namespaced inclusions could include objects of the non-namespaced kind of
+// the resource, and no exclusion drops all of it, so it needs to be listed. Only the kind, group and resource names of
+// the resource are used.
+func (filter *Filter) QueriesNonNamespaced(resource FilterTarget) bool {
+	if f := filter.NonNamespacedExclusions; f.Selectors.isEmpty() && filter.matchesKind(f.Resources, resource) {
+		return false
+	}
+	return filter.IncludesAllKindsNonNamespaced() || filter.matchesKind(filter.NonNamespacedInclusions.Resources, resource)
+}
+
+// excludesKind checks whether a namespaced exclusion of the kind of the resource, without selectors, applies
+func (filter *Filter) excludesKind(resource FilterTarget, applies func(f NamespacedExclusion) bool) bool {
+	for _, f := range filter.NamespacedExclusions {
+		if len(f.Resources) == 0 && len(f.Namespaces) == 0 && f.NamespaceSelector == "" {
+			continue
+		}
+		if f.Selectors.isEmpty() && (len(f.Resources) == 0 || filter.matchesKind(f.Resources, resource)) && applies(f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"slices"
+	"strings"
+)
+
+// ResourceNames are the names discovery lists for a kind besides the kind itself, e.g. helmreleases, helmrelease and hr
+// for HelmRelease. The filter matches the resources by any of them.
+type ResourceNames struct {
+	Plural     string
+	Singular   string
+	ShortNames []string
+}
+
+// matchesKind checks whether any of the resources of a filter entry refers to the kind of the target. A plain resource
+// is its kind, plural, singular or short name, ignoring case, optionally followed by its group to tell apart the kinds
+// two groups share, e.g. HelmRelease.helm.toolkit.fluxcd.io or helmreleases.helm.toolkit.fluxcd.io. A glob or a regular
+// expression is matched against the kind and against the kind followed by its group, e.g. *.toolkit.fluxcd.io.
+func (filter *Filter) matchesKind(resources []string, target FilterTarget) bool {
+	for _, resource := range resources {
+		if IsPattern(resource) {
+			if filter.compiled.matchesPattern(resource, target.Kind) || (target.Group != "" && filter.compiled.matchesPattern(resource, target.Kind+"."+target.Group)) {
+				return true
+			}
+		} else if matchesResourceName(resource, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesResourceName checks whether the plain resource names the kind of the target
+func matchesResourceName(resource string, target FilterTarget) bool {
+	name := resource
+	if group := target.Group; group != "" && len(resource) > len(group)+1 &&
+		resource[len(resource)-len(group)-1] == '.' && strings.EqualFold(resource[len(resource)-len(group):], group) {
+		name = resource[:len(resource)-len(group)-1]
+	}
+	if name == "" {
+		return false
+	}
+	names := target.Resource
+	return strings.EqualFold(name, target.Kind) || strings.EqualFold(name, names.Plural) || strings.EqualFold(name, names.Singular) ||
+		slices.ContainsFunc(names.ShortNames, func(shortName string) bool { return strings.EqualFold(name, shortName) })
+}
+
+// UnknownResources returns the plain resources of the filter that name none of the kinds, so a misspelled kind doesn't
+// silently match nothing. Only the kind, group and resource names of the kinds are used.
+func (filter *Filter) UnknownResources(kinds []FilterTarget) []string {
+	var unknown []string
+	check := func(resources []string) {
+		for _, resource := range resources {
+			if IsPattern(resource) || slices.Contains(unknown, resource) {
+				continue
+			}
+			if !slices.ContainsFunc(kinds, func(kind FilterTarget) bool { return matchesResourceName(resource, kind) }) {
+				unknown = append(unknown, resource)
+			}
+		}
+	}
+	for _, f := range filter.NamespacedInclusions {
+		check(f.Resources)
+	}
+	for _, f := range filter.NamespacedExclusions {
+		check(f.Resources)
+	}
+	check(filter.NonNamespacedInclusions.Resources)
+	check(filter.NonNamespacedExclusions.Resources)
+	return unknown
+}
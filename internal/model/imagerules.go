@@ -0,0 +1,16 @@
+package model
+
+// ImageRules map kinds of custom resources to the JSONPath expressions that yield the image references in their objects
+type ImageRules struct {
+	Rules []ImageRule `json:"rules"`
+}
+
+// ImageRule finds the images in the objects of a kind, e.g. the containers of a Knative Service
+type ImageRule struct {
+	Group string `json:"group"`
+	// Version is the API version of the objects the rule applies to, or empty for every version
+	Version string `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+	// Paths are JSONPath expressions like {.spec.template.spec.containers[*].image}. The braces are optional.
+	Paths []string `json:"paths"`
+}
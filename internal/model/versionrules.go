@@ -0,0 +1,21 @@
+package model
+
+// VersionRules say where the version of the objects of a kind is found, since there is no standard way of setting it
+type VersionRules struct {
+	Rules []VersionRule `json:"rules"`
+}
+
+// VersionRule reads the version of the objects of a kind from a JSONPath expression, a label or an annotation. Exactly
+// one of Path, Label and Annotation is set.
+type VersionRule struct {
+	// Group is the API group of the objects the rule applies to, or * for every group
+	Group string `json:"group"`
+	// Version is the API version of the objects the rule applies to, or empty for every version
+	Version string `json:"version,omitempty"`
+	// Kind is the kind of the objects the rule applies to, or * for every kind
+	Kind string `json:"kind"`
+	// Path is a JSONPath expression like {.spec.chart.spec.version}. The braces are optional.
+	Path       string `json:"path,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Annotation string `json:"annotation,omitempty"`
+}
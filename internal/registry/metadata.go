@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"cluster-codex/internal/model"
+	"context"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+)
+
+// Pre-defined OCI annotation keys, see https://github.com/opencontainers/image-spec/blob/main/annotations.md. They are
+// also commonly set as labels of the image config.
+const (
+	annotationPrefix        = "org.opencontainers.image."
+	AnnotationSource        = annotationPrefix + "source"
+	AnnotationRevision      = annotationPrefix + "revision"
+	AnnotationVersion       = annotationPrefix + "version"
+	AnnotationLicenses      = annotationPrefix + "licenses"
+	AnnotationVendor        = annotationPrefix + "vendor"
+	AnnotationCreated       = annotationPrefix + "created"
+	AnnotationURL           = annotationPrefix + "url"
+	AnnotationDocumentation = annotationPrefix + "documentation"
+	AnnotationBaseName      = annotationPrefix + "base.name"
+	AnnotationBaseDigest    = annotationPrefix + "base.digest"
+)
+
+// DefaultPlatform is the platform whose image is read from a multi-platform index
+var DefaultPlatform = v1.Platform{OS: "linux", Architecture: "amd64"}
+
+// ImageMetadata holds the OCI annotations of an image, from the labels of its config and the annotations of its
+// manifest and index
+type ImageMetadata map[string]string
+
+// Metadata returns the OCI annotations of the image. Annotations of the manifest take precedence over those of the
+// index, which take precedence over the labels of the image config.
+func (r *Resolver) Metadata(ctx context.Context, image string) (ImageMetadata, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	metadata, found := r.cache.Metadata[ref.Name()]
+	r.lock.Unlock()
+	if found {
+		return metadata, nil
+	}
+
+	metadata, err = r.fetchMetadata(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// Only images pinned by digest are cached, since a tag can be moved to another image
+	if _, isDigest := ref.(name.Digest); isDigest {
+		r.lock.Lock()
+		r.cache.Metadata[ref.Name()] = metadata
+		r.dirty = true
+		r.lock.Unlock()
+	}
+	return metadata, nil
+}
+
+func (r *Resolver) fetchMetadata(ctx context.Context, ref name.Reference) (ImageMetadata, error) {
+	options := append([]remote.Option{remote.WithContext(ctx), remote.WithPlatform(r.Platform)}, r.options...)
+	descriptor, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := ImageMetadata{}
+	var indexAnnotations map[string]string
+	if descriptor.MediaType.IsIndex() {
+		index, err := descriptor.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		indexAnnotations = indexManifest.Annotations
+	}
+	img, err := descriptor.Image()
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range []map[string]string{config.Config.Labels, indexAnnotations, manifest.Annotations} {
+		for key, value := range source {
+			if strings.HasPrefix(key, annotationPrefix) && value != "" {
+				metadata[key] = value
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// Enrich adds the licenses, supplier, external references and OCI properties of each image component from the
+// metadata of the image in its registry. Images whose metadata cannot be read are left as they are.
+func (r *Resolver) Enrich(ctx context.Context, components []model.Component) {
+	var images []string
+	for _, component := range components {
+		if component.Type == "container" {
+			images = append(images, imageReference(component))
+		}
+	}
+
+	results := make(map[string]ImageMetadata, len(images))
+	var resultsLock sync.Mutex
+	r.forEach(ctx, images, func(image string) {
+		metadata, err := r.Metadata(ctx, image)
+		if err != nil {
+			log.Warn().Msgf("Could not read metadata of image %s: %v", image, err)
+			return
+		}
+		resultsLock.Lock()
+		defer resultsLock.Unlock()
+		results[image] = metadata
+	})
+
+	for idx := range components {
+		if components[idx].Type != "container" {
+			continue
+		}
+		if metadata, found := results[imageReference(components[idx])]; found {
+			applyMetadata(&components[idx], metadata)
+		}
+	}
+}
+
+// imageReference returns the image of the component, pinned by digest when it is known
+func imageReference(component model.Component) string {
+	for _, hash := range component.Hashes {
+		if hash.Algorithm == "SHA-256" {
+			return fmt.Sprintf("%s@sha256:%s", component.Name, hash.Value)
+		}
+	}
+	if strings.HasPrefix(component.Version, "sha256:") {
+		return fmt.Sprintf("%s@%s", component.Name, component.Version)
+	}
+	return fmt.Sprintf("%s:%s", component.Name, component.Version)
+}
+
+// applyMetadata maps the OCI annotations onto the CycloneDX fields of the component
+func applyMetadata(component *model.Component, metadata ImageMetadata) {
+	if licenses, found := metadata[AnnotationLicenses]; found {
+		// The OCI annotation is an SPDX license expression
+		component.Licenses = []model.License{{Expression: licenses}}
+	}
+	if vendor, found := metadata[AnnotationVendor]; found {
+		component.Supplier = &model.OrganizationalEntity{Name: vendor}
+	}
+
+	component.ExternalReferences = nil
+	for _, reference := range []struct {
+		annotation    string
+		referenceType string
+	}{
+		{AnnotationSource, "vcs"},
+		{AnnotationURL, "website"},
+		{AnnotationDocumentation, "documentation"},
+	} {
+		if url, found := metadata[reference.annotation]; found {
+			component.ExternalReferences = append(component.ExternalReferences, model.ExternalReference{URL: url, Type: reference.referenceType})
+		}
+	}
+
+	for annotation, property := range map[string]string{
+		AnnotationRevision:   model.ImageRevision,
+		AnnotationVersion:    model.ImageVersion,
+		AnnotationCreated:    model.ImageCreated,
+		AnnotationBaseName:   model.ImageBaseName,
+		AnnotationBaseDigest: model.ImageBaseDigest,
+	} {
+		if value, found := metadata[annotation]; found {
+			component.AddProperty(property, value)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package registry_test
+
+import (
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/registry"
+	"context"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Image metadata - Unit", Label("unit"), func() {
+	var (
+		registryServer *httptest.Server
+		registryHost   string
+		cacheDir       string
+	)
+
+	// pushImage pushes a random image with the config labels and manifest annotations and returns its digest
+	pushImage := func(image string, labels map[string]string, annotations map[string]string) v1.Hash {
+		img, err := random.Image(256, 1)
+		Expect(err).ToNot(HaveOccurred())
+		config, err := img.ConfigFile()
+		Expect(err).ToNot(HaveOccurred())
+		config.Config.Labels = labels
+		img, err = mutate.ConfigFile(img, config)
+		Expect(err).ToNot(HaveOccurred())
+		img = mutate.Annotations(img, annotations).(v1.Image)
+		ref, err := name.ParseReference(image)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remote.Write(ref, img)).To(Succeed())
+		digest, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		return digest
+	}
+
+	newImageComponent := func(image string, version string, digest *v1.Hash) model.Component {
+		component := model.Component{Type: "container", Name: image, Version: version}
+		if digest != nil {
+			component.Hashes = []model.Hash{{Algorithm: "SHA-256", Value: digest.Hex}}
+		}
+		component.AddProperty(model.ComponentKind, "Image")
+		return component
+	}
+
+	property := func(component model.Component, name string) string {
+		value, _ := component.GetProperty(name)
+		return value
+	}
+
+	BeforeEach(func() {
+		registryServer = httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+		DeferCleanup(registryServer.Close)
+		registryHost = strings.TrimPrefix(registryServer.URL, "http://")
+		cacheDir = GinkgoT().TempDir()
+	})
+
+	It("should map the OCI labels and annotations onto the image component", func() {
+		digest := pushImage(registryHost+"/acme/api:2.1.0", map[string]string{
+			registry.AnnotationSource:   "https://github.com/acme/api",
+			registry.AnnotationRevision: "0123456789abcdef",
+			registry.AnnotationLicenses: "Apache-2.0 OR MIT",
+			registry.AnnotationVendor:   "Acme Corp",
+			registry.AnnotationCreated:  "2024-01-02T03:04:05Z",
+			registry.AnnotationBaseName: "docker.io/library/alpine:3.20",
+			"maintainer":                "ops@acme.example",
+		}, map[string]string{
+			registry.AnnotationVersion: "2.1.0",
+			registry.AnnotationURL:     "https://acme.example/api",
+		})
+		resolver, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		components := []model.Component{newImageComponent(registryHost+"/acme/api", "2.1.0", &digest)}
+
+		resolver.Enrich(context.Background(), components)
+
+		api := components[0]
+		Expect(api.Licenses).To(Equal([]model.License{{Expression: "Apache-2.0 OR MIT"}}))
+		Expect(api.Supplier).To(Equal(&model.OrganizationalEntity{Name: "Acme Corp"}))
+		Expect(api.ExternalReferences).To(Equal([]model.ExternalReference{
+			{URL: "https://github.com/acme/api", Type: "vcs"},
+			{URL: "https://acme.example/api", Type: "website"},
+		}))
+		Expect(property(api, model.ImageRevision)).To(Equal("0123456789abcdef"))
+		Expect(property(api, model.ImageVersion)).To(Equal("2.1.0"))
+		Expect(property(api, model.ImageCreated)).To(Equal("2024-01-02T03:04:05Z"))
+		Expect(property(api, model.ImageBaseName)).To(Equal("docker.io/library/alpine:3.20"))
+		_, found := api.GetProperty(model.ImageBaseDigest)
+		Expect(found).To(BeFalse())
+	})
+
+	It("should prefer the manifest annotations over the config labels", func() {
+		pushImage(registryHost+"/acme/worker:1.0", map[string]string{
+			registry.AnnotationVendor:   "Old Vendor",
+			registry.AnnotationLicenses: "GPL-2.0-only",
+		}, map[string]string{
+			registry.AnnotationVendor: "Acme Corp",
+		})
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+
+		metadata, err := resolver.Metadata(context.Background(), registryHost+"/acme/worker:1.0")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metadata).To(Equal(registry.ImageMetadata{
+			registry.AnnotationVendor:   "Acme Corp",
+			registry.AnnotationLicenses: "GPL-2.0-only",
+		}))
+	})
+
+	It("should leave images whose metadata cannot be read as they are", func() {
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+		components := []model.Component{newImageComponent(registryHost+"/acme/missing", "1.0", nil)}
+		expected := newImageComponent(registryHost+"/acme/missing", "1.0", nil)
+
+		resolver.Enrich(context.Background(), components)
+
+		Expect(components[0]).To(Equal(expected))
+	})
+
+	It("should read the metadata of images pinned by digest from the disk cache", func() {
+		digest := pushImage(registryHost+"/acme/api:2.1.0", map[string]string{
+			registry.AnnotationLicenses: "MIT",
+		}, nil)
+		resolver, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = resolver.Metadata(context.Background(), registryHost+"/acme/api@"+digest.String())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolver.Save()).To(Succeed())
+		registryServer.Close()
+
+		resolver, err = registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		components := []model.Component{newImageComponent(registryHost+"/acme/api", "2.1.0", &digest)}
+		resolver.Enrich(context.Background(), components)
+
+		Expect(components[0].Licenses).To(Equal([]model.License{{Expression: "MIT"}}))
+	})
+})
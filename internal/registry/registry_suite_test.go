@@ -0,0 +1,18 @@
+package registry_test
+
+import (
+	"cluster-codex/internal/config"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRegistry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Registry Suite")
+}
+
+var _ = BeforeSuite(func() {
+	config.ConfigureLogger("info") // Initialize the logger once
+})
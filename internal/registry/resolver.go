@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is the number of registry requests made in parallel
+const DefaultConcurrency = 8
+
+// DefaultCacheTTL is how long a resolved digest is reused before the tag is looked up again, since tags can be moved
+const DefaultCacheTTL = 24 * time.Hour
+
+// Name of the cache file in the cache directory
+const cacheFileName = "registry.json"
+
+// Resolver looks up the digests and metadata of images in their registries. Results are cached in memory for the
+// lifetime of the Resolver and on disk between runs, since a cluster can have hundreds of images.
+type Resolver struct {
+	// Concurrency is the maximum number of registry requests made in parallel by Prefetch
+	Concurrency int
+	// CacheTTL is how long a digest from the disk cache is used
+	CacheTTL time.Duration
+	// Platform is the image whose metadata is read when an image is a multi-platform index
+	Platform v1.Platform
+
+	cachePath string
+	options   []remote.Option
+	lock      sync.Mutex
+	cache     diskCache
+	failed    map[string]struct{} // Images that could not be resolved in this run, so they are not retried until the next one
+	dirty     bool
+}
+
+// diskCache is the content of the cache file
+type diskCache struct {
+	Digests map[string]cacheEntry `json:"digests"`
+	// Metadata is keyed by the image pinned by digest, since it never changes for a digest
+	Metadata map[string]ImageMetadata `json:"metadata"`
+}
+
+type cacheEntry struct {
+	Digest   string    `json:"digest"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// NewResolver creates a Resolver that caches its results in cacheDir, or only in memory when cacheDir is empty.
+// Registries are authenticated with the default keychain, e.g. the docker config, unless options are given.
+func NewResolver(cacheDir string, options ...remote.Option) (*Resolver, error) {
+	if len(options) == 0 {
+		options = []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	}
+	resolver := &Resolver{
+		Concurrency: DefaultConcurrency,
+		CacheTTL:    DefaultCacheTTL,
+		Platform:    DefaultPlatform,
+		options:     options,
+		cache:       newDiskCache(),
+		failed:      make(map[string]struct{}),
+	}
+	if cacheDir == "" {
+		return resolver, nil
+	}
+
+	resolver.cachePath = filepath.Join(cacheDir, cacheFileName)
+	data, err := os.ReadFile(resolver.cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return resolver, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &resolver.cache); err != nil {
+		// A corrupt cache is only a performance problem, so start over
+		log.Warn().Msgf("Ignoring registry cache %s: %v", resolver.cachePath, err)
+		resolver.cache = newDiskCache()
+	}
+	if resolver.cache.Digests == nil {
+		resolver.cache.Digests = make(map[string]cacheEntry)
+	}
+	if resolver.cache.Metadata == nil {
+		resolver.cache.Metadata = make(map[string]ImageMetadata)
+	}
+	return resolver, nil
+}
+
+func newDiskCache() diskCache {
+	return diskCache{
+		Digests:  make(map[string]cacheEntry),
+		Metadata: make(map[string]ImageMetadata),
+	}
+}
+
+// DefaultCacheDir returns the directory the registry results are cached in by default
+func DefaultCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "clx")
+}
+
+// Prefetch resolves the digests of the images that are not cached yet, at most Concurrency at a time. It starts a run,
+// so the images that could not be resolved in the previous one are tried again, e.g. after a transient registry error
+// while serving or watching a cluster.
+func (r *Resolver) Prefetch(ctx context.Context, images []string) {
+	r.lock.Lock()
+	clear(r.failed)
+	r.lock.Unlock()
+	var missing []string
+	for _, image := range images {
+		if _, found := r.cached(image); !found {
+			missing = append(missing, image)
+		}
+	}
+	r.forEach(ctx, missing, func(image string) {
+		r.Digest(ctx, image)
+	})
+}
+
+// forEach calls fn for each distinct image, at most Concurrency at a time
+func (r *Resolver) forEach(ctx context.Context, images []string, fn func(image string)) {
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	seen := make(map[string]struct{})
+	for _, image := range images {
+		if _, exists := seen[image]; exists {
+			continue
+		}
+		seen[image] = struct{}{}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case semaphore <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			fn(image)
+		}()
+	}
+	wg.Wait()
+}
+
+// Digest returns the digest of the image, e.g. "sha256:...", or "" when it cannot be resolved. The digest of an image
+// that is already pinned by digest is returned without a registry request.
+func (r *Resolver) Digest(ctx context.Context, image string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		log.Debug().Msgf("Cannot resolve digest of invalid image %s: %v", image, err)
+		return ""
+	}
+	if digest, isDigest := ref.(name.Digest); isDigest {
+		return digest.DigestStr()
+	}
+	if digest, found := r.cached(image); found {
+		return digest
+	}
+
+	descriptor, err := remote.Head(ref, append([]remote.Option{remote.WithContext(ctx)}, r.options...)...)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil {
+		log.Warn().Msgf("Could not resolve digest of image %s: %v", image, err)
+		r.failed[ref.Name()] = struct{}{}
+		return ""
+	}
+	digest := descriptor.Digest.String()
+	r.cache.Digests[ref.Name()] = cacheEntry{Digest: digest, Resolved: time.Now()}
+	r.dirty = true
+	log.Debug().Msgf("Resolved digest of image %s: %s", image, digest)
+	return digest
+}
+
+// cached returns the cached digest of the image. Images that already failed in this run are reported as cached with
+// an empty digest.
+func (r *Resolver) cached(image string) (string, bool) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, failed := r.failed[ref.Name()]; failed {
+		return "", true
+	}
+	entry, found := r.cache.Digests[ref.Name()]
+	if !found || time.Since(entry.Resolved) > r.CacheTTL {
+		return "", false
+	}
+	return entry.Digest, true
+}
+
+// Save writes the digests and metadata fetched since the Resolver was created to the disk cache
+func (r *Resolver) Save() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cachePath == "" || !r.dirty {
+		return nil
+	}
+	for image, entry := range r.cache.Digests {
+		if time.Since(entry.Resolved) > r.CacheTTL {
+			delete(r.cache.Digests, image)
+		}
+	}
+	data, err := json.MarshalIndent(r.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.cachePath), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a concurrent run never reads a partial cache
+	tmpPath := r.cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, r.cachePath); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
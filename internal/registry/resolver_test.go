@@ -0,0 +1,151 @@
+package registry_test
+
+import (
+	"cluster-codex/internal/registry"
+	"context"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+)
+
+var _ = Describe("Resolver - Unit", Label("unit"), func() {
+	var (
+		registryServer *httptest.Server
+		registryHost   string
+		headRequests   atomic.Int32
+		nginxDigest    string
+		cacheDir       string
+	)
+
+	// pushImage pushes a random image to the in-memory registry and returns its digest. The requests made by the push
+	// are not counted.
+	pushImage := func(image string) string {
+		before := headRequests.Load()
+		defer headRequests.Store(before)
+		img, err := random.Image(256, 1)
+		Expect(err).ToNot(HaveOccurred())
+		ref, err := name.ParseReference(image)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remote.Write(ref, img)).To(Succeed())
+		digest, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		return digest.String()
+	}
+
+	BeforeEach(func() {
+		headRequests.Store(0)
+		handler := ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0)))
+		registryServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead && strings.Contains(r.URL.Path, "/manifests/") {
+				headRequests.Add(1)
+			}
+			handler.ServeHTTP(w, r)
+		}))
+		DeferCleanup(registryServer.Close)
+		registryHost = strings.TrimPrefix(registryServer.URL, "http://")
+		nginxDigest = pushImage(registryHost + "/library/nginx:1.27")
+		cacheDir = GinkgoT().TempDir()
+	})
+
+	It("should resolve the digest of a tag in the registry", func() {
+		resolver, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest := resolver.Digest(context.Background(), registryHost+"/library/nginx:1.27")
+
+		Expect(digest).To(Equal(nginxDigest))
+		Expect(headRequests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should return the digest of an image pinned by digest without a request", func() {
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+		pinned := "sha256:0a399eb16751829e1af26fea27b20c3ec28d7ab1fb72182879dcae1cca21206a"
+
+		Expect(resolver.Digest(context.Background(), registryHost+"/library/nginx@"+pinned)).To(Equal(pinned))
+		Expect(headRequests.Load()).To(BeZero())
+	})
+
+	It("should return an empty digest for an image that is not in the registry and not retry it", func() {
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resolver.Digest(context.Background(), registryHost+"/library/missing:1.0")).To(BeEmpty())
+		Expect(resolver.Digest(context.Background(), registryHost+"/library/missing:1.0")).To(BeEmpty())
+		Expect(headRequests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should retry the images that could not be resolved in the next run", func() {
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+		image := registryHost + "/library/redis:7.4"
+		resolver.Prefetch(context.Background(), []string{image})
+		Expect(resolver.Digest(context.Background(), image)).To(BeEmpty())
+		redisDigest := pushImage(image)
+
+		resolver.Prefetch(context.Background(), []string{image})
+
+		Expect(resolver.Digest(context.Background(), image)).To(Equal(redisDigest))
+		Expect(headRequests.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should resolve each image once when prefetching in parallel", func() {
+		var images []string
+		digests := make(map[string]string)
+		for i := 0; i < 10; i++ {
+			image := fmt.Sprintf("%s/team/app-%d:v1", registryHost, i)
+			digests[image] = pushImage(image)
+			images = append(images, image, image)
+		}
+		resolver, err := registry.NewResolver("")
+		Expect(err).ToNot(HaveOccurred())
+		resolver.Concurrency = 3
+
+		resolver.Prefetch(context.Background(), images)
+
+		Expect(headRequests.Load()).To(BeEquivalentTo(10))
+		for image, digest := range digests {
+			Expect(resolver.Digest(context.Background(), image)).To(Equal(digest))
+		}
+		Expect(headRequests.Load()).To(BeEquivalentTo(10))
+	})
+
+	It("should reuse the digests from the disk cache in the next run", func() {
+		resolver, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolver.Digest(context.Background(), registryHost+"/library/nginx:1.27")).To(Equal(nginxDigest))
+		Expect(resolver.Save()).To(Succeed())
+		registryServer.Close()
+
+		nextRun, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(nextRun.Digest(context.Background(), registryHost+"/library/nginx:1.27")).To(Equal(nginxDigest))
+		Expect(headRequests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should look up the tag again once the cached digest has expired", func() {
+		resolver, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolver.Digest(context.Background(), registryHost+"/library/nginx:1.27")).To(Equal(nginxDigest))
+		Expect(resolver.Save()).To(Succeed())
+		movedDigest := pushImage(registryHost + "/library/nginx:1.27")
+
+		nextRun, err := registry.NewResolver(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		nextRun.CacheTTL = 0
+
+		Expect(nextRun.Digest(context.Background(), registryHost+"/library/nginx:1.27")).To(Equal(movedDigest))
+		Expect(headRequests.Load()).To(BeEquivalentTo(2))
+	})
+})
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"cluster-codex/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics describes the BOM being served and its generation
+type metrics struct {
+	registry           *prometheus.Registry
+	componentsByKind   *prometheus.GaugeVec
+	componentsByNs     *prometheus.GaugeVec
+	images             *prometheus.GaugeVec
+	generationDuration prometheus.Histogram
+	generations        prometheus.Counter
+	generationFailures prometheus.Counter
+	lastSuccess        prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		componentsByKind: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clx_bom_components_by_kind",
+			Help: "Number of components in the BOM per type and kind.",
+		}, []string{"type", "kind"}),
+		componentsByNs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clx_bom_components_by_namespace",
+			Help: "Number of components in the BOM per type and namespace.",
+		}, []string{"type", "namespace"}),
+		images: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clx_bom_images",
+			Help: "Number of namespaces each image version is running in.",
+		}, []string{"image", "version"}),
+		generationDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "clx_bom_generation_duration_seconds",
+			Help:    "Time taken to generate the BOM.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+		generations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clx_bom_generations_total",
+			Help: "Number of BOM generations.",
+		}),
+		generationFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clx_bom_generation_failures_total",
+			Help: "Number of BOM generations that failed.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "clx_bom_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful BOM generation.",
+		}),
+	}
+	m.registry.MustRegister(m.componentsByKind, m.componentsByNs, m.images, m.generationDuration, m.generations,
+		m.generationFailures, m.lastSuccess)
+	return m
+}
+
+// update replaces the component counts with those of the BOM, so components that are gone are no longer reported
+func (m *metrics) update(bom *model.BOM) {
+	m.componentsByKind.Reset()
+	m.componentsByNs.Reset()
+	m.images.Reset()
+	for _, component := range bom.Components {
+		m.componentsByKind.WithLabelValues(component.Type, component.GetKind()).Inc()
+		namespaces := []string{""} // Cluster scoped
+		if property, found := component.GetPropertyObject(model.ComponentNamespace); found && len(property.Values) > 0 {
+			namespaces = property.Values
+		}
+		for _, namespace := range namespaces {
+			m.componentsByNs.WithLabelValues(component.Type, namespace).Inc()
+		}
+		if component.Type == "container" {
+			m.images.WithLabelValues(component.Name, component.Version).Add(float64(len(namespaces)))
+		}
+	}
+	m.lastSuccess.SetToCurrentTime()
+}
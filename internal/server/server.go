@@ -0,0 +1,216 @@
+package server
+
+import (
+	"cluster-codex/internal/model"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats served by /bom
+const (
+	FormatCycloneDXJSON = "cyclonedx-json"
+	FormatCSV           = "csv"
+)
+
+// DefaultInterval is how often the BOM is regenerated
+const DefaultInterval = 5 * time.Minute
+
+// GenerateFunc generates a new BOM of the cluster
+type GenerateFunc func(ctx context.Context) (*model.BOM, error)
+
+// Server serves the latest BOM of the cluster over HTTP and regenerates it periodically
+type Server struct {
+	generate GenerateFunc
+	// Interval is how long to wait between generations
+	Interval time.Duration
+
+	lock          sync.RWMutex
+	bom           *model.BOM
+	lastGenerated time.Time
+	metrics       *metrics
+}
+
+// New creates a Server that calls generate to get the BOM
+func New(generate GenerateFunc) *Server {
+	return &Server{
+		generate: generate,
+		Interval: DefaultInterval,
+		metrics:  newMetrics(),
+	}
+}
+
+// Run generates the BOM immediately, then again every Interval until the context is cancelled
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("Error generating BOM, serving the previous one")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh generates a new BOM and serves it if the generation succeeds. The previous BOM is kept on failure.
+func (s *Server) Refresh(ctx context.Context) error {
+	start := time.Now()
+	bom, err := s.generate(ctx)
+	s.metrics.generationDuration.Observe(time.Since(start).Seconds())
+	s.metrics.generations.Inc()
+	if err != nil {
+		s.metrics.generationFailures.Inc()
+		return err
+	}
+
+	s.lock.Lock()
+	s.bom = bom
+	s.lastGenerated = time.Now()
+	s.lock.Unlock()
+	s.metrics.update(bom)
+	log.Info().Msgf("Serving BOM with %d components generated in %s", len(bom.Components), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /bom", s.handleBOM)
+	mux.HandleFunc("GET /components", s.handleComponents)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// currentBOM returns the BOM being served, or nil before the first successful generation
+func (s *Server) currentBOM() *model.BOM {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.bom
+}
+
+func (s *Server) handleBOM(w http.ResponseWriter, r *http.Request) {
+	bom := s.currentBOM()
+	if bom == nil {
+		http.Error(w, "BOM has not been generated yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", FormatCycloneDXJSON:
+		writeJson(w, bom)
+	case FormatCSV:
+		writeCSV(w, bom.Components)
+	default:
+		http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+	}
+}
+
+// handleComponents returns the components matching the kind, namespace, name and type query parameters. Images
+// have the kind "Image", which is the default when only containers are requested.
+func (s *Server) handleComponents(w http.ResponseWriter, r *http.Request) {
+	bom := s.currentBOM()
+	if bom == nil {
+		http.Error(w, "BOM has not been generated yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	namespace := query.Get("namespace")
+	name := query.Get("name")
+	componentType := query.Get("type")
+	if kind == "" && componentType == "container" {
+		kind = "Image"
+	}
+	if kind == "" {
+		http.Error(w, "kind is required", http.StatusBadRequest)
+		return
+	}
+
+	components := make([]model.Component, 0)
+	switch componentType {
+	case "application":
+		components = append(components, bom.FindApplications(name, kind, namespace)...)
+	case "container":
+		components = append(components, bom.FindContainers(name, kind, namespace)...)
+	case "platform":
+		components = append(components, bom.FindPlatforms(name, kind, namespace)...)
+	case "":
+		components = append(components, bom.FindApplications(name, kind, namespace)...)
+		components = append(components, bom.FindContainers(name, kind, namespace)...)
+		components = append(components, bom.FindPlatforms(name, kind, namespace)...)
+	default:
+		http.Error(w, "unsupported type: "+componentType, http.StatusBadRequest)
+		return
+	}
+	writeJson(w, components)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	s.lock.RLock()
+	lastGenerated := s.lastGenerated
+	s.lock.RUnlock()
+	if lastGenerated.IsZero() {
+		http.Error(w, "BOM has not been generated yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJson(w, map[string]string{"lastGenerated": lastGenerated.UTC().Format(time.RFC3339)})
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		log.Err(err).Msg("Error writing response")
+	}
+}
+
+func writeCSV(w http.ResponseWriter, components []model.Component) {
+	w.Header().Set("Content-Type", "text/csv")
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"type", "kind", "name", "namespace", "version", "purl"})
+	for _, component := range components {
+		namespaces := ""
+		if property, found := component.GetPropertyObject(model.ComponentNamespace); found {
+			namespaces = strings.Join(property.Values, " ")
+		}
+		_ = writer.Write([]string{component.Type, component.GetKind(), component.Name, namespaces, component.Version, component.PackageURL})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Err(err).Msg("Error writing response")
+	}
+}
+
+// ListenAndServe serves the API on the address until the context is cancelled
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	httpServer := &http.Server{Addr: address, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Err(err).Msg("Error shutting down server")
+		}
+	}()
+
+	log.Info().Msgf("Serving BOM on %s", address)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package server_test
+
+import (
+	"cluster-codex/internal/config"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Server Suite")
+}
+
+var _ = BeforeSuite(func() {
+	config.ConfigureLogger("info") // Initialize the logger once
+})
@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"cluster-codex/internal/model"
+	"cluster-codex/internal/server"
+	"context"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func newTestBOM() *model.BOM {
+	bom := model.NewBOM()
+	web := model.Component{Type: "application", Name: "web", Version: "apps/v1", PackageURL: "pkg:k8s/Deployment/web?apiVersion=apps%2Fv1&namespace=shop"}
+	web.AddProperty(model.ComponentKind, "Deployment")
+	web.AddProperty(model.ComponentNamespace, "shop")
+	api := model.Component{Type: "application", Name: "api", Version: "apps/v1", PackageURL: "pkg:k8s/Deployment/api?apiVersion=apps%2Fv1&namespace=payments"}
+	api.AddProperty(model.ComponentKind, "Deployment")
+	api.AddProperty(model.ComponentNamespace, "payments")
+	nginx := model.Component{Type: "container", Name: "index.docker.io/library/nginx", Version: "1.27", PackageURL: "pkg:oci/library/nginx?namespace=shop"}
+	nginx.AddProperty(model.ComponentKind, "Image")
+	nginx.AddPropertyMultipleValue(model.ComponentNamespace, "shop", "payments")
+	bom.Components = []model.Component{web, api, nginx}
+	return bom
+}
+
+var _ = Describe("Server - Unit", Label("unit"), func() {
+	var (
+		bomServer   *server.Server
+		httpServer  *httptest.Server
+		generateErr error
+	)
+
+	get := func(path string) (int, string) {
+		response, err := http.Get(httpServer.URL + path)
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return response.StatusCode, string(body)
+	}
+
+	BeforeEach(func() {
+		generateErr = nil
+		bomServer = server.New(func(ctx context.Context) (*model.BOM, error) {
+			if generateErr != nil {
+				return nil, generateErr
+			}
+			return newTestBOM(), nil
+		})
+		httpServer = httptest.NewServer(bomServer.Handler())
+		DeferCleanup(httpServer.Close)
+	})
+
+	It("should return service unavailable before the first generation", func() {
+		status, _ := get("/bom")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		status, _ = get("/healthz")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	Context("when the BOM has been generated", func() {
+		BeforeEach(func() {
+			Expect(bomServer.Refresh(context.Background())).To(Succeed())
+		})
+
+		It("should serve the BOM as CycloneDX JSON by default", func() {
+			status, body := get("/bom")
+			Expect(status).To(Equal(http.StatusOK))
+			var bom model.BOM
+			Expect(json.Unmarshal([]byte(body), &bom)).To(Succeed())
+			Expect(bom.Components).To(HaveLen(3))
+		})
+
+		It("should serve the BOM as CSV", func() {
+			status, body := get("/bom?format=csv")
+			Expect(status).To(Equal(http.StatusOK))
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			Expect(lines).To(HaveLen(4))
+			Expect(lines[0]).To(Equal("type,kind,name,namespace,version,purl"))
+			Expect(lines[3]).To(HavePrefix("container,Image,index.docker.io/library/nginx,shop payments,1.27,"))
+		})
+
+		It("should reject an unknown format", func() {
+			status, _ := get("/bom?format=spdx")
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		DescribeTable("should return the components matching the query",
+			func(query string, expectedNames []string) {
+				status, body := get("/components?" + query)
+				Expect(status).To(Equal(http.StatusOK))
+				var components []model.Component
+				Expect(json.Unmarshal([]byte(body), &components)).To(Succeed())
+				var names []string
+				for _, component := range components {
+					names = append(names, component.Name)
+				}
+				Expect(names).To(Equal(expectedNames))
+			},
+			Entry("by kind", "kind=Deployment", []string{"web", "api"}),
+			Entry("by kind and namespace", "kind=Deployment&namespace=payments", []string{"api"}),
+			Entry("by kind and name", "kind=Deployment&name=web", []string{"web"}),
+			Entry("containers without a kind", "type=container&namespace=shop", []string{"index.docker.io/library/nginx"}),
+			Entry("nothing matches", "kind=StatefulSet", nil),
+		)
+
+		It("should require a kind for applications", func() {
+			status, _ := get("/components?namespace=shop")
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should expose the component counts and generation metrics", func() {
+			status, body := get("/metrics")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring(`clx_bom_components_by_kind{kind="Deployment",type="application"} 2`))
+			Expect(body).To(ContainSubstring(`clx_bom_components_by_namespace{namespace="shop",type="container"} 1`))
+			Expect(body).To(ContainSubstring(`clx_bom_images{image="index.docker.io/library/nginx",version="1.27"} 2`))
+			Expect(body).To(ContainSubstring("clx_bom_generations_total 1"))
+			Expect(body).To(ContainSubstring("clx_bom_generation_failures_total 0"))
+			Expect(body).To(ContainSubstring("clx_bom_generation_duration_seconds_count 1"))
+		})
+
+		It("should keep serving the previous BOM when a generation fails", func() {
+			generateErr = errors.New("cluster is unreachable")
+			Expect(bomServer.Refresh(context.Background())).To(MatchError("cluster is unreachable"))
+
+			status, _ := get("/bom")
+			Expect(status).To(Equal(http.StatusOK))
+			_, body := get("/metrics")
+			Expect(body).To(ContainSubstring("clx_bom_generation_failures_total 1"))
+		})
+	})
+})